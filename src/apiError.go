@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"gopkg.in/mgo.v2"
+)
+
+// APIError is an error answered by the API. Code is stable and meant to be
+// tested by the apps, Message is for the developers and Text is the
+// message to show to the user. It is encoded as the JSON error envelope:
+// {"error": Text, "code": Code, "message": Message, "status": Status}
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Text    string `json:"error"`
+}
+
+func (e *APIError) Error() string {
+	return e.Text
+}
+
+// NewAPIError is the constructor of APIError
+func NewAPIError(status int, code string, message string, text string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message, Text: text}
+}
+
+// Errors shared by the controllers
+var (
+	ErrBadRequest      = NewAPIError(http.StatusBadRequest, "bad_request", "the body of the request is malformed", "Mauvais Format")
+	ErrUnauthenticated = NewAPIError(http.StatusUnauthorized, "unauthenticated", "a valid session token is required", "Authentification requise")
+	ErrWrongLogin      = NewAPIError(http.StatusUnauthorized, "wrong_credentials", "the credentials are wrong", "Identifiants incorrects")
+	ErrForbidden       = NewAPIError(http.StatusForbidden, "forbidden", "the principal is not allowed to do this", "Contenu Protégé")
+	ErrContentNotFound = NewAPIError(http.StatusNotFound, "not_found", "the content does not exist", "Contenu Inexistant")
+	ErrDuplicate       = NewAPIError(http.StatusConflict, "conflict", "the content already exists", "Contenu déjà existant")
+	ErrInternal        = NewAPIError(http.StatusInternalServerError, "internal", "an unexpected error occurred", "Une erreur est survenue")
+)
+
+// ValidationError returns the APIError of a content
+// rejected for the given reason, shown to the user
+func ValidationError(text string) *APIError {
+	return NewAPIError(http.StatusBadRequest, "validation", "the content is invalid", text)
+}
+
+// ConflictError returns the APIError of a content conflicting
+// with an existing one for the given reason, shown to the user
+func ConflictError(text string) *APIError {
+	return NewAPIError(http.StatusConflict, "conflict", "the content conflicts with an existing one", text)
+}
+
+// ToAPIError returns the APIError matching the given error.
+// Unknown errors are logged and hidden behind ErrInternal
+func ToAPIError(err error) *APIError {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr
+	}
+	if err == ErrNotFound || err == mgo.ErrNotFound {
+		return ErrContentNotFound
+	}
+	if mgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	log.Println("[error]", err)
+	return ErrInternal
+}
+
+// WriteError answers the JSON error envelope of the given error
+func WriteError(w http.ResponseWriter, err error) {
+	apiErr := *ToAPIError(err)
+	apiErr.Text = Translate(writerLocale(w), apiErr.Text)
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(apiErr)
+}
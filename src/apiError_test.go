@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestToAPIError(t *testing.T) {
+	if err := ToAPIError(ErrNotFound); err != ErrContentNotFound {
+		t.Fatalf("expected a missing document to be not found, got %+v", err)
+	}
+	if err := ToAPIError(ValidationError("Nom obligatoire")); err.Status != http.StatusBadRequest || err.Text != "Nom obligatoire" {
+		t.Fatalf("expected the validation error to be kept, got %+v", err)
+	}
+	if err := ToAPIError(errors.New("connection reset")); err != ErrInternal {
+		t.Fatalf("expected an unknown error to be hidden, got %+v", err)
+	}
+}
+
+func TestWriteErrorEnvelope(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteError(w, ErrForbidden)
+	var envelope map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&envelope); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusForbidden || envelope["code"] != "forbidden" || envelope["error"] != "Contenu Protégé" ||
+		envelope["status"] != float64(http.StatusForbidden) || envelope["message"] == "" {
+		t.Fatalf("unexpected envelope %d %v", w.Code, envelope)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	apnsProductionHost = "https://api.push.apple.com"
+	apnsSandboxHost    = "https://api.sandbox.push.apple.com"
+	// apnsTokenLifetime is how long a provider token is used. Apple
+	// refuses the ones older than an hour, or renewed more than every 20 minutes
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsProvider is the PushProvider of iOS, sending each push with the
+// HTTP/2 API of the Apple Push Notification service. It is authenticated
+// by a provider token signed with the .p8 key of the team
+type APNsProvider struct {
+	Host   string
+	KeyID  string
+	TeamID string
+	Topic  string
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	mutex    sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// apnsError is the error answered by APNs
+type apnsError struct {
+	Reason string `json:"reason"`
+}
+
+// NewAPNsProvider returns the APNsProvider signing its tokens with the given
+// .p8 key file and sending to the given topic, the bundle id of the app.
+// The pushes are sent to the sandbox environment if sandbox is set
+func NewAPNsProvider(keyFile string, keyID string, teamID string, topic string, sandbox bool) (*APNsProvider, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("apns: no private key in " + keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apns: the private key is not an ECDSA key")
+	}
+	host := apnsProductionHost
+	if sandbox {
+		host = apnsSandboxHost
+	}
+	return &APNsProvider{
+		Host:   host,
+		KeyID:  keyID,
+		TeamID: teamID,
+		Topic:  topic,
+		key:    key,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Send pushes the notification of the given job to each of its
+// devices, with the number of unread notifications as badge
+func (p *APNsProvider) Send(job PushJob) PushResult {
+	return sendEach(job, func(recipient PushRecipient) (pushOutcome, error) {
+		token, err := p.providerToken("")
+		if err != nil {
+			return pushRetry, err
+		}
+		outcome, err := p.send(job.Notification, recipient, token)
+		if err == errExpiredToken {
+			if token, err = p.providerToken(token); err != nil {
+				return pushRetry, err
+			}
+			outcome, err = p.send(job.Notification, recipient, token)
+		}
+		return outcome, err
+	})
+}
+
+func (p *APNsProvider) send(notification Notification, recipient PushRecipient, token string) (pushOutcome, error) {
+	data := pushData(notification, recipient)
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": data["message"],
+			"badge": recipient.Badge,
+			"sound": "bingbong.aiff",
+		},
+	}
+	for key, value := range data {
+		payload[key] = value
+	}
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequest("POST", p.Host+"/3/device/"+recipient.Token, bytes.NewReader(body))
+	if err != nil {
+		return pushFailed, err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apns-topic", p.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return pushRetry, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return pushDelivered, nil
+	}
+
+	var answer apnsError
+	json.NewDecoder(resp.Body).Decode(&answer)
+	err = fmt.Errorf("apns answered %s %s", resp.Status, answer.Reason)
+	switch {
+	case resp.StatusCode == http.StatusForbidden && answer.Reason == "ExpiredProviderToken":
+		return pushRetry, errExpiredToken
+	case resp.StatusCode == http.StatusGone, answer.Reason == "BadDeviceToken", answer.Reason == "DeviceTokenNotForTopic":
+		return pushUnregistered, err
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return pushRetry, err
+	}
+	return pushFailed, err
+}
+
+// providerToken returns the provider token signed with the key of the team,
+// signing a new one if it is about to expire or if it is the given refused
+// one. A token refused after another push renewed it is not renewed again,
+// as APNs refuses the tokens renewed too often
+func (p *APNsProvider) providerToken(refused string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.token != "" && p.token != refused && time.Since(p.issuedAt) < apnsTokenLifetime {
+		return p.token, nil
+	}
+
+	now := time.Now()
+	token, err := signJWT(
+		map[string]interface{}{"alg": "ES256", "kid": p.KeyID},
+		map[string]interface{}{"iss": p.TeamID, "iat": now.Unix()},
+		func(data []byte) ([]byte, error) {
+			hash := sha256.Sum256(data)
+			r, s, err := ecdsa.Sign(rand.Reader, p.key, hash[:])
+			if err != nil {
+				return nil, err
+			}
+			// ES256 signatures are the two 32 bytes integers, not their ASN.1
+			signature := make([]byte, 64)
+			r.FillBytes(signature[:32])
+			s.FillBytes(signature[32:])
+			return signature, nil
+		})
+	if err != nil {
+		return "", err
+	}
+	p.token = token
+	p.issuedAt = now
+	return token, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestAPNs starts a stub APNs server and returns an APNsProvider sending
+// to it. The stub answers the pushes according to their device token
+func newTestAPNs(t *testing.T) *APNsProvider {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("apns-topic") != "fr.insapp" || !strings.HasPrefix(r.Header.Get("Authorization"), "bearer ") {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/3/device/") {
+		case "gone":
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"reason": "Unregistered"}`))
+		case "bad":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"reason": "BadDeviceToken"}`))
+		case "busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"reason": "TooManyRequests"}`))
+		default:
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			if aps, _ := payload["aps"].(map[string]interface{}); aps["badge"] != float64(3) || payload["comment"] == "" {
+				t.Errorf("unexpected payload %v", payload)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return &APNsProvider{Host: server.URL, KeyID: "KEY", TeamID: "TEAM", Topic: "fr.insapp", key: key, client: server.Client()}
+}
+
+func TestAPNsProviderSend(t *testing.T) {
+	provider := newTestAPNs(t)
+	result := provider.Send(newTestPushJobFor("iOS", "ok"))
+	expectPushResult(t, result, nil, nil)
+	expectPushResult(t, provider.Send(newTestPushJobFor("iOS", "gone")), nil, []string{"gone"})
+	expectPushResult(t, provider.Send(newTestPushJobFor("iOS", "bad")), nil, []string{"bad"})
+	expectPushResult(t, provider.Send(newTestPushJobFor("iOS", "busy")), []string{"busy"}, nil)
+}
+
+func TestAPNsProviderReusesToken(t *testing.T) {
+	provider := newTestAPNs(t)
+	first, err := provider.providerToken("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts := strings.Split(first, "."); len(parts) != 3 {
+		t.Fatalf("expected a JWT, got %q", first)
+	}
+	if second, _ := provider.providerToken(""); second != first {
+		t.Fatal("expected the provider token to be reused")
+	}
+}
+
+func TestAPNsProviderRenewsRefusedTokenOnce(t *testing.T) {
+	provider := newTestAPNs(t)
+	first, _ := provider.providerToken("")
+	second, err := provider.providerToken(first)
+	if err != nil || second == first {
+		t.Fatalf("expected the refused token to be renewed, got %v", err)
+	}
+	if third, _ := provider.providerToken(first); third != second {
+		t.Fatal("expected a token refused after its renewal not to be renewed again")
+	}
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"gopkg.in/mgo.v2"
+	"strings"
+
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -19,179 +20,203 @@ type Association struct {
 	Cover	    	string          `json:"cover"`
 	BgColor     string          `json:"bgcolor"`
 	FgColor     string          `json:"fgcolor"`
+	Followers   int             `json:"followers"`
+	// Official associations may broadcast their posts and events to every user
+	Official    bool            `json:"official"`
 }
 
 // Associations is an array of Association
 type Associations []Association
 
-func AddAssociationUser(user AssociationUser) {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association_user")
-	db.Insert(user)
+func AddAssociationUser(user AssociationUser) error {
+	return store.InsertAssociationUser(user)
 }
 
 // AddAssociation will add the given association to the database
-func AddAssociation(association Association) Association {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association")
-	db.Insert(association)
-	var result Association
-	db.Find(bson.M{"name": association.Name}).One(&result)
-	return result
+func AddAssociation(association Association) (Association, error) {
+	if len(association.Name) == 0 || len(association.Email) == 0 {
+		return Association{}, ValidationError("Le nom et l'email de l'association sont obligatoires")
+	}
+	associations, err := store.FindAssociations(Page{})
+	if err != nil {
+		return Association{}, err
+	}
+	for _, existing := range associations {
+		if strings.EqualFold(existing.Name, association.Name) {
+			return Association{}, ConflictError("Une association porte déjà ce nom")
+		}
+	}
+	association.ID = bson.NewObjectId()
+	association.Followers = 0
+	if err := store.InsertAssociation(association); err != nil {
+		return Association{}, err
+	}
+	return store.FindAssociation(association.ID)
 }
 
 // UpdateAssociation will update the given association link to the given ID,
 // with the field of the given association, in the database
-func UpdateAssociation(id bson.ObjectId, association Association) Association {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association")
-	assosID := bson.M{"_id": id}
-	change := bson.M{"$set": bson.M{
-		"name":        		association.Name,
-		"email":       		association.Email,
-		"description": 		association.Description,
-		"profile":     		association.Profile,
-		"cover":     	 		association.Cover,
-		"palette":		 		association.Palette,
-		"selectedcolor":	association.SelectedColor,
-		"bgcolor":     		association.BgColor,
-		"fgcolor":     		association.FgColor,
-	}}
-	db.Update(assosID, change)
-	var result Association
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
+func UpdateAssociation(id bson.ObjectId, association Association) (Association, error) {
+	if err := store.UpdateAssociation(id, association); err != nil {
+		return Association{}, err
+	}
+	return store.FindAssociation(id)
 }
 
 // DeleteAssociation will delete the given association from the database
-func DeleteAssociation(id bson.ObjectId) Association {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association")
-	association := GetAssociation(id)
+func DeleteAssociation(id bson.ObjectId) error {
+	association, err := GetAssociation(id)
+	if err != nil {
+		return err
+	}
 	for _, eventId := range association.Events {
-		DeleteEvent(GetEvent(eventId))
+		event, err := GetEvent(eventId)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := DeleteEvent(event); err != nil {
+			return err
+		}
 	}
 	for _, postId := range association.Posts {
-		DeletePost(GetPost(postId))
+		post, err := GetPost(postId)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := DeletePost(post); err != nil {
+			return err
+		}
+	}
+	DeleteMembersForAssociation(id)
+	if err := store.RemoveFollowingForAssociation(id); err != nil {
+		return err
 	}
-	db.RemoveId(id)
-	var result Association
-	db.FindId(id).One(result)
-	return result
+	RevokeSessionTokensForAssociation(id)
+	return store.RemoveAssociation(id)
 }
 
 // GetAssociation will return an Association object from the given ID
-func GetAssociation(id bson.ObjectId) Association {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association")
-	var result Association
-	db.FindId(id).One(&result)
-	return result
-}
-
-// GetAllAssociation will return an array of all the existing Association
-func GetAllAssociation() Associations {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association")
-	var result Associations
-	db.Find(bson.M{}).All(&result)
-	return result
-}
-
-func GetMyAssociations(id bson.ObjectId) []bson.ObjectId {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association_user")
-	var result []AssociationUser
-	db.Find(bson.M{"owner": id}).All(&result)
+func GetAssociation(id bson.ObjectId) (Association, error) {
+	return store.FindAssociation(id)
+}
+
+// GetAllAssociation will return the given page of the existing
+// Association and the cursor of the next page if there is one
+func GetAllAssociation(page Page) (Associations, *Cursor, error) {
+	associations, err := store.FindAssociations(page.peek())
+	if err != nil || !page.more(len(associations)) {
+		return associations, nil, err
+	}
+	associations = associations[:page.Limit]
+	return associations, &Cursor{ID: associations[len(associations)-1].ID}, nil
+}
+
+// GetMyAssociations will return the associations owned by the given
+// id, and the ones the user linked to it is a member of
+func GetMyAssociations(id bson.ObjectId) ([]bson.ObjectId, error) {
+	result, err := store.FindAssociationUsersByOwner(id)
+	if err != nil {
+		return nil, err
+	}
 	res := []bson.ObjectId{}
 	for _, asso := range result {
 		res = append(res, asso.Association)
 	}
-	return res
+	members, err := GetMembershipsForUser(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if member.Status == MemberAccepted {
+			res = append(res, member.Association)
+		}
+	}
+	return res, nil
 }
 
 // AddEventToAssociation will add the given event ID to the given association
-func AddEventToAssociation(id bson.ObjectId, event bson.ObjectId) Association {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association")
-	assosID := bson.M{"_id": id}
-	change := bson.M{"$addToSet": bson.M{
-		"events": event,
-	}}
-	db.Update(assosID, change)
-	var result Association
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
+func AddEventToAssociation(id bson.ObjectId, event bson.ObjectId) (Association, error) {
+	if err := store.AddAssociationEvent(id, event); err != nil {
+		return Association{}, err
+	}
+	return store.FindAssociation(id)
 }
 
 // RemoveEventFromAssociation will remove the given event ID from the given association
-func RemoveEventFromAssociation(id bson.ObjectId, event bson.ObjectId) Association {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association")
-	assosID := bson.M{"_id": id}
-	change := bson.M{"$pull": bson.M{
-		"events": event,
-	}}
-	db.Update(assosID, change)
-	var result Association
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
-}
-
-func AddPostToAssociation(id bson.ObjectId, post bson.ObjectId) Association {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association")
-	assosID := bson.M{"_id": id}
-	change := bson.M{"$addToSet": bson.M{
-		"posts": post,
-	}}
-	db.Update(assosID, change)
-	var result Association
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
-}
-
-func RemovePostFromAssociation(id bson.ObjectId, post bson.ObjectId) Association {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association")
-	assosID := bson.M{"_id": id}
-	change := bson.M{"$pull": bson.M{
-		"posts": post,
-	}}
-	db.Update(assosID, change)
-	var result Association
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
-}
-
-func GetAssociationUser(id bson.ObjectId) AssociationUser {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association_user")
-	var result AssociationUser
-	db.Find(bson.M{"association": id}).One(&result)
-	return result
+func RemoveEventFromAssociation(id bson.ObjectId, event bson.ObjectId) (Association, error) {
+	if err := store.RemoveAssociationEvent(id, event); err != nil {
+		return Association{}, err
+	}
+	return store.FindAssociation(id)
+}
+
+func AddPostToAssociation(id bson.ObjectId, post bson.ObjectId) (Association, error) {
+	if err := store.AddAssociationPost(id, post); err != nil {
+		return Association{}, err
+	}
+	return store.FindAssociation(id)
+}
+
+func RemovePostFromAssociation(id bson.ObjectId, post bson.ObjectId) (Association, error) {
+	if err := store.RemoveAssociationPost(id, post); err != nil {
+		return Association{}, err
+	}
+	return store.FindAssociation(id)
+}
+
+func GetAssociationUser(id bson.ObjectId) (AssociationUser, error) {
+	return store.FindAssociationUser(id)
+}
+
+// FollowAssociation will add the association to the ones followed by the user,
+// who will be notified of its posts and events. Following twice does nothing
+func FollowAssociation(id bson.ObjectId, userID bson.ObjectId) (Association, User, error) {
+	if _, err := store.FindAssociation(id); err != nil {
+		return Association{}, User{}, err
+	}
+	err := store.AddUserFollowing(userID, id)
+	if err == nil {
+		err = store.IncAssociationFollowers(id, 1)
+	} else if err == ErrNotFound {
+		_, err = store.FindUser(userID)
+	}
+	if err != nil {
+		return Association{}, User{}, err
+	}
+	return getAssociationAndUser(id, userID)
+}
+
+// UnfollowAssociation will remove the association from the ones followed
+// by the user. Unfollowing an association not followed does nothing
+func UnfollowAssociation(id bson.ObjectId, userID bson.ObjectId) (Association, User, error) {
+	err := store.RemoveUserFollowing(userID, id)
+	if err == nil {
+		err = store.IncAssociationFollowers(id, -1)
+	} else if err == ErrNotFound {
+		_, err = store.FindUser(userID)
+	}
+	if err != nil {
+		return Association{}, User{}, err
+	}
+	return getAssociationAndUser(id, userID)
+}
+
+// GetFollowers will return the ids of the users following the given association
+func GetFollowers(id bson.ObjectId) ([]bson.ObjectId, error) {
+	return store.FindFollowers(id)
+}
+
+func getAssociationAndUser(id bson.ObjectId, userID bson.ObjectId) (Association, User, error) {
+	association, err := store.FindAssociation(id)
+	if err != nil {
+		return Association{}, User{}, err
+	}
+	user, err := store.FindUser(userID)
+	return association, user, err
 }
@@ -5,14 +5,17 @@ import (
 	"net/http"
 
 	"gopkg.in/mgo.v2/bson"
-	"github.com/freehaha/token-auth"
 	"github.com/gorilla/mux"
 )
 
 func GetMyAssociationController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	assocationID := vars["id"]
-	var res = GetMyAssociations(bson.ObjectIdHex(assocationID))
+	res, err := GetMyAssociations(bson.ObjectIdHex(assocationID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -21,36 +24,70 @@ func GetMyAssociationController(w http.ResponseWriter, r *http.Request) {
 func GetAssociationController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	assocationID := vars["id"]
-	var res = GetAssociation(bson.ObjectIdHex(assocationID))
+	res, err := GetAssociation(bson.ObjectIdHex(assocationID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(res)
 }
 
-// GetAllAssociationsController will answer a JSON of all associations
+// GetAllAssociationsController will answer a JSON of the associations,
+// or of a page of them if the request has a limit or a cursor
 func GetAllAssociationsController(w http.ResponseWriter, r *http.Request) {
-	var res = GetAllAssociation()
+	page, err := ParseOptionalPage(r, 100)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	res, next, err := GetAllAssociation(page)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	SetNextLink(w, r, page, next)
 	json.NewEncoder(w).Encode(res)
 }
 
 func CreateUserForAssociationController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	assocationID := vars["id"]
-	var res = GetAssociation(bson.ObjectIdHex(assocationID))
 
 	decoder := json.NewDecoder(r.Body)
 	var user AssociationUser
-	decoder.Decode(&user)
+	if err := decoder.Decode(&user); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
 
 	isValid := VerifyAssociationRequest(r, bson.ObjectIdHex(assocationID))
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
 		return
 	}
 
+	res, err := GetAssociation(bson.ObjectIdHex(assocationID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	user.Association = res.ID
 	user.Username = res.Email
-	user.Password = GetMD5Hash(user.Password)
-	AddAssociationUser(user)
+	if err := ValidatePasswordStrength(user.Username, user.Password); err != nil {
+		WriteError(w, err)
+		return
+	}
+	hash, algorithm, err := HashPassword(user.Password)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	user.Password = hash
+	user.Algorithm = algorithm
+	if err := AddAssociationUser(user); err != nil {
+		WriteError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -59,14 +96,20 @@ func CreateUserForAssociationController(w http.ResponseWriter, r *http.Request)
 func AddAssociationController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var association Association
-	decoder.Decode(&association)
+	if err := decoder.Decode(&association); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
 	isValid := VerifyAssociationRequest(r, association.ID)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
+		return
+	}
+	res, err := AddAssociation(association)
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
-	res := AddAssociation(association)
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -75,16 +118,31 @@ func AddAssociationController(w http.ResponseWriter, r *http.Request) {
 func UpdateAssociationController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var association Association
-	decoder.Decode(&association)
+	if err := decoder.Decode(&association); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
 	vars := mux.Vars(r)
 	assocationID := vars["id"]
 	isValid := VerifyAssociationRequest(r, bson.ObjectIdHex(assocationID))
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
+		return
+	}
+	if !GetPrincipal(r).Can(PermissionManageAssociations) {
+		existing, err := GetAssociation(bson.ObjectIdHex(assocationID))
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		association.Official = existing.Official
+	}
+	res, err := UpdateAssociation(bson.ObjectIdHex(assocationID), association)
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
-	res := UpdateAssociation(bson.ObjectIdHex(assocationID), association)
+	RecordActivity(res.ID, GetPrincipal(r).ID, "association:update", res.ID)
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -95,20 +153,56 @@ func DeleteAssociationController(w http.ResponseWriter, r *http.Request) {
 	assoID := vars["id"]
 	isValid := VerifyAssociationRequest(r, bson.ObjectIdHex(assoID))
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
 		return
 	}
-	res := DeleteAssociation(bson.ObjectIdHex(assoID))
-	json.NewEncoder(w).Encode(res)
+	if err := DeleteAssociation(bson.ObjectIdHex(assoID)); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(Association{})
 }
 
+// VerifyAssociationRequest tells whether the request has been made on behalf
+// of the given association, or by a principal managing every association
 func VerifyAssociationRequest(r *http.Request, associationId bson.ObjectId) bool {
-	token := tauth.Get(r)
-	id := token.Claims("id").(string)
-	if bson.ObjectIdHex(id) != associationId {
-		result := GetAssociationUser(bson.ObjectIdHex(id))
-		return result.Master
+	return GetPrincipal(r).CanManageAssociation(associationId)
+}
+
+// FollowAssociationController will answer the JSON of the association
+// and of the user who now follows it
+func FollowAssociationController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	associationID := bson.ObjectIdHex(vars["id"])
+	userID := bson.ObjectIdHex(vars["userID"])
+	isValid := VerifyUserRequest(r, userID)
+	if !isValid {
+		Forbidden(w)
+		return
+	}
+	association, user, err := FollowAssociation(associationID, userID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"association": association, "user": user})
+}
+
+// UnfollowAssociationController will answer the JSON of the association
+// and of the user who does not follow it anymore
+func UnfollowAssociationController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	associationID := bson.ObjectIdHex(vars["id"])
+	userID := bson.ObjectIdHex(vars["userID"])
+	isValid := VerifyUserRequest(r, userID)
+	if !isValid {
+		Forbidden(w)
+		return
+	}
+	association, user, err := UnfollowAssociation(associationID, userID)
+	if err != nil {
+		WriteError(w, err)
+		return
 	}
-	return true
+	json.NewEncoder(w).Encode(bson.M{"association": association, "user": user})
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFollowAssociation(t *testing.T) {
+	newTestStore()
+	association := newTestAssociation(t, "BDE")
+	alice, token := newTestUser(t, "alice")
+	bob, _ := newTestUser(t, "bob")
+	url := "/association/" + association.ID.Hex() + "/follow/"
+	expectError(t, serveTestRequest("POST", url+bob.ID.Hex(), "", token), http.StatusForbidden, ErrForbidden.Code)
+	for i := 0; i < 2; i++ {
+		if w := serveTestRequest("POST", url+alice.ID.Hex(), "", token); w.Code != http.StatusOK {
+			t.Fatalf("expected alice to follow the association, got %d", w.Code)
+		}
+	}
+	association, user, err := getAssociationAndUser(association.ID, alice.ID)
+	if err != nil || association.Followers != 1 || len(user.Following) != 1 {
+		t.Fatalf("expected to follow once, got %d followers and %v %v", association.Followers, user.Following, err)
+	}
+	if followers, _ := GetFollowers(association.ID); len(followers) != 1 || followers[0] != alice.ID {
+		t.Fatalf("expected alice to be the only follower, got %v", followers)
+	}
+	for i := 0; i < 2; i++ {
+		if w := serveTestRequest("DELETE", url+alice.ID.Hex(), "", token); w.Code != http.StatusOK {
+			t.Fatalf("expected alice to unfollow the association, got %d", w.Code)
+		}
+	}
+	if association, _, _ = getAssociationAndUser(association.ID, alice.ID); association.Followers != 0 {
+		t.Fatalf("expected no follower left, got %d", association.Followers)
+	}
+}
+
+func TestAudienceIsFollowers(t *testing.T) {
+	newTestStore()
+	association := newTestAssociation(t, "BDE")
+	alice, _ := newTestUser(t, "alice")
+	newTestUser(t, "bob")
+	if _, _, err := FollowAssociation(association.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if users := getAudience(association.ID, false); len(users) != 1 || users[0] != alice.ID {
+		t.Fatalf("expected only alice, got %v", users)
+	}
+	if users := getAudience(association.ID, true); len(users) != 2 {
+		t.Fatalf("expected a broadcast to reach every user, got %v", users)
+	}
+}
+
+func TestDeleteAssociationRemovesFollowing(t *testing.T) {
+	newTestStore()
+	association := newTestAssociation(t, "BDE")
+	alice, _ := newTestUser(t, "alice")
+	if _, _, err := FollowAssociation(association.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteAssociation(association.ID); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := GetUser(alice.ID); len(user.Following) != 0 {
+		t.Fatalf("expected the association not to be followed anymore, got %v", user.Following)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Status of a Broadcast
+const (
+	BroadcastScheduled = "scheduled"
+	BroadcastSending   = "sending"
+	BroadcastSent      = "sent"
+	BroadcastCanceled  = "canceled"
+	// BroadcastFailed is a broadcast of which the recipients could not be
+	// notified, such as when its segment could not be resolved
+	BroadcastFailed = "failed"
+)
+
+// Kinds of the Segment of a Broadcast
+const (
+	SegmentAll       = "all"
+	SegmentPromotion = "promotion"
+	SegmentYear      = "year"
+	SegmentEvent     = "event"
+	SegmentFollowers = "followers"
+)
+
+const (
+	// broadcastInterval is how often the broadcast job looks for due broadcasts
+	broadcastInterval = time.Minute
+	// broadcastMaxLength is the longest message of a Broadcast
+	broadcastMaxLength = 500
+)
+
+// broadcastSignal wakes up the broadcast job when a broadcast is due now
+var broadcastSignal = make(chan struct{}, 1)
+
+// Segment is the users a Broadcast is sent to: every user, the ones of a
+// promotion such as "3INFO", of a study year such as "3", the participants
+// of an event or the followers of an association, of which Value is the ID
+type Segment struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value,omitempty" bson:"value,omitempty"`
+}
+
+// BroadcastStats are the recipients of a Broadcast, counted when it is sent,
+// and the delivery of its notifications, counted when it is read
+type BroadcastStats struct {
+	Users   int `json:"users"`
+	Devices int `json:"devices"`
+	Pending int `json:"pending"`
+	Sent    int `json:"sent"`
+	Failed  int `json:"failed"`
+	Read    int `json:"read"`
+}
+
+// Broadcast defines how to model an announcement notified to a Segment of
+// the users, by an association or a super admin. It is sent at ScheduledAt
+type Broadcast struct {
+	ID          bson.ObjectId  `bson:"_id,omitempty"`
+	Sender      bson.ObjectId  `json:"sender"`
+	Message     string         `json:"message"`
+	Segment     Segment        `json:"segment"`
+	Status      string         `json:"status"`
+	ScheduledAt time.Time      `json:"scheduledat"`
+	CreatedBy   bson.ObjectId  `json:"createdby"`
+	CreatedAt   time.Time      `json:"createdat"`
+	SentAt      time.Time      `json:"sentat,omitempty" bson:"sentat,omitempty"`
+	Stats       BroadcastStats `json:"stats"`
+}
+
+// Broadcasts is an array of Broadcast
+type Broadcasts []Broadcast
+
+// ErrBroadcastSent is returned when canceling a broadcast already sent
+var ErrBroadcastSent = NewAPIError(http.StatusConflict, "broadcast_sent",
+	"the broadcast is not scheduled anymore", "Annonce déjà envoyée")
+
+// AddBroadcast will schedule the given broadcast of the principal, sent right
+// away if it is not scheduled in the future. An association can only target
+// its followers and the participants of its events
+func AddBroadcast(principal Principal, broadcast Broadcast) (Broadcast, error) {
+	broadcast.Message = strings.TrimSpace(broadcast.Message)
+	if broadcast.Message == "" {
+		return Broadcast{}, ValidationError("Le message de l'annonce est obligatoire")
+	}
+	if len([]rune(broadcast.Message)) > broadcastMaxLength {
+		return Broadcast{}, ValidationError("Le message de l'annonce est trop long")
+	}
+	if err := authorizeSegment(principal, broadcast.Segment); err != nil {
+		return Broadcast{}, err
+	}
+	now := time.Now()
+	broadcast.ID = bson.NewObjectId()
+	broadcast.Sender = principal.ID
+	if len(principal.Association) > 0 {
+		broadcast.Sender = principal.Association
+	}
+	broadcast.Status = BroadcastScheduled
+	broadcast.CreatedBy = principal.ID
+	broadcast.CreatedAt = now
+	broadcast.SentAt = time.Time{}
+	broadcast.Stats = BroadcastStats{}
+	if broadcast.ScheduledAt.IsZero() {
+		broadcast.ScheduledAt = now
+	}
+	if err := store.InsertBroadcast(broadcast); err != nil {
+		return Broadcast{}, err
+	}
+	if !broadcast.ScheduledAt.After(now) {
+		select {
+		case broadcastSignal <- struct{}{}:
+		default:
+		}
+	}
+	return broadcast, nil
+}
+
+// PreviewBroadcast will return the number of users and devices
+// the principal would reach by broadcasting to the given segment
+func PreviewBroadcast(principal Principal, segment Segment) (BroadcastStats, error) {
+	if err := authorizeSegment(principal, segment); err != nil {
+		return BroadcastStats{}, err
+	}
+	users, err := segmentUsers(segment)
+	if err != nil {
+		return BroadcastStats{}, err
+	}
+	return countRecipients(users)
+}
+
+// GetBroadcast will return the broadcast of the given ID with its delivery
+// stats. It returns ErrNotFound if it was not sent by the principal
+func GetBroadcast(principal Principal, id bson.ObjectId) (Broadcast, error) {
+	broadcast, err := store.FindBroadcast(id)
+	if err != nil {
+		return Broadcast{}, err
+	}
+	if !canSeeBroadcast(principal, broadcast) {
+		return Broadcast{}, ErrNotFound
+	}
+	if broadcast.Status != BroadcastSent {
+		return broadcast, nil
+	}
+	deliveries, err := store.CountBroadcastDeliveries(broadcast.ID)
+	if err != nil {
+		return Broadcast{}, err
+	}
+	deliveries.Users, deliveries.Devices = broadcast.Stats.Users, broadcast.Stats.Devices
+	broadcast.Stats = deliveries
+	return broadcast, nil
+}
+
+// GetBroadcasts will return a page of the broadcasts of the principal, or of
+// every broadcast for a super admin, latest first, and the cursor of the next
+// page if there is one
+func GetBroadcasts(principal Principal, page Page) (Broadcasts, *Cursor, error) {
+	sender := bson.ObjectId("")
+	if !principal.Can(PermissionBroadcast) {
+		sender = principal.Association
+	}
+	broadcasts, err := store.FindBroadcasts(sender, page.peek())
+	if err != nil || !page.more(len(broadcasts)) {
+		return broadcasts, nil, err
+	}
+	broadcasts = broadcasts[:page.Limit]
+	last := broadcasts[len(broadcasts)-1]
+	return broadcasts, &Cursor{Date: last.CreatedAt, ID: last.ID}, nil
+}
+
+// CancelBroadcast will cancel the given scheduled broadcast of the principal.
+// It returns ErrBroadcastSent if it is being sent or already sent
+func CancelBroadcast(principal Principal, id bson.ObjectId) (Broadcast, error) {
+	broadcast, err := store.FindBroadcast(id)
+	if err != nil {
+		return Broadcast{}, err
+	}
+	if !canSeeBroadcast(principal, broadcast) {
+		return Broadcast{}, ErrNotFound
+	}
+	if err := store.CancelBroadcast(id); err == ErrNotFound {
+		return Broadcast{}, ErrBroadcastSent
+	} else if err != nil {
+		return Broadcast{}, err
+	}
+	broadcast.Status = BroadcastCanceled
+	return broadcast, nil
+}
+
+// SendBroadcasts will run the broadcast job, sending the broadcasts
+// due every broadcastInterval or when one is added for now
+func SendBroadcasts() {
+	for {
+		sendDueBroadcasts(time.Now())
+		select {
+		case <-broadcastSignal:
+		case <-time.After(broadcastInterval):
+		}
+	}
+}
+
+// sendDueBroadcasts will send the broadcasts scheduled before the given date.
+// Each is claimed first, so that it is sent once by concurrent jobs
+func sendDueBroadcasts(now time.Time) {
+	for {
+		broadcast, err := store.ClaimDueBroadcast(now)
+		if err == ErrNotFound {
+			return
+		}
+		if err != nil {
+			log.Println("[error] Failed to claim a broadcast", err)
+			return
+		}
+		sendBroadcast(broadcast)
+	}
+}
+
+// sendBroadcast will notify the users of the segment of the given broadcast,
+// each in the inbox and on their devices, and mark it sent. It marks it
+// failed if its segment can not be resolved or its notifications queued
+func sendBroadcast(broadcast Broadcast) {
+	users, err := segmentUsers(broadcast.Segment)
+	if err == nil {
+		broadcast.Stats, err = countRecipients(users)
+	}
+	if err == nil {
+		notification := Notification{Sender: broadcast.Sender, Content: broadcast.ID, Message: broadcast.Message, Type: "broadcast"}
+		err = EnqueueNotification(notification, users)
+	}
+	broadcast.Status = BroadcastSent
+	if err != nil {
+		log.Println("[error] Failed to send broadcast", broadcast.ID.Hex(), err)
+		broadcast.Status = BroadcastFailed
+	}
+	broadcast.SentAt = time.Now()
+	if err := store.UpdateBroadcast(broadcast); err != nil {
+		log.Println("[error] Failed to update broadcast", broadcast.ID.Hex(), err)
+	}
+}
+
+// authorizeSegment returns ErrForbidden if the principal can not broadcast to
+// the given segment: a super admin can target any, an association only its
+// followers and the participants of its events
+func authorizeSegment(principal Principal, segment Segment) error {
+	switch segment.Kind {
+	case SegmentAll:
+	case SegmentPromotion:
+		if segment.Value == "" || !containsString(promotions, segment.Value) {
+			return ValidationError("Promotion inconnue")
+		}
+	case SegmentYear:
+		if len(yearPromotions(segment.Value)) == 0 {
+			return ValidationError("Année d'étude inconnue")
+		}
+	case SegmentEvent, SegmentFollowers:
+		if !bson.IsObjectIdHex(segment.Value) {
+			return ErrBadRequest
+		}
+	default:
+		return ValidationError("Cible de l'annonce inconnue")
+	}
+	if principal.Can(PermissionBroadcast) {
+		return nil
+	}
+	switch segment.Kind {
+	case SegmentFollowers:
+		if principal.CanManageAssociation(bson.ObjectIdHex(segment.Value)) {
+			return nil
+		}
+	case SegmentEvent:
+		event, err := store.FindEvent(bson.ObjectIdHex(segment.Value))
+		if err != nil {
+			return err
+		}
+		if principal.CanManageAssociation(event.Association) {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
+
+// canSeeBroadcast tells whether the principal sent the given broadcast or is a super admin
+func canSeeBroadcast(principal Principal, broadcast Broadcast) bool {
+	return principal.Can(PermissionBroadcast) || broadcast.CreatedBy == principal.ID ||
+		(len(principal.Association) > 0 && broadcast.Sender == principal.Association)
+}
+
+// yearPromotions returns the promotions of the given study year, such as
+// "1STPI" for "1" or "3INFO" for "3"
+func yearPromotions(year string) []string {
+	result := []string{}
+	if len(year) != 1 || year[0] < '1' || year[0] > '9' {
+		return result
+	}
+	for _, promotion := range promotions {
+		if strings.HasPrefix(promotion, year) {
+			result = append(result, promotion)
+		}
+	}
+	return result
+}
+
+// segmentUsers returns the users of the given segment, each once
+func segmentUsers(segment Segment) ([]bson.ObjectId, error) {
+	var users []bson.ObjectId
+	switch segment.Kind {
+	case SegmentAll:
+		found, err := store.FindUserIDs()
+		if err != nil {
+			return nil, err
+		}
+		users = found
+	case SegmentPromotion, SegmentYear:
+		selected := []string{segment.Value}
+		if segment.Kind == SegmentYear {
+			selected = yearPromotions(segment.Value)
+		}
+		found, err := store.FindUsersByPromotions(selected)
+		if err != nil {
+			return nil, err
+		}
+		users = found
+	case SegmentEvent:
+		event, err := store.FindEvent(bson.ObjectIdHex(segment.Value))
+		if err != nil {
+			return nil, err
+		}
+		users = event.Participants
+	case SegmentFollowers:
+		followers, err := GetFollowers(bson.ObjectIdHex(segment.Value))
+		if err != nil {
+			return nil, err
+		}
+		users = followers
+	}
+	result := []bson.ObjectId{}
+	seen := map[bson.ObjectId]bool{}
+	for _, user := range users {
+		if !seen[user] {
+			seen[user] = true
+			result = append(result, user)
+		}
+	}
+	return result, nil
+}
+
+// countRecipients returns the number of the given users and of their iOS and android devices
+func countRecipients(users []bson.ObjectId) (BroadcastStats, error) {
+	stats := BroadcastStats{Users: len(users)}
+	if len(users) == 0 {
+		return stats, nil
+	}
+	devices, err := store.FindNotificationUsersForUsers(users)
+	if err != nil {
+		return stats, err
+	}
+	stats.Devices = len(filterDevicesByOs("iOS", devices)) + len(filterDevicesByOs("android", devices))
+	return stats, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// GetBroadcastsController will answer a JSON of a page of the broadcasts
+// of the association, or of every broadcast for a super admin
+func GetBroadcastsController(w http.ResponseWriter, r *http.Request) {
+	page, err := ParsePage(r, 30)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	res, next, err := GetBroadcasts(GetPrincipal(r), page)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	SetNextLink(w, r, page, next)
+	json.NewEncoder(w).Encode(res)
+}
+
+// GetBroadcastController will answer a JSON of the broadcast and its delivery stats
+func GetBroadcastController(w http.ResponseWriter, r *http.Request) {
+	res, err := GetBroadcast(GetPrincipal(r), bson.ObjectIdHex(mux.Vars(r)["id"]))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// AddBroadcastController will answer a JSON of the broadcast of
+// the JSON body, sent now or at its "scheduledat" date
+func AddBroadcastController(w http.ResponseWriter, r *http.Request) {
+	var broadcast Broadcast
+	if err := json.NewDecoder(r.Body).Decode(&broadcast); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	res, err := AddBroadcast(GetPrincipal(r), broadcast)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// PreviewBroadcastController will answer a JSON of the number of users
+// and devices the segment of the JSON body would reach
+func PreviewBroadcastController(w http.ResponseWriter, r *http.Request) {
+	var segment Segment
+	if err := json.NewDecoder(r.Body).Decode(&segment); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	stats, err := PreviewBroadcast(GetPrincipal(r), segment)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"users": stats.Users, "devices": stats.Devices})
+}
+
+// CancelBroadcastController will answer a JSON of the canceled broadcast
+func CancelBroadcastController(w http.ResponseWriter, r *http.Request) {
+	res, err := CancelBroadcast(GetPrincipal(r), bson.ObjectIdHex(mux.Vars(r)["id"]))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestAuthorizeSegment(t *testing.T) {
+	newTestStore()
+	association := newTestAssociation(t, "BDE")
+	other := newTestAssociation(t, "BDS")
+	event, err := AddEvent(Event{Name: "Gala", Association: association.ID, DateStart: time.Now(), DateEnd: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := Principal{ID: bson.NewObjectId(), Roles: []Role{RoleSuperAdmin}}
+	bde := Principal{ID: bson.NewObjectId(), Association: association.ID, Roles: []Role{RoleAssociationAdmin}}
+	for _, test := range []struct {
+		principal Principal
+		segment   Segment
+		allowed   bool
+	}{
+		{admin, Segment{Kind: SegmentAll}, true},
+		{admin, Segment{Kind: SegmentPromotion, Value: "3INFO"}, true},
+		{admin, Segment{Kind: SegmentPromotion, Value: "9XYZ"}, false},
+		{admin, Segment{Kind: SegmentYear, Value: "4"}, true},
+		{admin, Segment{Kind: SegmentYear, Value: "0"}, false},
+		{admin, Segment{Kind: "everyone"}, false},
+		{bde, Segment{Kind: SegmentAll}, false},
+		{bde, Segment{Kind: SegmentYear, Value: "3"}, false},
+		{bde, Segment{Kind: SegmentFollowers, Value: association.ID.Hex()}, true},
+		{bde, Segment{Kind: SegmentFollowers, Value: other.ID.Hex()}, false},
+		{bde, Segment{Kind: SegmentFollowers, Value: "bde"}, false},
+		{bde, Segment{Kind: SegmentEvent, Value: event.ID.Hex()}, true},
+	} {
+		if err := authorizeSegment(test.principal, test.segment); (err == nil) != test.allowed {
+			t.Errorf("%v to %+v: expected allowed %v, got %v", test.principal.Roles, test.segment, test.allowed, err)
+		}
+	}
+}
+
+func TestSendDueBroadcasts(t *testing.T) {
+	newTestStore()
+	admin := Principal{ID: bson.NewObjectId(), Roles: []Role{RoleSuperAdmin}}
+	users := map[string]bson.ObjectId{}
+	for username, promotion := range map[string]string{"alice": "3INFO", "bob": "3GM", "carol": "4INFO"} {
+		user, _ := newTestUser(t, username)
+		if _, err := UpdateUser(user.ID, User{Username: username, Promotion: promotion}); err != nil {
+			t.Fatal(err)
+		}
+		if err := CreateOrUpdateNotificationUser(NotificationUser{UserId: user.ID, Token: username, Os: "iOS"}); err != nil {
+			t.Fatal(err)
+		}
+		users[username] = user.ID
+	}
+	if _, err := AddBroadcast(admin, Broadcast{Message: "  ", Segment: Segment{Kind: SegmentAll}}); err == nil {
+		t.Fatal("expected an empty message to be refused")
+	}
+	now := time.Now()
+	third, err := AddBroadcast(admin, Broadcast{Message: "Partiels décalés", Segment: Segment{Kind: SegmentYear, Value: "3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, err := AddBroadcast(admin, Broadcast{Message: "Bonnes vacances", Segment: Segment{Kind: SegmentAll}, ScheduledAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendDueBroadcasts(now.Add(time.Second))
+	sendDueBroadcasts(now.Add(time.Second))
+	third, err = GetBroadcast(admin, third.ID)
+	if err != nil || third.Status != BroadcastSent || third.Stats.Users != 2 || third.Stats.Devices != 2 || third.Stats.Pending != 2 {
+		t.Fatalf("expected the broadcast to be sent once to the third year, got %+v %v", third, err)
+	}
+	if later, _ = GetBroadcast(admin, later.ID); later.Status != BroadcastScheduled {
+		t.Fatalf("expected the broadcast of later to be scheduled, got %s", later.Status)
+	}
+	if _, err := CancelBroadcast(admin, third.ID); err != ErrBroadcastSent {
+		t.Fatalf("expected a sent broadcast not to be canceled, got %v", err)
+	}
+	if later, err = CancelBroadcast(admin, later.ID); err != nil || later.Status != BroadcastCanceled {
+		t.Fatalf("expected the broadcast to be canceled, got %+v %v", later, err)
+	}
+	sendDueBroadcasts(now.Add(2 * time.Hour))
+	if count, _ := GetUnreadCount(users["carol"]); count != 0 {
+		t.Fatalf("expected carol not to be notified, got %d", count)
+	}
+}
+
+func TestBroadcastRoutes(t *testing.T) {
+	newTestStore()
+	_, token := newTestUser(t, "alice")
+	_, admin := newTestUser(t, "admin", RoleSuperAdmin)
+	for _, username := range []string{"bob", "carol"} {
+		user, _ := newTestUser(t, username)
+		CreateOrUpdateNotificationUser(NotificationUser{UserId: user.ID, Token: username, Os: "android"})
+	}
+	expectError(t, serveTestRequest("POST", "/broadcast", `{"message": "Coucou", "segment": {"kind": "all"}}`, token), http.StatusForbidden, ErrForbidden.Code)
+	expectError(t, serveTestRequest("POST", "/broadcast", `{"message": "Coucou", "segment": {"kind": "nobody"}}`, admin), http.StatusBadRequest, "validation")
+	w := serveTestRequest("POST", "/broadcast/preview", `{"kind": "all"}`, admin)
+	var preview BroadcastStats
+	if err := json.NewDecoder(w.Body).Decode(&preview); err != nil || preview.Users != 4 || preview.Devices != 2 {
+		t.Fatalf("expected to preview 4 users and 2 devices, got %d %+v %v", w.Code, preview, err)
+	}
+	w = serveTestRequest("POST", "/broadcast", `{"message": "Coucou", "segment": {"kind": "all"}, "scheduledat": "2099-01-01T00:00:00Z"}`, admin)
+	var broadcast Broadcast
+	if err := json.NewDecoder(w.Body).Decode(&broadcast); err != nil || broadcast.Status != BroadcastScheduled {
+		t.Fatalf("expected the broadcast to be scheduled, got %d %+v %v", w.Code, broadcast, err)
+	}
+	if w := serveTestRequest("DELETE", "/broadcast/"+broadcast.ID.Hex(), "", admin); w.Code != http.StatusOK {
+		t.Fatalf("expected the broadcast to be canceled, got %d", w.Code)
+	}
+	expectError(t, serveTestRequest("DELETE", "/broadcast/"+broadcast.ID.Hex(), "", admin), http.StatusConflict, ErrBroadcastSent.Code)
+}
+
+func TestSendBroadcastReachesUsersWithoutDevice(t *testing.T) {
+	memory := newTestStore()
+	alice, _ := newTestUser(t, "alice")
+	bob, _ := newTestUser(t, "bob")
+	if err := CreateOrUpdateNotificationUser(NotificationUser{UserId: bob.ID, Token: "bob", Os: "android"}); err != nil {
+		t.Fatal(err)
+	}
+	broadcast := Broadcast{ID: bson.NewObjectId(), Message: "Coupure d'eau", Segment: Segment{Kind: SegmentAll}, Status: BroadcastSending, ScheduledAt: time.Now()}
+	if err := memory.InsertBroadcast(broadcast); err != nil {
+		t.Fatal(err)
+	}
+	sendBroadcast(broadcast)
+	broadcast, _ = memory.FindBroadcast(broadcast.ID)
+	if broadcast.Status != BroadcastSent || broadcast.Stats.Users != 2 || broadcast.Stats.Devices != 1 {
+		t.Fatalf("expected the broadcast to reach 2 users and 1 device, got %+v", broadcast)
+	}
+	for _, user := range []User{alice, bob} {
+		if count, _ := GetUnreadCount(user.ID); count != 1 {
+			t.Fatalf("expected %s to have the broadcast in the inbox, got %d", user.Username, count)
+		}
+	}
+}
+
+func TestSendBroadcastFailsOnUnknownSegment(t *testing.T) {
+	memory := newTestStore()
+	newTestUser(t, "alice")
+	segment := Segment{Kind: SegmentEvent, Value: bson.NewObjectId().Hex()}
+	broadcast := Broadcast{ID: bson.NewObjectId(), Message: "Annulé", Segment: segment, Status: BroadcastSending, ScheduledAt: time.Now()}
+	if err := memory.InsertBroadcast(broadcast); err != nil {
+		t.Fatal(err)
+	}
+	sendBroadcast(broadcast)
+	if broadcast, _ = memory.FindBroadcast(broadcast.ID); broadcast.Status != BroadcastFailed {
+		t.Fatalf("expected the broadcast to be failed, got %s", broadcast.Status)
+	}
+	if len(memory.notifications) != 0 {
+		t.Fatalf("expected no notification, got %d", len(memory.notifications))
+	}
+}
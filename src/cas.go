@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrCASValidation is returned when a CAS ticket can not be validated
+var ErrCASValidation = NewAPIError(http.StatusUnauthorized, "cas_validation",
+	"the CAS ticket could not be validated", "Impossible de verfifier l'identité")
+
+// casClient is the CASClient used to validate the tickets of SignInUserController.
+// It is replaced in main by a client built from the config file
+var casClient = NewCASClient(Config{})
+
+// CASClient validates service and proxy tickets against a CAS server
+type CASClient struct {
+	// URL is the base URL of the CAS server, e.g. https://cas.insa-rennes.fr/cas
+	URL string
+	// Service is the service URL the tickets have been issued for
+	Service string
+	// Version is the CAS protocol version, "2.0" or "3.0"
+	Version string
+	// Proxy allows proxy tickets on top of service tickets
+	Proxy bool
+	// AllowedProxies are the proxy services accepted in the chain of
+	// a proxy ticket. A ticket proxied by any other one is rejected
+	AllowedProxies []string
+	// Attributes maps the User fields ("name", "email", "department")
+	// to the name of the CAS attributes holding them
+	Attributes map[string]string
+	HTTPClient *http.Client
+}
+
+// CASResponse is the result of a successful ticket validation
+type CASResponse struct {
+	User       string
+	Attributes map[string][]string
+	Proxies    []string
+}
+
+// casServiceResponse is the XML answered by the CAS validation endpoints
+type casServiceResponse struct {
+	XMLName xml.Name `xml:"serviceResponse"`
+	Success *struct {
+		User       string `xml:"user"`
+		Attributes struct {
+			Values []casAttribute `xml:",any"`
+		} `xml:"attributes"`
+		Proxies []string `xml:"proxies>proxy"`
+	} `xml:"authenticationSuccess"`
+	Failure *struct {
+		Code    string `xml:"code,attr"`
+		Message string `xml:",chardata"`
+	} `xml:"authenticationFailure"`
+}
+
+// casAttribute is either <cas:mail>value</cas:mail>
+// or <cas:attribute name="mail" value="value"/>
+type casAttribute struct {
+	XMLName xml.Name
+	Name    string `xml:"name,attr"`
+	Value   string `xml:"value,attr"`
+	Content string `xml:",chardata"`
+}
+
+// NewCASClient is the constructor of CASClient, using the CAS
+// settings of the given Config and the INSA Rennes ones by default
+func NewCASClient(config Config) *CASClient {
+	client := &CASClient{
+		URL:            config.CASURL,
+		Service:        config.CASService,
+		Version:        config.CASVersion,
+		Proxy:          config.CASProxy,
+		AllowedProxies: config.CASAllowedProxies,
+		Attributes: map[string]string{
+			"name":       "displayName",
+			"email":      "mail",
+			"department": "department",
+		},
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	if client.URL == "" {
+		client.URL = "https://cas.insa-rennes.fr/cas"
+	}
+	if client.Service == "" {
+		client.Service = "https://insapp.fr/"
+	}
+	if client.Version == "" {
+		client.Version = "2.0"
+	}
+	for field, attribute := range config.CASAttributes {
+		client.Attributes[field] = attribute
+	}
+	return client
+}
+
+// validationURL returns the URL validating the given ticket
+func (c *CASClient) validationURL(ticket string) string {
+	endpoint := "/serviceValidate"
+	if c.Proxy {
+		endpoint = "/proxyValidate"
+	}
+	if c.Version == "3.0" {
+		endpoint = "/p3" + endpoint
+	}
+	query := url.Values{}
+	query.Set("service", c.Service)
+	query.Set("ticket", ticket)
+	return strings.TrimSuffix(c.URL, "/") + endpoint + "?" + query.Encode()
+}
+
+// Validate will validate the given ticket against the CAS server
+// and return the authenticated user along with its attributes
+func (c *CASClient) Validate(ticket string) (*CASResponse, error) {
+	if len(ticket) == 0 {
+		return nil, ErrCASValidation
+	}
+	response, err := c.HTTPClient.Get(c.validationURL(ticket))
+	if err != nil {
+		return nil, ErrCASValidation
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, ErrCASValidation
+	}
+
+	var result casServiceResponse
+	if err := xml.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, ErrCASValidation
+	}
+	if result.Success == nil {
+		return nil, ErrCASValidation
+	}
+	username := strings.TrimSpace(result.Success.User)
+	if len(username) == 0 {
+		return nil, ErrCASValidation
+	}
+	for _, proxy := range result.Success.Proxies {
+		if !containsString(c.AllowedProxies, strings.TrimSpace(proxy)) {
+			return nil, ErrCASValidation
+		}
+	}
+
+	attributes := map[string][]string{}
+	for _, attribute := range result.Success.Attributes.Values {
+		name, value := attribute.XMLName.Local, strings.TrimSpace(attribute.Content)
+		if name == "attribute" {
+			name, value = attribute.Name, attribute.Value
+		}
+		attributes[name] = append(attributes[name], value)
+	}
+	return &CASResponse{User: username, Attributes: attributes, Proxies: result.Success.Proxies}, nil
+}
+
+// Attribute returns the first value of the CAS attribute
+// mapped to the given User field, or an empty string
+func (c *CASClient) Attribute(response *CASResponse, field string) string {
+	values := response.Attributes[c.Attributes[field]]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// NewUserFromCAS will build a new User from the attributes of the given CASResponse
+func (c *CASClient) NewUserFromCAS(response *CASResponse) User {
+	user := User{
+		Username:    response.User,
+		Name:        c.Attribute(response, "name"),
+		Email:       c.Attribute(response, "email"),
+		Department:  c.Attribute(response, "department"),
+		EmailPublic: false,
+		Events:      []bson.ObjectId{},
+		PostsLiked:  []bson.ObjectId{},
+	}
+	for _, promotion := range promotions {
+		if len(promotion) > 0 && strings.EqualFold(promotion, user.Department) {
+			user.Promotion = promotion
+		}
+	}
+	return user
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const casSuccess2 = `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+	<cas:authenticationSuccess>
+		<cas:user>jdupont</cas:user>
+		<cas:attributes>
+			<cas:attribute name="displayName" value="Jean Dupont"/>
+			<cas:attribute name="mail" value="jean.dupont@insa-rennes.fr"/>
+		</cas:attributes>
+	</cas:authenticationSuccess>
+</cas:serviceResponse>`
+
+const casSuccess3 = `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+	<cas:authenticationSuccess>
+		<cas:user>jdupont</cas:user>
+		<cas:attributes>
+			<cas:displayName>Jean Dupont</cas:displayName>
+			<cas:mail>jean.dupont@insa-rennes.fr</cas:mail>
+			<cas:department>3INFO</cas:department>
+			<cas:memberOf>students</cas:memberOf>
+			<cas:memberOf>insa</cas:memberOf>
+		</cas:attributes>
+	</cas:authenticationSuccess>
+</cas:serviceResponse>`
+
+const casProxied = `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+	<cas:authenticationSuccess>
+		<cas:user>jdupont</cas:user>
+		<cas:proxies>
+			<cas:proxy>https://proxy.insapp.fr/</cas:proxy>
+		</cas:proxies>
+	</cas:authenticationSuccess>
+</cas:serviceResponse>`
+
+const casFailure = `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+	<cas:authenticationFailure code="INVALID_TICKET">Ticket ST-1 not recognized</cas:authenticationFailure>
+</cas:serviceResponse>`
+
+// newTestCAS starts a stub CAS server answering the given body to the
+// given endpoint, and returns a CASClient of the given version validating
+// against it. The requested service and ticket are checked
+func newTestCAS(t *testing.T, version string, proxy bool, endpoint string, body string) *CASClient {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != endpoint {
+			t.Errorf("expected %s, got %s", endpoint, r.URL.Path)
+		}
+		if r.URL.Query().Get("service") != "https://insapp.fr/" || r.URL.Query().Get("ticket") != "ST-1" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return NewCASClient(Config{CASURL: server.URL + "/cas", CASVersion: version, CASProxy: proxy})
+}
+
+func TestCASValidate2(t *testing.T) {
+	client := newTestCAS(t, "2.0", false, "/cas/serviceValidate", casSuccess2)
+	response, err := client.Validate("ST-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := client.NewUserFromCAS(response)
+	if user.Username != "jdupont" || user.Name != "Jean Dupont" || user.Email != "jean.dupont@insa-rennes.fr" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+}
+
+func TestCASValidate3(t *testing.T) {
+	client := newTestCAS(t, "3.0", false, "/cas/p3/serviceValidate", casSuccess3)
+	response, err := client.Validate("ST-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := response.Attributes["memberOf"]; len(values) != 2 || values[0] != "students" || values[1] != "insa" {
+		t.Fatalf("expected both memberOf values, got %v", values)
+	}
+	user := client.NewUserFromCAS(response)
+	if user.Name != "Jean Dupont" || user.Department != "3INFO" || user.Promotion != "3INFO" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+}
+
+func TestCASMapsConfiguredAttributes(t *testing.T) {
+	client := newTestCAS(t, "3.0", false, "/cas/p3/serviceValidate", casSuccess3)
+	client.Attributes["name"] = "mail"
+	response, err := client.Validate("ST-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := client.NewUserFromCAS(response).Name; name != "jean.dupont@insa-rennes.fr" {
+		t.Fatalf("expected the mapped attribute, got %q", name)
+	}
+}
+
+func TestCASRejectsFailure(t *testing.T) {
+	client := newTestCAS(t, "2.0", false, "/cas/serviceValidate", casFailure)
+	if _, err := client.Validate("ST-1"); err != ErrCASValidation {
+		t.Fatalf("expected ErrCASValidation, got %v", err)
+	}
+}
+
+func TestCASRejectsMalformedXML(t *testing.T) {
+	client := newTestCAS(t, "2.0", false, "/cas/serviceValidate", `<cas:serviceResponse><cas:authenticationSuccess>`)
+	if _, err := client.Validate("ST-1"); err != ErrCASValidation {
+		t.Fatalf("expected ErrCASValidation, got %v", err)
+	}
+}
+
+func TestCASChecksProxies(t *testing.T) {
+	client := newTestCAS(t, "2.0", true, "/cas/proxyValidate", casProxied)
+	if _, err := client.Validate("ST-1"); err != ErrCASValidation {
+		t.Fatalf("expected a proxy out of the allow-list to be rejected, got %v", err)
+	}
+	client.AllowedProxies = []string{"https://proxy.insapp.fr/"}
+	response, err := client.Validate("ST-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.User != "jdupont" || len(response.Proxies) != 1 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
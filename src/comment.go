@@ -1,9 +1,8 @@
 package main
 
 import (
+	"log"
 	"time"
-	"errors"
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -14,6 +13,8 @@ type Comment struct {
 	Content string        		`json:"content"`
 	Date    time.Time     		`json:"date"`
 	Tags    Tags							`json:"tags"`
+	// ReplyTo is the comment of the post this one replies to, if any
+	ReplyTo bson.ObjectId			`json:"replyto,omitempty" bson:"replyto,omitempty"`
 }
 
 // Comments is an array of Comment
@@ -30,79 +31,97 @@ type Tags []Tag
 
 // CommentPost will add the given comment object to the
 // list of comments of the post linked to the given id
-func CommentPost(id bson.ObjectId, comment Comment) Post {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	postID := bson.M{"_id": id}
-	change := bson.M{"$addToSet": bson.M{
-		"comments": comment,
-	}}
-	db.Update(postID, change)
-	var post Post
-	db.Find(bson.M{"_id": id}).One(&post)
-	return post
+func CommentPost(id bson.ObjectId, comment Comment) (Post, error) {
+	if len(comment.Content) == 0 {
+		return Post{}, ValidationError("Le commentaire est vide")
+	}
+	for _, tag := range comment.Tags {
+		if !bson.IsObjectIdHex(tag.User) {
+			return Post{}, ValidationError("Utilisateur taggé invalide")
+		}
+	}
+	if comment.ReplyTo != "" {
+		if _, err := GetComment(id, comment.ReplyTo); err == ErrNotFound {
+			return Post{}, ValidationError("Le commentaire auquel tu réponds n'existe pas")
+		} else if err != nil {
+			return Post{}, err
+		}
+	}
+	if err := store.AddPostComment(id, comment); err != nil {
+		return Post{}, err
+	}
+	return findAndPublishPost(id)
 }
 
 // UncommentPost will remove the given comment object from the
 // list of comments of the post linked to the given id
-func UncommentPost(id bson.ObjectId, commentID bson.ObjectId) Post {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	DeleteNotificationsForComment(commentID)
-	postID := bson.M{"_id": id}
-	change := bson.M{"$pull": bson.M{
-		"comments": bson.M{"_id": commentID},
-	}}
-	db.Update(postID, change)
-	var post Post
-	db.Find(bson.M{"_id": id}).One(&post)
-	return post
+func UncommentPost(id bson.ObjectId, commentID bson.ObjectId) (Post, error) {
+	comment, err := GetComment(id, commentID)
+	if err != nil {
+		return Post{}, err
+	}
+	if err := store.RemovePostComment(id, commentID); err != nil {
+		return Post{}, err
+	}
+	post, err := findAndPublishPost(id)
+	if err != nil {
+		return Post{}, err
+	}
+	if err := DeleteNotificationsForComment(post, comment); err != nil {
+		log.Println("[error] Failed to delete the notifications of comment", commentID.Hex(), err)
+	}
+	return post, nil
 }
 
-func ReportComment(id bson.ObjectId, commentID bson.ObjectId, reporterId bson.ObjectId) {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	var post Post
-	db.Find(bson.M{"_id": id}).One(&post)
-	db = session.DB("insapp").C("user")
-	var reporter User
-	db.Find(bson.M{"_id": reporterId}).One(&reporter)
-	for _, comment := range post.Comments {
-		if comment.ID == commentID {
-			var sender User
-			db = session.DB("insapp").C("user")
-			db.Find(bson.M{"_id": comment.User}).One(&sender)
-			SendEmail("aeir@insa-rennes.fr", "Un commentaire a été reporté sur Insapp",
-				"Ce commentaire a été reporté le " + time.Now().String() +
-				"\n\nReporteur:\n" + reporter.ID.Hex() + "\n" + reporter.Username +
-				"\n\nCommentaire:\n" + comment.ID.Hex() + "\n" + comment.Content +
-				"\n\nPost:\n" + post.Title +
-				"\n\nUser:\n" + sender.ID.Hex() + "\n" + sender.Username + "\n" + sender.Name)
-		}
+// findAndPublishPost returns the post of the given id after
+// streaming it to the clients watching it
+func findAndPublishPost(id bson.ObjectId) (Post, error) {
+	post, err := store.FindPost(id)
+	if err == nil {
+		publishPost(post)
+	}
+	return post, err
+}
+
+func ReportComment(id bson.ObjectId, commentID bson.ObjectId, reporterId bson.ObjectId) error {
+	post, err := store.FindPost(id)
+	if err != nil {
+		return err
+	}
+	reporter, err := store.FindUser(reporterId)
+	if err != nil {
+		return err
+	}
+	comment, err := GetComment(id, commentID)
+	if err != nil {
+		return err
 	}
+	sender, _ := store.FindUser(comment.User)
+	SendEmail("aeir@insa-rennes.fr", "Un commentaire a été reporté sur Insapp",
+		"Ce commentaire a été reporté le " + time.Now().String() +
+		"\n\nReporteur:\n" + reporter.ID.Hex() + "\n" + reporter.Username +
+		"\n\nCommentaire:\n" + comment.ID.Hex() + "\n" + comment.Content +
+		"\n\nPost:\n" + post.Title +
+		"\n\nUser:\n" + sender.ID.Hex() + "\n" + sender.Username + "\n" + sender.Name)
+	return nil
 }
 
 func GetComment(postId bson.ObjectId, id bson.ObjectId) (Comment, error) {
-	post := GetPost(postId)
+	post, err := GetPost(postId)
+	if err != nil {
+		return Comment{}, err
+	}
 	for _, comment := range post.Comments {
 		if comment.ID == id {
 			return comment, nil
 		}
 	}
-	return Comment{}, errors.New("No Comment Found")
+	return Comment{}, ErrNotFound
 }
 
-func getCommentforUser(id bson.ObjectId, userId bson.ObjectId) []bson.ObjectId {
-	post := GetPost(id)
-	comments := post.Comments
+func getCommentforUser(post Post, userId bson.ObjectId) []bson.ObjectId {
 	var results []bson.ObjectId
-	for _, comment := range comments{
+	for _, comment := range post.Comments{
 		if comment.User == userId {
 			results = append(results, comment.ID)
 		}
@@ -110,23 +129,27 @@ func getCommentforUser(id bson.ObjectId, userId bson.ObjectId) []bson.ObjectId {
 	return results
 }
 
-func DeleteCommentsForUser(userId bson.ObjectId) {
-	posts := GetLastestPosts(100)
+func DeleteCommentsForUser(userId bson.ObjectId) error {
+	posts, err := store.FindPosts()
+	if err != nil {
+		return err
+	}
 	for _, post := range posts {
-		comments := getCommentforUser(post.ID, userId)
+		comments := getCommentforUser(post, userId)
 		for _, commentId := range comments {
-				UncommentPost(post.ID, commentId)
+			if _, err := UncommentPost(post.ID, commentId); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
-func DeleteTagsForUser(userId bson.ObjectId) {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	var posts Posts
-	db.Find(bson.M{}).All(&posts)
+func DeleteTagsForUser(userId bson.ObjectId) error {
+	posts, err := store.FindPosts()
+	if err != nil {
+		return err
+	}
 	for _, post := range(posts){
 		comments := post.Comments
 		finalComments := Comments{}
@@ -141,6 +164,9 @@ func DeleteTagsForUser(userId bson.ObjectId) {
 			comment.Tags = finalTags
 			finalComments = append(finalComments, comment)
 		}
-		db.Update(bson.M{"_id": post.ID}, bson.M{"$set": bson.M{"comments": finalComments}})
+		if err := store.SetPostComments(post.ID, finalComments); err != nil {
+			return err
+		}
 	}
+	return nil
 }
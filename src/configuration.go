@@ -12,6 +12,63 @@ type Config struct {
 	GoogleKey   string      `json:"googlekey"`
   Environment string      `json:"env"`
   Port        string      `json:"port"`
+
+	MongoURL           string `json:"mongourl"`
+	MongoDatabase      string `json:"mongodatabase"`
+	MongoTimeout       int    `json:"mongotimeout"`
+	MongoSocketTimeout int    `json:"mongosockettimeout"`
+	MongoPoolLimit     int    `json:"mongopoollimit"`
+	MongoMode          string `json:"mongomode"`
+
+	SessionDuration        int `json:"sessionduration"`
+	SessionCleanupInterval int `json:"sessioncleanupinterval"`
+
+	// MailTransport is "smtp" by default, "file" to write the emails to
+	// MailDirectory or "memory" to keep them, for the development.
+	// SMTPTLS is "starttls" by default, "tls" or "none". The SMTP server
+	// is the Gmail one with the Email account if not set
+	MailTransport string `json:"mailtransport"`
+	MailDirectory string `json:"maildirectory"`
+	MailFrom      string `json:"mailfrom"`
+	SMTPHost      string `json:"smtphost"`
+	SMTPPort      int    `json:"smtpport"`
+	SMTPTLS       string `json:"smtptls"`
+	SMTPUsername  string `json:"smtpusername"`
+	SMTPPassword  string `json:"smtppassword"`
+
+	PasswordResetURL string `json:"passwordreseturl"`
+	InvitationURL    string `json:"invitationurl"`
+
+	// DigestUnsubscribeURL is the page unsubscribing from the digest,
+	// the token of the user is appended to it
+	DigestUnsubscribeURL string `json:"digestunsubscribeurl"`
+	// DigestOneClickURL is the public URL of the one-click unsubscribe route
+	// of the API, "/digest/unsubscribe/oneclick?token=", the token of the
+	// user is appended to it. The mail clients unsubscribe with it only if set
+	DigestOneClickURL string `json:"digestoneclickurl"`
+
+	CASURL        string            `json:"casurl"`
+	CASService    string            `json:"casservice"`
+	CASVersion    string            `json:"casversion"`
+	CASProxy      bool              `json:"casproxy"`
+	CASAttributes map[string]string `json:"casattributes"`
+	// CASAllowedProxies are the services allowed in the proxy chain of a ticket
+	CASAllowedProxies []string `json:"casallowedproxies"`
+
+	FeedWeights *FeedWeights `json:"feedweights"`
+
+	PushWorkers     int `json:"pushworkers"`
+	PushBatchSize   int `json:"pushbatchsize"`
+	PushMaxAttempts int `json:"pushmaxattempts"`
+
+	// PushProvider is "fake" to record the pushes instead of sending them
+	PushProvider   string `json:"pushprovider"`
+	FCMCredentials string `json:"fcmcredentials"`
+	FCMProject     string `json:"fcmproject"`
+	APNsKey        string `json:"apnskey"`
+	APNsKeyID      string `json:"apnskeyid"`
+	APNsTeamID     string `json:"apnsteamid"`
+	APNsTopic      string `json:"apnstopic"`
 }
 
 
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// LoginDevice is the public view of the Credentials of a device,
+// without the AuthToken
+type LoginDevice struct {
+	ID        bson.ObjectId `json:"id"`
+	Device    string        `json:"device"`
+	CreatedAt time.Time     `json:"createdat"`
+	LastUsed  time.Time     `json:"lastused"`
+}
+
+// GetDevicesForUser will return the devices on which
+// the given user is signed in, the most recently used first
+func GetDevicesForUser(userID bson.ObjectId) ([]LoginDevice, error) {
+	credentials, err := store.FindCredentialsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	result := []LoginDevice{}
+	for _, cred := range credentials {
+		result = append(result, LoginDevice{ID: cred.ID, Device: cred.Device, CreatedAt: cred.CreatedAt, LastUsed: cred.LastUsed})
+	}
+	return result, nil
+}
+
+// RevokeDeviceForUser will sign the given user out of the device linked to
+// the given credentials id. It returns ErrNotFound if the user has no such device
+func RevokeDeviceForUser(userID bson.ObjectId, credentialsID bson.ObjectId) error {
+	credentials, err := store.FindCredentialsForUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, cred := range credentials {
+		if cred.ID == credentialsID {
+			return RevokeCredentials(cred)
+		}
+	}
+	return ErrNotFound
+}
+
+// RevokeAllDevicesForUser will sign the given user out of all its devices
+func RevokeAllDevicesForUser(userID bson.ObjectId) error {
+	credentials, err := store.FindCredentialsForUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, cred := range credentials {
+		if err := RevokeCredentials(cred); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeCredentials will delete the given credentials
+// and the session tokens opened with them
+func RevokeCredentials(credentials Credentials) error {
+	if err := RevokeSessionTokensForCredentials(credentials.ID); err != nil {
+		return err
+	}
+	return store.RemoveCredentials(credentials.ID)
+}
+
+// Logout will revoke the given session token, and the
+// credentials of the device it was opened with if any
+func Logout(token string) error {
+	session, err := store.FindSessionToken(token)
+	if err != nil {
+		return err
+	}
+	if err := RevokeSessionToken(token); err != nil {
+		return err
+	}
+	if session.Credentials != "" {
+		if err := RevokeSessionTokensForCredentials(session.Credentials); err != nil {
+			return err
+		}
+		return store.RemoveCredentials(session.Credentials)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/freehaha/token-auth"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// GetDevicesController will answer a JSON of the devices
+// on which the user linked to the given id is signed in
+func GetDevicesController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["id"]
+	isValid := VerifyUserRequest(r, bson.ObjectIdHex(userID))
+	if !isValid {
+		Forbidden(w)
+		return
+	}
+	res, err := GetDevicesForUser(bson.ObjectIdHex(userID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"devices": res})
+}
+
+// RevokeDeviceController will sign the user out of the given device
+func RevokeDeviceController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["id"]
+	deviceID := vars["deviceID"]
+	isValid := VerifyUserRequest(r, bson.ObjectIdHex(userID))
+	if !isValid {
+		Forbidden(w)
+		return
+	}
+	if err := RevokeDeviceForUser(bson.ObjectIdHex(userID), bson.ObjectIdHex(deviceID)); err != nil {
+		WriteError(w, err)
+		return
+	}
+	res, err := GetDevicesForUser(bson.ObjectIdHex(userID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"devices": res})
+}
+
+// RevokeAllDevicesController will sign the user out of all its devices
+func RevokeAllDevicesController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["id"]
+	isValid := VerifyUserRequest(r, bson.ObjectIdHex(userID))
+	if !isValid {
+		Forbidden(w)
+		return
+	}
+	if err := RevokeAllDevicesForUser(bson.ObjectIdHex(userID)); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"devices": []LoginDevice{}})
+}
+
+// LogoutController will invalidate the session token of the request
+// and the credentials of the device it was opened with
+func LogoutController(w http.ResponseWriter, r *http.Request) {
+	token := tauth.Get(r)
+	if err := Logout(token.String()); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"status": "ok"})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// newTestDevice signs the given user in on the given device,
+// and returns its credentials and session token
+func newTestDevice(t *testing.T, userID bson.ObjectId, device string) (Credentials, *SessionToken) {
+	t.Helper()
+	credentials, err := addCredentials(Credentials{Username: "alice", AuthToken: device + "-token", User: userID, Device: device})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := logUser(credentials, User{ID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return credentials, token
+}
+
+// expectDevices fails if the given user is not signed in on exactly the given devices
+func expectDevices(t *testing.T, userID bson.ObjectId, devices ...string) {
+	t.Helper()
+	result, err := GetDevicesForUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != len(devices) {
+		t.Fatalf("expected the devices %v, got %+v", devices, result)
+	}
+	for i, device := range devices {
+		if result[i].Device != device {
+			t.Fatalf("expected the devices %v, got %+v", devices, result)
+		}
+	}
+}
+
+func TestAddCredentialsPerDevice(t *testing.T) {
+	newTestStore()
+	userID := bson.NewObjectId()
+	_, phone := newTestDevice(t, userID, "phone")
+	newTestDevice(t, userID, "tablet")
+	_, newPhone := newTestDevice(t, userID, "phone")
+	if devices, _ := GetDevicesForUser(userID); len(devices) != 2 {
+		t.Fatalf("expected one credential per device, got %+v", devices)
+	}
+	if _, err := testTokenStore.CheckToken(phone.Token); err == nil {
+		t.Fatal("expected the session of the replaced credentials to be revoked")
+	}
+	if _, err := testTokenStore.CheckToken(newPhone.Token); err != nil {
+		t.Fatalf("expected the new session to be valid, got %v", err)
+	}
+}
+
+func TestRevokeDevice(t *testing.T) {
+	newTestStore()
+	userID := bson.NewObjectId()
+	phone, phoneSession := newTestDevice(t, userID, "phone")
+	_, tabletSession := newTestDevice(t, userID, "tablet")
+	if err := RevokeDeviceForUser(bson.NewObjectId(), phone.ID); err == nil {
+		t.Fatal("expected a device of another user not to be revoked")
+	}
+	if err := RevokeDeviceForUser(userID, phone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTokenStore.CheckToken(phoneSession.Token); err == nil {
+		t.Fatal("expected the session of the revoked device to be refused")
+	}
+	expectDevices(t, userID, "tablet")
+	if err := RevokeAllDevicesForUser(userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTokenStore.CheckToken(tabletSession.Token); err == nil {
+		t.Fatal("expected every session to be revoked")
+	}
+	expectDevices(t, userID)
+}
+
+func TestLogout(t *testing.T) {
+	newTestStore()
+	userID := bson.NewObjectId()
+	_, phone := newTestDevice(t, userID, "phone")
+	_, tablet := newTestDevice(t, userID, "tablet")
+	if err := Logout(phone.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTokenStore.CheckToken(phone.Token); err == nil {
+		t.Fatal("expected the session to be revoked")
+	}
+	expectDevices(t, userID, "tablet")
+	if _, err := testTokenStore.CheckToken(tablet.Token); err != nil {
+		t.Fatalf("expected the other device to stay signed in, got %v", err)
+	}
+}
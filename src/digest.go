@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Status of a DigestRecord
+const (
+	DigestSent  = "sent"
+	DigestEmpty = "empty"
+)
+
+const (
+	// digestWeekday and digestHour are when the digests of the week are sent,
+	// in the defaultTimezone. A digest missed then is sent later in the week
+	digestWeekday = time.Monday
+	digestHour    = 8
+	// digestInterval is how often the digest job looks for digests to send
+	digestInterval = time.Hour
+	digestMaxItems = 10
+)
+
+// ErrInvalidDigestToken is returned when an unsubscribe token is unknown
+var ErrInvalidDigestToken = NewAPIError(http.StatusBadRequest, "invalid_digest_token",
+	"the unsubscribe token is unknown", "Lien de désinscription invalide")
+
+// DigestSubscription defines how to model the opt-in of a user to the weekly
+// email digest. Token is the secret of the unsubscribe link of the emails
+type DigestSubscription struct {
+	User      bson.ObjectId `json:"user" bson:"_id"`
+	Token     string        `json:"-"`
+	CreatedAt time.Time     `json:"createdat"`
+}
+
+// DigestRecord is the record of the digest of a week sent to a user,
+// the Week being formatted as "2026-W42"
+type DigestRecord struct {
+	ID     bson.ObjectId   `bson:"_id,omitempty"`
+	User   bson.ObjectId   `json:"user"`
+	Week   string          `json:"week"`
+	Status string          `json:"status"`
+	Events []bson.ObjectId `json:"events"`
+	Posts  []bson.ObjectId `json:"posts"`
+	SentAt time.Time       `json:"sentat"`
+}
+
+// digestPost is a Post of a digest, with the name of its association
+type digestPost struct {
+	Post
+	AssociationName string
+}
+
+// digestData is what the digest templates are executed with
+type digestData struct {
+	User           User
+	Events         Events
+	Posts          []digestPost
+	UnsubscribeURL string
+}
+
+// SubscribeToDigest will subscribe the given user to the weekly digest
+// sent to the email of the profile. It does nothing if already subscribed
+func SubscribeToDigest(userID bson.ObjectId) error {
+	user, err := store.FindUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.Email == "" {
+		return ValidationError("Adresse email manquante")
+	}
+	if _, err := store.FindDigestSubscription(userID); err != ErrNotFound {
+		return err
+	}
+	return store.InsertDigestSubscription(DigestSubscription{User: userID, Token: generateSessionToken(), CreatedAt: time.Now()})
+}
+
+// UnsubscribeFromDigest will unsubscribe the given user from the weekly digest
+func UnsubscribeFromDigest(userID bson.ObjectId) error {
+	err := store.RemoveDigestSubscription(userID)
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// UnsubscribeFromDigestWithToken will unsubscribe the user of the given token,
+// the one of the unsubscribe link of the digest emails
+func UnsubscribeFromDigestWithToken(token string) error {
+	subscription, err := store.FindDigestSubscriptionByToken(token)
+	if err == ErrNotFound {
+		return ErrInvalidDigestToken
+	}
+	if err != nil {
+		return err
+	}
+	return UnsubscribeFromDigest(subscription.User)
+}
+
+// IsSubscribedToDigest returns whether the given user receives the weekly digest
+func IsSubscribedToDigest(userID bson.ObjectId) (bool, error) {
+	_, err := store.FindDigestSubscription(userID)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SendDigests will run the digest job every digestInterval: once the send
+// time of the week has passed, it sends the digest of each subscriber who
+// has not received the one of this week yet
+func SendDigests() {
+	for {
+		if week, due := digestWeek(time.Now()); due {
+			sendDigestsOfWeek(week)
+		}
+		time.Sleep(digestInterval)
+	}
+}
+
+// digestWeek returns the week of the given date, and whether
+// the digests of this week are due at this date
+func digestWeek(date time.Time) (string, bool) {
+	local := date.In(digestLocation())
+	year, week := local.ISOWeek()
+	daysSinceMonday := (int(local.Weekday()) + 6) % 7
+	monday := time.Date(local.Year(), local.Month(), local.Day()-daysSinceMonday, 0, 0, 0, 0, local.Location())
+	sendAt := monday.AddDate(0, 0, (int(digestWeekday)+6)%7).Add(digestHour * time.Hour)
+	return fmt.Sprintf("%d-W%02d", year, week), !local.Before(sendAt)
+}
+
+// digestLocation returns the location of the defaultTimezone
+func digestLocation() *time.Location {
+	location, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		log.Println("[error] Failed to load the timezone", defaultTimezone, "of the digest, using UTC", err)
+		return time.UTC
+	}
+	return location
+}
+
+func sendDigestsOfWeek(week string) {
+	subscriptions, err := store.FindDigestSubscriptions()
+	if err != nil {
+		log.Println("[error] Failed to get the digest subscriptions", err)
+		return
+	}
+	for _, subscription := range subscriptions {
+		if err := sendDigest(subscription, week, time.Now()); err != nil && err != ErrDuplicate {
+			log.Println("[error] Failed to send the digest of", subscription.User.Hex(), err)
+		}
+	}
+}
+
+// sendDigest will send the digest of the given week to the subscriber. The
+// record of the week is inserted first, so that a digest is sent only once
+// even by concurrent jobs: it returns ErrDuplicate if it already has been
+func sendDigest(subscription DigestSubscription, week string, now time.Time) error {
+	user, err := store.FindUser(subscription.User)
+	if err != nil {
+		return err
+	}
+	previous, err := store.FindLastDigestRecord(user.ID)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if previous.Week == week {
+		return ErrDuplicate
+	}
+	data, err := compileDigest(user, previous, now)
+	if err != nil {
+		return err
+	}
+	record := DigestRecord{ID: bson.NewObjectId(), User: user.ID, Week: week, Status: DigestEmpty, SentAt: now}
+	for _, event := range data.Events {
+		record.Events = append(record.Events, event.ID)
+	}
+	for _, post := range data.Posts {
+		record.Posts = append(record.Posts, post.ID)
+	}
+	if len(data.Events) > 0 || len(data.Posts) > 0 {
+		record.Status = DigestSent
+	}
+	if err := store.InsertDigestRecord(record); err != nil {
+		return err
+	}
+	if record.Status == DigestEmpty || user.Email == "" {
+		return nil
+	}
+
+	data.UnsubscribeURL = mailLinks.DigestUnsubscribe + url.QueryEscape(subscription.Token)
+	email, err := digestTemplate.Render(user.Email, data)
+	if err != nil {
+		return err
+	}
+	email.Headers = map[string]string{"List-Unsubscribe": "<" + data.UnsubscribeURL + ">"}
+	if mailLinks.DigestOneClick != "" {
+		// RFC 8058: the mail client posts List-Unsubscribe=One-Click to the URL
+		email.Headers["List-Unsubscribe"] = "<" + mailLinks.DigestOneClick + url.QueryEscape(subscription.Token) + ">"
+		email.Headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	}
+	if err := mailer.Send(email); err != nil {
+		// forget the record for the digest to be sent by the next run
+		store.RemoveDigestRecord(record.ID)
+		return err
+	}
+	return nil
+}
+
+// compileDigest returns the upcoming events of the next week and the posts of
+// the last week of the associations followed by the user, leaving out the
+// events of the previous digest. It is empty if the user follows none
+func compileDigest(user User, previous DigestRecord, now time.Time) (digestData, error) {
+	data := digestData{User: user}
+	if len(user.Following) == 0 {
+		return data, nil
+	}
+	events, _, err := GetFutureEvents(Page{Limit: maxPageLimit})
+	if err != nil {
+		return data, err
+	}
+	for _, event := range events {
+		if len(data.Events) == digestMaxItems || event.DateStart.After(now.AddDate(0, 0, 7)) {
+			break
+		}
+		if !contains(previous.Events, event.ID) {
+			data.Events = append(data.Events, event)
+		}
+	}
+
+	posts, err := store.FindPostsForAssociations(user.Following, Page{Limit: digestMaxItems})
+	if err != nil {
+		return data, err
+	}
+	names := map[bson.ObjectId]string{}
+	for _, post := range posts {
+		if post.Date.Before(now.AddDate(0, 0, -7)) {
+			break
+		}
+		if _, ok := names[post.Association]; !ok {
+			association, _ := store.FindAssociation(post.Association)
+			names[post.Association] = association.Name
+		}
+		data.Posts = append(data.Posts, digestPost{Post: post, AssociationName: names[post.Association]})
+	}
+	return data, nil
+}
+
+// digestTemplate is the email of the digest. It is sent by the digest
+// job itself rather than queued, for a failed one to be sent by the next run
+var digestTemplate = NewMailTemplate("digest", "Ta semaine sur Insapp", `Bonjour {{.User.Name}},
+
+Voici ta semaine sur Insapp.
+{{if .Events}}
+Les prochains événements :
+{{range .Events}}- {{.Name}}, le {{date .DateStart}}
+{{end}}{{end}}{{if .Posts}}
+Les dernières publications :
+{{range .Posts}}- {{.AssociationName}} : {{.Title}}
+{{end}}{{end}}
+Pour ne plus recevoir ce résumé : {{.UnsubscribeURL}}
+`, `<!DOCTYPE html>
+<html>
+<body>
+<p>Bonjour {{.User.Name}},</p>
+<p>Voici ta semaine sur Insapp.</p>
+{{if .Events}}<h2>Les prochains événements</h2>
+<ul>{{range .Events}}
+<li><strong>{{.Name}}</strong>, le {{date .DateStart}}</li>{{end}}
+</ul>{{end}}
+{{if .Posts}}<h2>Les dernières publications</h2>
+<ul>{{range .Posts}}
+<li><strong>{{.AssociationName}}</strong> : {{.Title}}</li>{{end}}
+</ul>{{end}}
+<p><small><a href="{{.UnsubscribeURL}}">Ne plus recevoir ce résumé</a></small></p>
+</body>
+</html>
+`)
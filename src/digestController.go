@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// GetDigestController will answer a JSON of whether
+// the user is subscribed to the weekly digest
+func GetDigestController(w http.ResponseWriter, r *http.Request) {
+	userID := bson.ObjectIdHex(mux.Vars(r)["id"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	subscribed, err := IsSubscribedToDigest(userID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"subscribed": subscribed})
+}
+
+// SubscribeToDigestController will subscribe the user to the weekly digest
+func SubscribeToDigestController(w http.ResponseWriter, r *http.Request) {
+	userID := bson.ObjectIdHex(mux.Vars(r)["id"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	if err := SubscribeToDigest(userID); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"subscribed": true})
+}
+
+// UnsubscribeFromDigestController will unsubscribe the user from the weekly digest
+func UnsubscribeFromDigestController(w http.ResponseWriter, r *http.Request) {
+	userID := bson.ObjectIdHex(mux.Vars(r)["id"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	if err := UnsubscribeFromDigest(userID); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"subscribed": false})
+}
+
+// UnsubscribeFromDigestWithTokenController will unsubscribe the user
+// of the token of the JSON body, the one of the link of the digest emails
+func UnsubscribeFromDigestWithTokenController(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	if err := UnsubscribeFromDigestWithToken(body.Token); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"status": "ok"})
+}
+
+// OneClickUnsubscribeFromDigestController will unsubscribe the user of the
+// token of the query, for the one-click unsubscribe of the mail clients which
+// post the form "List-Unsubscribe=One-Click" to the List-Unsubscribe URL
+func OneClickUnsubscribeFromDigestController(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("List-Unsubscribe") != "One-Click" || token == "" {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	if err := UnsubscribeFromDigestWithToken(token); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"status": "ok"})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDigestWeek(t *testing.T) {
+	paris := digestLocation()
+	if paris.String() != defaultTimezone {
+		t.Fatalf("expected the digest to be sent in %s, got %s", defaultTimezone, paris)
+	}
+	for _, test := range []struct {
+		date time.Time
+		week string
+		due  bool
+	}{
+		{time.Date(2026, time.October, 12, 7, 59, 0, 0, paris), "2026-W42", false},
+		{time.Date(2026, time.October, 12, 8, 0, 0, 0, paris), "2026-W42", true},
+		{time.Date(2026, time.October, 18, 23, 0, 0, 0, paris), "2026-W42", true},
+		{time.Date(2026, time.October, 19, 0, 30, 0, 0, paris), "2026-W43", false},
+	} {
+		if week, due := digestWeek(test.date.UTC()); week != test.week || due != test.due {
+			t.Errorf("%v: expected %s due %v, got %s due %v", test.date, test.week, test.due, week, due)
+		}
+	}
+}
+
+func TestCompileDigest(t *testing.T) {
+	newTestStore()
+	alice, _ := newTestUser(t, "alice")
+	association := newTestAssociation(t, "BDE")
+	_, user, err := FollowAssociation(association.ID, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	seen, err := AddEvent(Event{Name: "Gala", Association: association.ID, DateStart: now.Add(time.Hour), DateEnd: now.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upcoming, _ := AddEvent(Event{Name: "Soirée", Association: association.ID, DateStart: now.Add(48 * time.Hour), DateEnd: now.Add(50 * time.Hour)})
+	AddEvent(Event{Name: "Voyage", Association: association.ID, DateStart: now.AddDate(0, 0, 10), DateEnd: now.AddDate(0, 0, 12)})
+	AddPost(Post{Title: "Ancienne news", Association: association.ID, Date: now.AddDate(0, 0, -8)})
+	post, _ := AddPost(Post{Title: "News", Association: association.ID, Date: now.Add(-time.Hour)})
+
+	data, err := compileDigest(user, DigestRecord{Events: []bson.ObjectId{seen.ID}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Events) != 1 || data.Events[0].ID != upcoming.ID {
+		t.Fatalf("expected the events of the next week not in the previous digest, got %+v", data.Events)
+	}
+	if len(data.Posts) != 1 || data.Posts[0].ID != post.ID || data.Posts[0].AssociationName != "BDE" {
+		t.Fatalf("expected the posts of the last week, got %+v", data.Posts)
+	}
+}
+
+func TestSendDigestOncePerWeek(t *testing.T) {
+	newTestStore()
+	user, _ := newTestUser(t, "alice")
+	subscription := DigestSubscription{User: user.ID, Token: "token"}
+	if err := sendDigest(subscription, "2026-W42", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.FindLastDigestRecord(user.ID)
+	if err != nil || record.Week != "2026-W42" || record.Status != DigestEmpty {
+		t.Fatalf("expected an empty digest to be recorded, got %+v %v", record, err)
+	}
+	if err := sendDigest(subscription, "2026-W42", time.Now()); err != ErrDuplicate {
+		t.Fatalf("expected the digest of the week to be sent once, got %v", err)
+	}
+	if err := sendDigest(subscription, "2026-W43", time.Now()); err != nil {
+		t.Fatalf("expected the digest of the next week to be sent, got %v", err)
+	}
+}
+
+func TestDigestSubscription(t *testing.T) {
+	newTestStore()
+	alice, token := newTestUser(t, "alice")
+	url := "/user/" + alice.ID.Hex() + "/digest"
+	expectError(t, serveTestRequest("POST", url, "", token), http.StatusBadRequest, "validation")
+	if _, err := UpdateUser(alice.ID, User{Username: "alice", Email: "alice@insa-rennes.fr"}); err != nil {
+		t.Fatal(err)
+	}
+	subscribed := func() bool {
+		t.Helper()
+		w := serveTestRequest("GET", url, "", token)
+		var body struct {
+			Subscribed bool `json:"subscribed"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("expected the subscription, got %d %v", w.Code, err)
+		}
+		return body.Subscribed
+	}
+	for i := 0; i < 2; i++ {
+		if w := serveTestRequest("POST", url, "", token); w.Code != http.StatusOK {
+			t.Fatalf("expected the subscription to succeed, got %d", w.Code)
+		}
+	}
+	if !subscribed() {
+		t.Fatal("expected alice to be subscribed")
+	}
+	subscription, _ := store.FindDigestSubscription(alice.ID)
+	expectError(t, serveTestRequest("POST", "/digest/unsubscribe", `{"token": "unknown"}`, ""), http.StatusBadRequest, ErrInvalidDigestToken.Code)
+	if w := serveTestRequest("POST", "/digest/unsubscribe", `{"token": "`+subscription.Token+`"}`, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the unsubscribe link to work without session, got %d", w.Code)
+	}
+	if subscribed() {
+		t.Fatal("expected alice to be unsubscribed")
+	}
+	if w := serveTestRequest("DELETE", url, "", token); w.Code != http.StatusOK {
+		t.Fatalf("expected unsubscribing twice to succeed, got %d", w.Code)
+	}
+}
+
+func TestDigestNeedsFollowedAssociation(t *testing.T) {
+	memory := newTestStore()
+	user, _ := newTestUser(t, "alice")
+	association, err := AddAssociation(Association{Name: "BDE", Email: "bde@insa-rennes.fr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddPost(Post{Title: "Soirée", Association: association.ID, Date: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := compileDigest(user, DigestRecord{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Posts) != 0 || len(data.Events) != 0 {
+		t.Fatalf("expected an empty digest, got %+v", data)
+	}
+	if _, _, err := FollowAssociation(association.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	user, _ = memory.FindUser(user.ID)
+	if data, _ = compileDigest(user, DigestRecord{}, time.Now()); len(data.Posts) != 1 {
+		t.Fatalf("expected the post of the followed association, got %+v", data)
+	}
+}
+
+func TestDigestOneClickUnsubscribe(t *testing.T) {
+	newTestStore()
+	user, err := AddUser(User{Username: "alice", Email: "alice@insa-rennes.fr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SubscribeToDigest(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := store.FindDigestSubscription(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/digest/unsubscribe/oneclick?token="+subscription.Token, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		NewRouter().ServeHTTP(w, r)
+		return w
+	}
+	expectError(t, post("List-Unsubscribe=Other"), http.StatusBadRequest, ErrBadRequest.Code)
+	if w := post("List-Unsubscribe=One-Click"); w.Code != http.StatusOK {
+		t.Fatalf("expected the one-click unsubscribe to succeed, got %d", w.Code)
+	}
+	if subscribed, _ := IsSubscribedToDigest(user.ID); subscribed {
+		t.Fatal("expected the user to be unsubscribed")
+	}
+	expectError(t, post("List-Unsubscribe=One-Click"), http.StatusBadRequest, ErrInvalidDigestToken.Code)
+}
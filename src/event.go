@@ -3,7 +3,6 @@ package main
 import (
 	"time"
 
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -12,6 +11,7 @@ type Event struct {
 	ID           	bson.ObjectId   `bson:"_id,omitempty"`
 	Name         	string          `json:"name"`
 	Association  	bson.ObjectId   `json:"association" bson:"association"`
+	Author       	bson.ObjectId   `json:"author,omitempty" bson:"author,omitempty"`
 	Description  	string          `json:"description"`
 	Participants 	[]bson.ObjectId `json:"participants" bson:"participants,omitempty"`
 	Status       	string          `json:"status"`
@@ -22,120 +22,112 @@ type Event struct {
 	Image     	 	string          `json:"image"`
 	BgColor      	string          `json:"bgColor"`
 	FgColor      	string          `json:"fgColor"`
+	// Broadcast asks to notify every user instead of the followers,
+	// it is only honored for an Official association
+	Broadcast     bool            `json:"broadcast" bson:"broadcast,omitempty"`
 }
 
 // Events is an array of Event
 type Events []Event
 
 // GetEvent returns an Event object from the given ID
-func GetEvent(id bson.ObjectId) Event {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("event")
-	var result Event
-	db.FindId(id).One(&result)
-	return result
+func GetEvent(id bson.ObjectId) (Event, error) {
+	return store.FindEvent(id)
 }
 
-// GetFutureEvents returns an array of Event objects
-// that will happen after "NOW"
-func GetFutureEvents() Events {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("event")
-	var result Events
-	var now = time.Now()
-	db.Find(bson.M{"dateend": bson.M{"$gt": now}}).All(&result)
-	return result
+// GetFutureEvents returns the given page of the Event objects that
+// are not over yet, soonest first, and the cursor of the next page
+func GetFutureEvents(page Page) (Events, *Cursor, error) {
+	events, err := store.FindEventsEndingAfter(time.Now(), page.peek())
+	if err != nil || !page.more(len(events)) {
+		return events, nil, err
+	}
+	events = events[:page.Limit]
+	last := events[len(events)-1]
+	return events, &Cursor{Date: last.DateStart, ID: last.ID}, nil
 }
 
 // AddEvent will add the Event event to the database
-func AddEvent(event Event) Event {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("event")
-	db.Insert(event)
-	var result Event
-	db.Find(bson.M{"name": event.Name, "datestart": event.DateStart}).One(&result)
-	AddEventToAssociation(result.Association, result.ID)
-	return result
+func AddEvent(event Event) (Event, error) {
+	if err := validateEvent(event); err != nil {
+		return Event{}, err
+	}
+	if _, err := store.FindAssociation(event.Association); err != nil {
+		return Event{}, err
+	}
+	event.ID = bson.NewObjectId()
+	if err := store.InsertEvent(event); err != nil {
+		return Event{}, err
+	}
+	if _, err := AddEventToAssociation(event.Association, event.ID); err != nil {
+		return Event{}, err
+	}
+	return store.FindEvent(event.ID)
 }
 
 // UpdateEvent will update the Event event in the database
-func UpdateEvent(id bson.ObjectId, event Event) Event {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("event")
-	eventID := bson.M{"_id": id}
-	change := bson.M{"$set": bson.M{
-		"name"					:	event.Name,
-		"description"		:	event.Description,
-		"status"				:	event.Status,
-		"image"					:	event.Image,
-		"palette"				:	event.Palette,
-		"selectedcolor"	:	event.SelectedColor,
-		"datestart"			:	event.DateStart,
-		"dateend"				:	event.DateEnd,
-		"bgcolor"				:	event.BgColor,
-		"fgcolor"				: event.FgColor,
-	}}
-	db.Update(eventID, change)
-	var result Event
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
+func UpdateEvent(id bson.ObjectId, event Event) (Event, error) {
+	if err := validateEvent(event); err != nil {
+		return Event{}, err
+	}
+	if err := store.UpdateEvent(id, event); err != nil {
+		return Event{}, err
+	}
+	return store.FindEvent(id)
 }
 
 // DeleteEvent will delete the given Event
-func DeleteEvent(event Event) Event {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("event")
-	db.Remove(event)
+func DeleteEvent(event Event) error {
+	if err := store.RemoveEvent(event.ID); err != nil {
+		return err
+	}
 	DeleteNotificationsForEvent(event.ID)
-	RemoveEventFromAssociation(event.Association, event.ID)
+	if _, err := RemoveEventFromAssociation(event.Association, event.ID); err != nil && err != ErrNotFound {
+		return err
+	}
 	for _, userId := range event.Participants{
-		RemoveEventFromUser(userId, event.ID)
+		if _, err := RemoveEventFromUser(userId, event.ID); err != nil && err != ErrNotFound {
+			return err
+		}
 	}
-	var result Event
-	db.Find(event.ID).One(result)
-	return result
+	return nil
 }
 
 // AddParticipant add the given userID to the given eventID as a participant
-func AddParticipant(id bson.ObjectId, userID bson.ObjectId) (Event, User) {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("event")
-	eventID := bson.M{"_id": id}
-	change := bson.M{"$addToSet": bson.M{
-		"participants": userID,
-	}}
-	db.Update(eventID, change)
-	var event Event
-	db.Find(bson.M{"_id": id}).One(&event)
-	user := AddEventToUser(userID, event.ID)
-	return event, user
+func AddParticipant(id bson.ObjectId, userID bson.ObjectId) (Event, User, error) {
+	if err := store.AddEventParticipant(id, userID); err != nil {
+		return Event{}, User{}, err
+	}
+	event, err := store.FindEvent(id)
+	if err != nil {
+		return Event{}, User{}, err
+	}
+	publishEvent(event)
+	user, err := AddEventToUser(userID, event.ID)
+	return event, user, err
 }
 
 // RemoveParticipant remove the given userID from the given eventID as a participant
-func RemoveParticipant(id bson.ObjectId, userID bson.ObjectId) (Event, User) {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("event")
-	eventID := bson.M{"_id": id}
-	change := bson.M{"$pull": bson.M{
-		"participants": userID,
-	}}
-	db.Update(eventID, change)
-	var event Event
-	db.Find(bson.M{"_id": id}).One(&event)
-	user := RemoveEventFromUser(userID, event.ID)
-	return event, user
+func RemoveParticipant(id bson.ObjectId, userID bson.ObjectId) (Event, User, error) {
+	if err := store.RemoveEventParticipant(id, userID); err != nil {
+		return Event{}, User{}, err
+	}
+	event, err := store.FindEvent(id)
+	if err != nil {
+		return Event{}, User{}, err
+	}
+	publishEvent(event)
+	user, err := RemoveEventFromUser(userID, event.ID)
+	return event, user, err
+}
+
+// validateEvent checks the fields of the given event before saving it
+func validateEvent(event Event) error {
+	if len(event.Name) == 0 {
+		return ValidationError("Le nom de l'événement est obligatoire")
+	}
+	if event.DateEnd.Before(event.DateStart) {
+		return ValidationError("L'événement doit finir après avoir commencé")
+	}
+	return nil
 }
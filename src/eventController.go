@@ -14,15 +14,29 @@ import (
 // from the given "id" in the URL. (cf Routes in routes.go)
 func GetEventController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	assocationID := vars["id"]
-	var res = GetEvent(bson.ObjectIdHex(assocationID))
+	eventID := vars["id"]
+	res, err := GetEvent(bson.ObjectIdHex(eventID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(res)
 }
 
 // GetFutureEventsController will answer a JSON
-// containing all future events from "NOW"
+// containing a page of the future events from "NOW"
 func GetFutureEventsController(w http.ResponseWriter, r *http.Request) {
-	var res = GetFutureEvents()
+	page, err := ParsePage(r, 50)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	res, next, err := GetFutureEvents(page)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	SetNextLink(w, r, page, next)
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -31,19 +45,27 @@ func GetFutureEventsController(w http.ResponseWriter, r *http.Request) {
 func AddEventController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var event Event
-	decoder.Decode(&event)
+	if err := decoder.Decode(&event); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
 
 	isValid := VerifyAssociationRequest(r, event.Association)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
 		return
 	}
 
-	res := AddEvent(event)
-	asso := GetAssociation(event.Association)
+	event.Author = GetPrincipal(r).ID
+	res, err := AddEvent(event)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	RecordActivity(res.Association, event.Author, "event:add", res.ID)
+	asso, _ := GetAssociation(event.Association)
 	json.NewEncoder(w).Encode(res)
-	go TriggerNotificationForEvent(asso.ID, res.ID, "@" + strings.ToLower(asso.Name) + " t'invite à " + res.Name + " 📅")
+	go TriggerNotificationForEvent(asso.ID, res.ID, NewLocalizedText("@{association} t'invite à {event} 📅", "association", strings.ToLower(asso.Name), "event", res.Name), res.Broadcast && asso.Official)
 }
 
 // UpdateEventController will answer the JSON
@@ -51,18 +73,30 @@ func AddEventController(w http.ResponseWriter, r *http.Request) {
 func UpdateEventController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var event Event
-	decoder.Decode(&event)
+	if err := decoder.Decode(&event); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
 	vars := mux.Vars(r)
 	eventID := vars["id"]
+	existing, err := GetEvent(bson.ObjectIdHex(eventID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 
-	isValid := VerifyAssociationRequest(r, event.Association)
+	isValid := VerifyAssociationRequest(r, existing.Association)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
 		return
 	}
 
-	res := UpdateEvent(bson.ObjectIdHex(eventID), event)
+	res, err := UpdateEvent(existing.ID, event)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	RecordActivity(existing.Association, GetPrincipal(r).ID, "event:update", res.ID)
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -70,17 +104,24 @@ func UpdateEventController(w http.ResponseWriter, r *http.Request) {
 // if the deletation has succeed
 func DeleteEventController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	event := GetEvent(bson.ObjectIdHex(vars["id"]))
+	event, err := GetEvent(bson.ObjectIdHex(vars["id"]))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 
 	isValid := VerifyAssociationRequest(r, event.Association)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
 		return
 	}
 
-	res := DeleteEvent(event)
-	json.NewEncoder(w).Encode(res)
+	if err := DeleteEvent(event); err != nil {
+		WriteError(w, err)
+		return
+	}
+	RecordActivity(event.Association, GetPrincipal(r).ID, "event:delete", event.ID)
+	json.NewEncoder(w).Encode(Event{})
 }
 
 // AddParticipantController will answer the JSON
@@ -91,11 +132,14 @@ func AddParticipantController(w http.ResponseWriter, r *http.Request) {
 	userID := bson.ObjectIdHex(vars["userID"])
 	isValid := VerifyUserRequest(r, userID)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
+		return
+	}
+	event, user, err := AddParticipant(eventID, userID)
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
-	event, user := AddParticipant(eventID, userID)
 	json.NewEncoder(w).Encode(bson.M{"event": event, "user": user})
 }
 
@@ -107,10 +151,13 @@ func RemoveParticipantController(w http.ResponseWriter, r *http.Request) {
 	userID := bson.ObjectIdHex(vars["userID"])
 	isValid := VerifyUserRequest(r, userID)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
+		return
+	}
+	event, user, err := RemoveParticipant(eventID, userID)
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
-	event, user := RemoveParticipant(eventID, userID)
 	json.NewEncoder(w).Encode(bson.M{"event": event, "user": user})
 }
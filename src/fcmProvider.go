@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	fcmScope    = "https://www.googleapis.com/auth/firebase.messaging"
+	fcmEndpoint = "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+)
+
+// FCMProvider is the PushProvider of android, sending each push with
+// the HTTP v1 API of Firebase Cloud Messaging. It is authenticated
+// by an access token of a service account, renewed before it expires
+type FCMProvider struct {
+	Project     string
+	ClientEmail string
+	TokenURL    string
+	Endpoint    string
+	key         *rsa.PrivateKey
+	client      *http.Client
+
+	mutex       sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+// fcmCredentials is the JSON key file of a Google service account
+type fcmCredentials struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// fcmError is the error answered by the FCM API
+type fcmError struct {
+	Error struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// NewFCMProvider returns the FCMProvider authenticated by the service account
+// of the given key file, sending to the given project or to the one of the key
+func NewFCMProvider(credentialsFile string, project string) (*FCMProvider, error) {
+	data, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	var credentials fcmCredentials
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(credentials.PrivateKey))
+	if block == nil {
+		return nil, errors.New("fcm: no private key in " + credentialsFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("fcm: the private key is not a RSA key")
+	}
+	if project == "" {
+		project = credentials.ProjectID
+	}
+	return &FCMProvider{
+		Project:     project,
+		ClientEmail: credentials.ClientEmail,
+		TokenURL:    credentials.TokenURI,
+		Endpoint:    fmt.Sprintf(fcmEndpoint, project),
+		key:         key,
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Send pushes the notification of the given job to each of its devices
+func (p *FCMProvider) Send(job PushJob) PushResult {
+	return sendEach(job, func(recipient PushRecipient) (pushOutcome, error) {
+		token, err := p.token("")
+		if err != nil {
+			return pushRetry, err
+		}
+		outcome, err := p.send(job.Notification, recipient, token)
+		if err == errExpiredToken {
+			if token, err = p.token(token); err != nil {
+				return pushRetry, err
+			}
+			outcome, err = p.send(job.Notification, recipient, token)
+		}
+		return outcome, err
+	})
+}
+
+// errExpiredToken is returned by a provider when its token has been refused
+var errExpiredToken = errors.New("expired provider token")
+
+func (p *FCMProvider) send(notification Notification, recipient PushRecipient, token string) (pushOutcome, error) {
+	data := pushData(notification, recipient)
+	data["badge"] = strconv.Itoa(recipient.Badge)
+	body, _ := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token": recipient.Token,
+			"data":  data,
+			"android": map[string]interface{}{
+				"priority": "high",
+			},
+		},
+	})
+	req, err := http.NewRequest("POST", p.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return pushFailed, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return pushRetry, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return pushDelivered, nil
+	}
+
+	var answer fcmError
+	json.NewDecoder(resp.Body).Decode(&answer)
+	code := answer.Error.Status
+	for _, detail := range answer.Error.Details {
+		if detail.ErrorCode != "" {
+			code = detail.ErrorCode
+		}
+	}
+	err = fmt.Errorf("fcm answered %s %s", resp.Status, code)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return pushRetry, errExpiredToken
+	case resp.StatusCode == http.StatusNotFound, code == "UNREGISTERED":
+		return pushUnregistered, err
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return pushRetry, err
+	}
+	return pushFailed, err
+}
+
+// token returns the access token of the service account, asking for a new
+// one if it is about to expire or if it is the given refused one. A token
+// refused after another push renewed it is not renewed again
+func (p *FCMProvider) token(refused string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.accessToken != "" && p.accessToken != refused && time.Now().Add(time.Minute).Before(p.expiry) {
+		return p.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := signJWT(
+		map[string]interface{}{"alg": "RS256", "typ": "JWT"},
+		map[string]interface{}{
+			"iss":   p.ClientEmail,
+			"scope": fcmScope,
+			"aud":   p.TokenURL,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		},
+		func(data []byte) ([]byte, error) {
+			hash := sha256.Sum256(data)
+			return rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, hash[:])
+		})
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.client.PostForm(p.TokenURL, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fcm token request answered %s", resp.Status)
+	}
+	var answer struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return "", err
+	}
+	p.accessToken = answer.AccessToken
+	p.expiry = now.Add(time.Duration(answer.ExpiresIn) * time.Second)
+	return p.accessToken, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// newTestFCM starts a stub FCM server and returns a FCMProvider sending to it.
+// The stub answers the pushes according to their device token and refuses
+// the first access token once if expire is set. It counts the access tokens
+func newTestFCM(t *testing.T, expire bool) (*FCMProvider, *int) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	tokens := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.URL.Path == "/token" {
+			if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(r.FormValue("assertion"), ".") != 2 {
+				t.Errorf("unexpected token request %v", r.Form)
+			}
+			tokens++
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access" + string(rune('0'+tokens)), "expires_in": 3600})
+			return
+		}
+		if expire && r.Header.Get("Authorization") == "Bearer access1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Message struct {
+				Token string            `json:"token"`
+				Data  map[string]string `json:"data"`
+			} `json:"message"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch body.Message.Token {
+		case "unregistered":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"status": "NOT_FOUND", "details": [{"errorCode": "UNREGISTERED"}]}}`))
+		case "invalid":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"status": "INVALID_ARGUMENT", "details": [{"errorCode": "INVALID_ARGUMENT"}]}}`))
+		case "unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": {"status": "UNAVAILABLE"}}`))
+		default:
+			if body.Message.Data["badge"] != "3" || body.Message.Data["type"] != "tag" {
+				t.Errorf("unexpected data %v", body.Message.Data)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return &FCMProvider{Project: "insapp", ClientEmail: "push@insapp.iam.gserviceaccount.com", TokenURL: server.URL + "/token",
+		Endpoint: server.URL + "/send", key: key, client: server.Client()}, &tokens
+}
+
+// newTestPushJobFor returns a job pushing a tag to the devices of the given tokens
+func newTestPushJobFor(os string, tokens ...string) PushJob {
+	job := PushJob{ID: bson.NewObjectId(), Os: os, Notification: Notification{Type: "tag", Message: "@bob t'a taggé", Comment: Comment{ID: bson.NewObjectId()}}}
+	for _, token := range tokens {
+		job.Recipients = append(job.Recipients, PushRecipient{Notification: bson.NewObjectId(), User: bson.NewObjectId(), Token: token, Badge: 3})
+	}
+	return job
+}
+
+// expectPushResult fails if the result does not retry and fail exactly the given tokens
+func expectPushResult(t *testing.T, result PushResult, retry []string, failed []string) {
+	t.Helper()
+	tokens := func(recipients []PushRecipient) string {
+		result := []string{}
+		for _, recipient := range recipients {
+			result = append(result, recipient.Token)
+		}
+		return strings.Join(result, ",")
+	}
+	if tokens(result.Retry) != strings.Join(retry, ",") || tokens(result.Failed) != strings.Join(failed, ",") {
+		t.Fatalf("expected to retry %v and fail %v, got %+v", retry, failed, result)
+	}
+}
+
+func TestFCMProviderSend(t *testing.T) {
+	provider, tokens := newTestFCM(t, false)
+	expectPushResult(t, provider.Send(newTestPushJobFor("android", "ok")), nil, nil)
+	result := provider.Send(newTestPushJobFor("android", "unregistered"))
+	expectPushResult(t, result, nil, []string{"unregistered"})
+	if len(result.Unregistered) != 1 {
+		t.Fatalf("expected the unregistered device to be forgotten, got %+v", result)
+	}
+	result = provider.Send(newTestPushJobFor("android", "invalid"))
+	expectPushResult(t, result, nil, []string{"invalid"})
+	if len(result.Unregistered) != 0 {
+		t.Fatalf("expected a device refusing an invalid message to be kept, got %+v", result)
+	}
+	expectPushResult(t, provider.Send(newTestPushJobFor("android", "unavailable")), []string{"unavailable"}, nil)
+	if *tokens != 1 {
+		t.Fatalf("expected the access token to be reused, got %d tokens", *tokens)
+	}
+}
+
+func TestFCMProviderRefreshesExpiredToken(t *testing.T) {
+	provider, tokens := newTestFCM(t, true)
+	expectPushResult(t, provider.Send(newTestPushJobFor("android", "ok")), nil, nil)
+	if *tokens != 2 {
+		t.Fatalf("expected a new access token after the refused one, got %d tokens", *tokens)
+	}
+	if token, _ := provider.token("access1"); token != "access2" || *tokens != 2 {
+		t.Fatalf("expected a token refused after its renewal not to be renewed again, got %s after %d tokens", token, *tokens)
+	}
+}
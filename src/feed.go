@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// feedHalfLife is the age at which an item of the feed has lost half of its recency
+const feedHalfLife = 24 * time.Hour
+
+// FeedItem is a Post or an Event of the feed. Date is the one it
+// was published at, the creation date for an Event
+type FeedItem struct {
+	Type  string    `json:"type"`
+	Date  time.Time `json:"date"`
+	Post  *Post     `json:"post,omitempty"`
+	Event *Event    `json:"event,omitempty"`
+	score float64
+}
+
+// FeedCursor is the position of a page of the feed, in the
+// posts on one side and in the events on the other side
+type FeedCursor struct {
+	Post  *Cursor `json:"p,omitempty"`
+	Event *Cursor `json:"e,omitempty"`
+}
+
+// FeedWeights defines how the items of a page of the feed are ranked:
+// the score of an item is the weighted sum of its recency, between 0 and 1,
+// and of the logarithm of its likes and of its participants
+type FeedWeights struct {
+	Recency      float64 `json:"recency"`
+	Likes        float64 `json:"likes"`
+	Participants float64 `json:"participants"`
+}
+
+// feedWeights are the FeedWeights used by GetFeed.
+// They are replaced in main by the ones of the config file, if any
+var feedWeights = FeedWeights{Recency: 1, Likes: 0.2, Participants: 0.2}
+
+// GetFeed will return the page of the feed of the given user following the
+// given cursor, and the cursor of the next page if there is one. The feed
+// interleaves the posts and the events of the associations followed by the
+// user, or of every association if the user follows none. A page holds the
+// latest items published before the cursor, ranked with the feedWeights
+func GetFeed(userID bson.ObjectId, limit int, after FeedCursor) ([]FeedItem, *FeedCursor, error) {
+	user, err := store.FindUser(userID)
+	if err != nil && err != ErrNotFound {
+		return nil, nil, err
+	}
+	var associations []bson.ObjectId
+	if len(user.Following) > 0 {
+		associations = user.Following
+	}
+	posts, err := store.FindPostsForAssociations(associations, Page{Limit: limit + 1, After: after.Post})
+	if err != nil {
+		return nil, nil, err
+	}
+	events, err := store.FindEventsForAssociations(associations, time.Now(), Page{Limit: limit + 1, After: after.Event})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := []FeedItem{}
+	next := after
+	postIndex, eventIndex := 0, 0
+	for len(items) < limit && (postIndex < len(posts) || eventIndex < len(events)) {
+		if eventIndex == len(events) || (postIndex < len(posts) && !posts[postIndex].Date.Before(events[eventIndex].ID.Time())) {
+			post := posts[postIndex]
+			items = append(items, FeedItem{Type: "post", Date: post.Date, Post: &post})
+			next.Post = &Cursor{Date: post.Date, ID: post.ID}
+			postIndex++
+		} else {
+			event := events[eventIndex]
+			items = append(items, FeedItem{Type: "event", Date: event.ID.Time(), Event: &event})
+			next.Event = &Cursor{ID: event.ID}
+			eventIndex++
+		}
+	}
+	rankFeed(items, time.Now())
+	if postIndex == len(posts) && eventIndex == len(events) {
+		return items, nil, nil
+	}
+	return items, &next, nil
+}
+
+// rankFeed sorts the given items by decreasing score
+func rankFeed(items []FeedItem, now time.Time) {
+	for i := range items {
+		item := &items[i]
+		recency := math.Pow(0.5, float64(now.Sub(item.Date))/float64(feedHalfLife))
+		item.score = feedWeights.Recency * math.Min(recency, 1)
+		if item.Post != nil {
+			item.score += feedWeights.Likes * math.Log1p(float64(len(item.Post.Likes)))
+		}
+		if item.Event != nil {
+			item.score += feedWeights.Participants * math.Log1p(float64(len(item.Event.Participants)))
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].score > items[j].score })
+}
+
+// valid returns whether the positions of the cursor are valid
+func (c FeedCursor) valid() bool {
+	return (c.Post == nil || c.Post.ID.Valid()) && (c.Event == nil || c.Event.ID.Valid())
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// GetFeedController will answer a JSON of a page of the feed
+// of the user of the request, 30 items by default
+func GetFeedController(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r, 30)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	var cursor FeedCursor
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		if err := decodeCursor(value, &cursor); err != nil || !cursor.valid() {
+			WriteError(w, ErrInvalidPage)
+			return
+		}
+	}
+	items, next, err := GetFeed(GetPrincipal(r).ID, limit, cursor)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if next != nil {
+		setNextLink(w, r, limit, encodeCursor(next))
+	}
+	json.NewEncoder(w).Encode(items)
+}
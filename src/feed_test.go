@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRankFeed(t *testing.T) {
+	now := time.Now()
+	old := FeedItem{Type: "post", Date: now.Add(-72 * time.Hour), Post: &Post{Title: "old"}}
+	recent := FeedItem{Type: "post", Date: now.Add(-time.Hour), Post: &Post{Title: "recent"}}
+	liked := FeedItem{Type: "post", Date: now.Add(-time.Hour), Post: &Post{Title: "liked", Likes: []bson.ObjectId{bson.NewObjectId(), bson.NewObjectId()}}}
+	crowded := FeedItem{Type: "event", Date: now.Add(-72 * time.Hour), Event: &Event{Name: "crowded", Participants: make([]bson.ObjectId, 500)}}
+	items := []FeedItem{old, recent, crowded, liked}
+	rankFeed(items, now)
+	expected := []string{"crowded", "liked", "recent", "old"}
+	for i, item := range items {
+		name := ""
+		if item.Post != nil {
+			name = item.Post.Title
+		} else {
+			name = item.Event.Name
+		}
+		if name != expected[i] {
+			t.Fatalf("expected the order %v, got %s at %d", expected, name, i)
+		}
+	}
+}
+
+func TestFeedOfFollowedAssociations(t *testing.T) {
+	newTestStore()
+	followed := newTestAssociation(t, "BDE")
+	other := newTestAssociation(t, "BDS")
+	alice, _ := newTestUser(t, "alice")
+	for i := 0; i < 3; i++ {
+		for _, association := range []Association{followed, other} {
+			if _, err := AddPost(Post{Title: "News", Association: association.ID, Date: time.Now().Add(-time.Duration(i) * time.Hour)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := AddEvent(Event{Name: "Gala", Association: followed.ID, DateStart: time.Now().Add(time.Hour), DateEnd: time.Now().Add(2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if items, _, _ := GetFeed(alice.ID, 20, FeedCursor{}); len(items) != 7 {
+		t.Fatalf("expected every item when following nothing, got %d", len(items))
+	}
+	if _, _, err := FollowAssociation(followed.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[bson.ObjectId]bool{}
+	cursor := FeedCursor{}
+	for pages := 1; ; pages++ {
+		items, next, err := GetFeed(alice.ID, 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range items {
+			id, association := bson.ObjectId(""), bson.ObjectId("")
+			if item.Post != nil {
+				id, association = item.Post.ID, item.Post.Association
+			} else {
+				id, association = item.Event.ID, item.Event.Association
+			}
+			if association != followed.ID || seen[id] {
+				t.Fatalf("unexpected item %+v", item)
+			}
+			seen[id] = true
+		}
+		if next == nil {
+			break
+		}
+		if pages == 2 {
+			t.Fatal("expected 2 pages")
+		}
+		cursor = *next
+	}
+	if len(seen) != 4 {
+		t.Fatalf("expected the 3 posts and the event of the followed association, got %d", len(seen))
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultLocale is the language of the texts of the code, and the
+// one of the users and devices which have not set a supported locale
+const defaultLocale = "fr"
+
+// locales are the supported languages
+var locales = []string{"fr", "en"}
+
+// catalogue holds the translations of the texts shown to the users, by
+// locale then by French text. A text may hold parameters such as
+// {association}, replaced when rendering it. A text missing from the
+// catalogue of a locale is shown in French
+var catalogue = map[string]map[string]string{
+	"en": {
+		// errors
+		"Mauvais Format":                                       "Bad format",
+		"Authentification requise":                             "Authentication required",
+		"Identifiants incorrects":                              "Wrong credentials",
+		"Contenu Protégé":                                      "Protected content",
+		"Contenu Inexistant":                                   "Content not found",
+		"Contenu déjà existant":                                "Content already exists",
+		"Une erreur est survenue":                              "An error occurred",
+		"Échec de l'envoi de l'image":                          "Failed to upload the image",
+		"Impossible de verfifier l'identité":                   "Unable to verify your identity",
+		"Invitation invalide ou expirée":                       "Invalid or expired invitation",
+		"Déjà membre ou invité":                                "Already a member or invited",
+		"Rôle de membre invalide":                              "Invalid member role",
+		"Rôle inconnu":                                         "Unknown role",
+		"Lien de réinitialisation invalide ou expiré":          "Invalid or expired reset link",
+		"Mot de passe incorrect":                               "Wrong password",
+		"Lien de désinscription invalide":                      "Invalid unsubscribe link",
+		"Token de notification manquant":                       "Missing notification token",
+		"Identifiant manquant":                                 "Missing username",
+		"Cet identifiant est déjà utilisé":                     "This username is already taken",
+		"Appareil manquant":                                    "Missing device",
+		"Adresse email invalide":                               "Invalid email address",
+		"Adresse email manquante":                              "Missing email address",
+		"Le mot de passe doit contenir au moins 10 caractères": "The password must be at least 10 characters long",
+		"Le mot de passe doit faire au plus 72 octets":         "The password must be at most 72 bytes long",
+		"Le mot de passe doit contenir des lettres et des chiffres ou symboles": "The password must contain letters and digits or symbols",
+		"Le mot de passe ne doit pas contenir l'identifiant":                    "The password must not contain the username",
+		"Le nom de l'événement est obligatoire":                                 "The name of the event is required",
+		"L'événement doit finir après avoir commencé":                           "The event must end after it starts",
+		"Le nom et l'email de l'association sont obligatoires":                  "The name and the email of the association are required",
+		"Une association porte déjà ce nom":                                     "An association already has this name",
+		"Le titre de la news est obligatoire":                                   "The title of the news is required",
+		"Le commentaire est vide":                                               "The comment is empty",
+		"Fuseau horaire invalide":                                               "Invalid timezone",
+		"Heure de début invalide":                                               "Invalid start time",
+		"Heure de fin invalide":                                                 "Invalid end time",
+		"Le commentaire auquel tu réponds n'existe pas":                         "The comment you reply to does not exist",
+		"Trop de demandes, réessaie plus tard":                                  "Too many requests, try again later",
+		"Annonce déjà envoyée":                                                  "Announcement already sent",
+		"Le message de l'annonce est obligatoire":                               "The message of the announcement is required",
+		"Le message de l'annonce est trop long":                                 "The message of the announcement is too long",
+		"Promotion inconnue":                                                    "Unknown promotion",
+		"Année d'étude inconnue":                                                "Unknown study year",
+		"Cible de l'annonce inconnue":                                           "Unknown announcement target",
+		"Langue non prise en charge":                                            "Unsupported language",
+		"Utilisateur taggé invalide":                                            "Invalid tagged user",
+		"Trop de contenus suivis":                                               "Too many watched contents",
+
+		// notifications
+		"@{association} a posté une nouvelle news 📰":                        "@{association} posted a new news 📰",
+		"@{association} t'invite à {event} 📅":                               "@{association} invites you to {event} 📅",
+		"@{association} t'invite à rejoindre son bureau":                    "@{association} invites you to join its board",
+		"@{user} a commenté \"{post}\"":                                     "@{user} commented on \"{post}\"",
+		"@{user} et 1 autre personne ont commenté \"{post}\"":               "@{user} and 1 other person commented on \"{post}\"",
+		"@{user} et {others} autres personnes ont commenté \"{post}\"":      "@{user} and {others} other people commented on \"{post}\"",
+		"@{user} t'a répondu sur \"{post}\"":                                "@{user} replied to you on \"{post}\"",
+		"@{user} et 1 autre personne t'ont répondu sur \"{post}\"":          "@{user} and 1 other person replied to you on \"{post}\"",
+		"@{user} et {others} autres personnes t'ont répondu sur \"{post}\"": "@{user} and {others} other people replied to you on \"{post}\"",
+		"@{user} t'a taggé sur \"{post}\"":                                  "@{user} tagged you on \"{post}\"",
+		"@{user} a aimé \"{post}\"":                                         "@{user} liked \"{post}\"",
+		"@{user} et 1 autre personne ont aimé \"{post}\"":                   "@{user} and 1 other person liked \"{post}\"",
+		"@{user} et {others} autres personnes ont aimé \"{post}\"":          "@{user} and {others} other people liked \"{post}\"",
+	},
+}
+
+// LocalizedText is a text of the catalogue with its parameters,
+// rendered in the language of each of its readers
+type LocalizedText struct {
+	Text   string            `json:"text"`
+	Params map[string]string `json:"params,omitempty" bson:"params,omitempty"`
+}
+
+// NewLocalizedText returns the LocalizedText of the given French
+// text, with the parameters given as name and value pairs
+func NewLocalizedText(text string, params ...string) LocalizedText {
+	result := LocalizedText{Text: text}
+	if len(params) > 0 {
+		result.Params = map[string]string{}
+		for i := 0; i+1 < len(params); i += 2 {
+			result.Params[params[i]] = params[i+1]
+		}
+	}
+	return result
+}
+
+// In returns the text translated in the given locale, with its parameters
+func (t LocalizedText) In(locale string) string {
+	result := Translate(locale, t.Text)
+	for name, value := range t.Params {
+		result = strings.Replace(result, "{"+name+"}", value, -1)
+	}
+	return result
+}
+
+// Translate returns the given French text translated in the given locale
+func Translate(locale string, text string) string {
+	if translated, ok := catalogue[locale][text]; ok {
+		return translated
+	}
+	return text
+}
+
+// supportedLocale returns the given locale if it is
+// supported, or an empty string otherwise
+func supportedLocale(locale string) string {
+	locale = strings.ToLower(locale)
+	for _, supported := range locales {
+		if locale == supported {
+			return locale
+		}
+	}
+	return ""
+}
+
+// localeOrDefault returns the first of the given locales which
+// is supported, or the defaultLocale if none is
+func localeOrDefault(candidates ...string) string {
+	for _, locale := range candidates {
+		if supported := supportedLocale(locale); supported != "" {
+			return supported
+		}
+	}
+	return defaultLocale
+}
+
+// requestLocale returns the preferred supported locale of the Accept-Language
+// header of the request, such as "en" for "en-GB,en;q=0.9,fr;q=0.8"
+func requestLocale(r *http.Request) string {
+	candidates := []string{}
+	for _, language := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag := strings.TrimSpace(strings.SplitN(language, ";", 2)[0])
+		candidates = append(candidates, strings.SplitN(tag, "-", 2)[0])
+	}
+	return localeOrDefault(candidates...)
+}
+
+// localeWriter is the http.ResponseWriter of a request,
+// with its locale used by WriteError to translate the errors
+type localeWriter struct {
+	http.ResponseWriter
+	locale string
+}
+
+// Flush lets the streaming handlers flush through the localeWriter
+func (w *localeWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// WithLocale is the middleware giving to the handler the
+// locale of the request, from its Accept-Language header
+func WithLocale(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(&localeWriter{ResponseWriter: w, locale: requestLocale(r)}, r)
+	})
+}
+
+// writerLocale returns the locale of the request of the given writer
+func writerLocale(w http.ResponseWriter) string {
+	if writer, ok := w.(*localeWriter); ok {
+		return writer.locale
+	}
+	return defaultLocale
+}
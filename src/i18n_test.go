@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRequestLocale(t *testing.T) {
+	for header, locale := range map[string]string{
+		"":                        "fr",
+		"en-GB,en;q=0.9,fr;q=0.8": "en",
+		"de-DE,de;q=0.9,en;q=0.8": "en",
+		"de-DE, FR-fr;q=0.5":      "fr",
+		"es":                      "fr",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", header)
+		if result := requestLocale(r); result != locale {
+			t.Errorf("%q: expected %s, got %s", header, locale, result)
+		}
+	}
+}
+
+func TestLocalizedText(t *testing.T) {
+	text := NewLocalizedText("@{association} t'invite à {event} 📅", "association", "bde", "event", "Gala")
+	if result := text.In("en"); result != "@bde invites you to Gala 📅" {
+		t.Fatalf("expected the english text, got %q", result)
+	}
+	if result := text.In("fr"); result != "@bde t'invite à Gala 📅" {
+		t.Fatalf("expected the french text, got %q", result)
+	}
+	if result := NewLocalizedText("Texte sans traduction").In("en"); result != "Texte sans traduction" {
+		t.Fatalf("expected a missing translation to be shown in french, got %q", result)
+	}
+}
+
+func TestErrorsInRequestLocale(t *testing.T) {
+	newTestStore()
+	for locale, text := range map[string]string{"en-US,en;q=0.9": "Authentication required", "": "Authentification requise"} {
+		r := httptest.NewRequest("GET", "/user/"+bson.NewObjectId().Hex(), nil)
+		r.Header.Set("Accept-Language", locale)
+		w := httptest.NewRecorder()
+		NewRouter().ServeHTTP(w, r)
+		var apiErr APIError
+		if err := json.NewDecoder(w.Body).Decode(&apiErr); err != nil || w.Code != http.StatusUnauthorized || apiErr.Text != text {
+			t.Fatalf("%q: expected %q, got %d %+v %v", locale, text, w.Code, apiErr, err)
+		}
+	}
+	if ErrUnauthenticated.Text != "Authentification requise" {
+		t.Fatal("expected the translation not to change the shared error")
+	}
+}
+
+func TestNotificationsInReceiverLocale(t *testing.T) {
+	newTestStore()
+	alice, _ := newTestUser(t, "alice")
+	if _, err := UpdateUser(alice.ID, User{Username: "alice", Locale: "en"}); err != nil {
+		t.Fatal(err)
+	}
+	text := NewLocalizedText("@{user} t'a taggé sur \"{post}\"", "user", "bob", "post", "Soirée")
+	notification, err := AddNotification(Notification{Receiver: alice.ID, Type: "tag", Message: text.In(defaultLocale), Text: &text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications, _, err := GetNotificationsForUser(alice.ID, NotificationFilter{}, Page{Limit: 10})
+	if err != nil || len(notifications) != 1 || notifications[0].Message != `@bob tagged you on "Soirée"` {
+		t.Fatalf("expected the notification in english, got %+v %v", notifications, err)
+	}
+	recipient := PushRecipient{Notification: notification.ID, User: alice.ID, Token: "token"}
+	if data := pushData(notification, recipient); data["message"] != `@bob t'a taggé sur "Soirée"` {
+		t.Fatalf("expected a device without locale to be pushed in french, got %v", data)
+	}
+	recipient.Locale = "en"
+	if data := pushData(notification, recipient); data["message"] != `@bob tagged you on "Soirée"` {
+		t.Fatalf("expected the push in the locale of the device, got %v", data)
+	}
+}
+
+func TestDeviceLocaleFromRequest(t *testing.T) {
+	memory := newTestStore()
+	alice, token := newTestUser(t, "alice")
+	r := httptest.NewRequest("POST", "/notification", strings.NewReader(`{"userid": "`+alice.ID.Hex()+`", "token": "phone", "os": "iOS"}`))
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Accept-Language", "en-GB,en;q=0.9")
+	w := httptest.NewRecorder()
+	NewRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the device to be registered, got %d", w.Code)
+	}
+	if devices, _ := memory.FindNotificationUsersForUser(alice.ID); len(devices) != 1 || devices[0].Locale != "en" {
+		t.Fatalf("expected the device to be in english, got %+v", devices)
+	}
+}
@@ -9,12 +9,13 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// ErrImageUpload is returned when the uploaded image can not be saved
+var ErrImageUpload = NewAPIError(http.StatusNotAcceptable, "image_upload", "the image could not be uploaded", "Échec de l'envoi de l'image")
 
 func UploadNewImageController(w http.ResponseWriter, r *http.Request) {
 	fileName := UploadImage(r)
 	if fileName == "error" {
-		w.WriteHeader(http.StatusNotAcceptable)
-		json.NewEncoder(w).Encode(bson.M{"error": "Failed to upload image"})
+		WriteError(w, ErrImageUpload)
 	} else {
     width, height := GetImageDimension(fileName)
     colors := GetImageColors(fileName)
@@ -27,8 +28,7 @@ func UploadImageController(w http.ResponseWriter, r *http.Request) {
   vars := mux.Vars(r)
   fileName := UploadImageWithName(r, vars["name"])
 	if fileName == "error" {
-		w.WriteHeader(http.StatusNotAcceptable)
-		json.NewEncoder(w).Encode(bson.M{"error": "Failed to upload image"})
+		WriteError(w, ErrImageUpload)
 	} else {
 		width, height := GetImageDimension(fileName)
     colors := GetImageColors(fileName)
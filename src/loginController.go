@@ -1,17 +1,13 @@
 package main
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"net/http"
   "os/exec"
 	"strings"
-	"io/ioutil"
-	"github.com/freehaha/token-auth/memory"
+	"time"
+	"log"
 	"github.com/gorilla/mux"
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -27,6 +23,8 @@ type Credentials struct {
 	AuthToken string				`json:"authtoken"`
 	User 			bson.ObjectId	`json:"user" bson:"user"`
 	Device 		string	 			`json:"device"`
+	CreatedAt time.Time			`json:"createdat"`
+	LastUsed	time.Time			`json:"lastused"`
 }
 
 type AssociationUser struct {
@@ -34,37 +32,57 @@ type AssociationUser struct {
 	Username    string        `json:"username"`
 	Association bson.ObjectId `json:"association" bson:"association"`
 	Password    string        `json:"password"`
+	Algorithm   string        `json:"-" bson:"algorithm,omitempty"`
 	Master      bool          `json:"master"`
+	Role        Role          `json:"role" bson:"role,omitempty"`
 	Owner       bson.ObjectId `json:"owner" bson:"owner,omitempty"`
+	// Member is the AssociationMember this account has been created for, if any
+	Member bson.ObjectId `json:"member,omitempty" bson:"member,omitempty"`
 }
 
 func LogAssociationController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var login Login
-	decoder.Decode(&login)
-	auth, master, err := checkLoginForAssociation(login)
-	if err == nil {
-		sessionToken := logAssociation(auth, master)
-		json.NewEncoder(w).Encode(bson.M{"token": sessionToken.Token, "master": master, "associationID": auth})
-	} else {
-		w.WriteHeader(http.StatusNotAcceptable)
-		json.NewEncoder(w).Encode(bson.M{"error": "Failed to authentificate"})
+	if err := decoder.Decode(&login); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	user, err := checkLoginForAssociation(login)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	sessionToken, err := logAssociation(user)
+	if err != nil {
+		WriteError(w, err)
+		return
 	}
+	json.NewEncoder(w).Encode(bson.M{"token": sessionToken.Token, "master": user.Master, "associationID": user.Association, "roles": sessionToken.Roles})
 }
 
 func LogUserController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var credentials Credentials
-	decoder.Decode(&credentials)
+	if err := decoder.Decode(&credentials); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
 	cred, err := checkLoginForUser(credentials)
-	if err == nil {
-		sessionToken := logUser(cred.User)
-		user := GetUser(cred.User)
-		json.NewEncoder(w).Encode(bson.M{"credentials": credentials, "sessionToken": sessionToken, "user": user})
-	} else {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(bson.M{"error": err})
+	if err != nil {
+		WriteError(w, err)
+		return
 	}
+	user, err := GetUser(cred.User)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	sessionToken, err := logUser(cred, user)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"credentials": credentials, "sessionToken": sessionToken, "user": user})
 }
 
 func SignInUserController(w http.ResponseWriter, r *http.Request) {
@@ -79,33 +97,39 @@ func SignInUserController(w http.ResponseWriter, r *http.Request) {
 	// json.NewEncoder(w).Encode(bson.M{"error": "De manière temporaire, les inscriptions sont désactivées. Réessaye Lundi 😊" })
 	// return
 
-	username, err := verifyTicket(ticket)
-	login.Username = username
+	cas, err := casClient.Validate(ticket)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	login.Username = cas.User
 
 	if login.Username == "fthomasm" {
 		login.Username = "fthomasm" + RandomString(4)
 	}
 
-	if err == nil && len(login.Username) > 0 && len(login.Device) > 0 {
-		session, _ := mgo.Dial("127.0.0.1")
-		defer session.Close()
-		session.SetMode(mgo.Monotonic, true)
-		db := session.DB("insapp").C("user")
-		count, _ := db.Find(bson.M{"username": login.Username}).Count()
-		var user User
-		if count == 0 {
-			user = AddUser(User{Name: "", Username: login.Username, Description: "", Email: "", EmailPublic: false, Promotion: "", Events: []bson.ObjectId{}, PostsLiked: []bson.ObjectId{}})
-		}else{
-			db.Find(bson.M{"username": login.Username}).One(&user)
-		}
-		token := generateAuthToken()
-		credentials := Credentials{AuthToken: token, User: user.ID, Username: user.Username, Device: login.Device}
-		result := addCredentials(credentials)
-		json.NewEncoder(w).Encode(result)
-	} else {
-		w.WriteHeader(http.StatusNotAcceptable)
-		json.NewEncoder(w).Encode(bson.M{"error": err})
+	if len(login.Device) == 0 {
+		WriteError(w, ValidationError("Appareil manquant"))
+		return
+	}
+	user, err := GetUserByUsername(strings.ToLower(login.Username))
+	if err == ErrNotFound {
+		newUser := casClient.NewUserFromCAS(cas)
+		newUser.Username = login.Username
+		user, err = AddUser(newUser)
+	}
+	if err != nil {
+		WriteError(w, err)
+		return
 	}
+	token := generateAuthToken()
+	credentials := Credentials{AuthToken: token, User: user.ID, Username: user.Username, Device: login.Device}
+	result, err := addCredentials(credentials)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
 }
 
 func generateAuthToken() (string){
@@ -113,95 +137,81 @@ func generateAuthToken() (string){
 	return strings.TrimSpace(string(out))
 }
 
-func DeleteCredentialsForUser(id bson.ObjectId){
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("credentials")
-	db.Remove(bson.M{"user": id})
+func DeleteCredentialsForUser(id bson.ObjectId) error {
+	return store.RemoveCredentialsForUser(id)
 }
 
-func addCredentials(credentials Credentials) (Credentials){
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("credentials")
-	var cred Credentials
-	db.Find(bson.M{"username": credentials.Username}).One(&cred)
-	db.RemoveId(cred.ID)
-	db.Insert(credentials)
-	var result Credentials
-	db.Find(bson.M{"username": credentials.Username}).One(&result)
-	return result
+// addCredentials will store the given credentials, replacing the ones
+// of the same device. The other devices of the user keep their own
+func addCredentials(credentials Credentials) (Credentials, error) {
+	cred, err := store.FindCredentialsForDevice(credentials.Username, credentials.Device)
+	if err == nil {
+		err = RevokeCredentials(cred)
+	}
+	if err != nil && err != ErrNotFound {
+		return Credentials{}, err
+	}
+	credentials.ID = bson.NewObjectId()
+	credentials.CreatedAt = time.Now()
+	credentials.LastUsed = credentials.CreatedAt
+	if err := store.InsertCredentials(credentials); err != nil {
+		return Credentials{}, err
+	}
+	return credentials, nil
 }
 
-func checkLoginForAssociation(login Login) (bson.ObjectId, bool, error) {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("association_user")
-	var result []AssociationUser
-	db.Find(bson.M{"username": login.Username, "password": GetMD5Hash(login.Password)}).All(&result)
-	if len(result) > 0 {
-		return result[0].Association, result[0].Master, nil
-	}
-	return bson.ObjectId(""), false, errors.New("Failed to authentificate")
+func checkLoginForAssociation(login Login) (AssociationUser, error) {
+	result, err := store.FindAssociationUsersByUsername(login.Username)
+	if err != nil {
+		return AssociationUser{}, err
+	}
+	if len(result) == 0 {
+		checkDummyPassword(login.Password)
+	}
+	for _, user := range result {
+		if CheckPassword(user, login.Password) {
+			if NeedsRehash(user) {
+				rehashAssociationUserPassword(user, login.Password)
+			}
+			return user, nil
+		}
+	}
+	return AssociationUser{}, ErrWrongLogin
 }
 
-func verifyTicket(ticket string) (string, error){
-	response, err := http.Get("https://cas.insa-rennes.fr/cas/serviceValidate?service=https%3A%2F%2Finsapp.fr%2F&ticket=" + ticket)
-  if err != nil {
-		return "", errors.New("Impossible de verfifier l'identité")
-  }
-  defer response.Body.Close()
-
-	htmlData, err := ioutil.ReadAll(response.Body)
+// rehashAssociationUserPassword will store the password of the given
+// AssociationUser again, hashed with the current algorithm
+func rehashAssociationUserPassword(user AssociationUser, password string) {
+	hash, algorithm, err := HashPassword(password)
 	if err != nil {
-		return "", errors.New("Impossible de verfifier l'identité")
-  }
-  xml := string(htmlData)
-	if !strings.Contains(xml, "<cas:authenticationSuccess>") && !strings.Contains(xml, "<cas:user>"){
-		return "", errors.New("Impossible de verfifier l'identité")
+		log.Println("[error] Failed to rehash password of", user.Username, err)
+		return
 	}
-
-	username := strings.Split(xml, "<cas:user>")[1]
-	username = strings.Split(username, "</cas:user>")[0]
-
-	if !(len(username) > 5) {
-		return "", errors.New("Impossible de verfifier l'identité")
+	if err := store.UpdateAssociationUserPassword(user.ID, hash, algorithm); err != nil {
+		log.Println("[error] Failed to store the rehashed password of", user.Username, err)
 	}
-	return username, nil
 }
 
 func checkLoginForUser(credentials Credentials) (Credentials, error) {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("credentials")
-	var result []Credentials
-	db.Find(bson.M{"username": credentials.Username, "authtoken": credentials.AuthToken}).All(&result)
-	if len(result) > 0 {
-		return result[0], nil
-	}
-	return Credentials{}, errors.New("Wrong Credentials")
-}
-
-func logAssociation(id bson.ObjectId, master bool) *memstore.MemoryToken {
-	if master {
-		memStoreUser.NewToken(id.Hex())
-		memStoreAssociationUser.NewToken(id.Hex())
-		return memStoreSuperUser.NewToken(id.Hex())
+	result, err := store.FindCredentials(credentials.Username, credentials.AuthToken)
+	if err == ErrNotFound {
+		return Credentials{}, ErrWrongLogin
+	}
+	if err != nil {
+		return Credentials{}, err
 	}
-	memStoreUser.NewToken(id.Hex())
-	return memStoreAssociationUser.NewToken(id.Hex())
+	result.LastUsed = time.Now()
+	err = store.SetCredentialsLastUsed(result.ID, result.LastUsed)
+	return result, err
 }
 
-func logUser(id bson.ObjectId) *memstore.MemoryToken {
-	return memStoreUser.NewToken(id.Hex())
+func logAssociation(user AssociationUser) (*SessionToken, error) {
+	principal := Principal{ID: user.ID, Association: user.Association, Roles: GetAssociationUserRoles(user)}
+	return NewSessionToken(principal, "")
 }
 
-func GetMD5Hash(text string) string {
-	hasher := md5.New()
-	hasher.Write([]byte(text))
-	return hex.EncodeToString(hasher.Sum(nil))
+func logUser(credentials Credentials, user User) (*SessionToken, error) {
+	principal := Principal{ID: credentials.User, Roles: GetUserRoles(user)}
+	return NewSessionToken(principal, credentials.ID)
 }
+
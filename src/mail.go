@@ -1,23 +1,420 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
 )
 
+// Security of the connection of a SMTPMailer
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPPlain    = "none"
+)
+
+const (
+	// mailQueueSize is how many emails wait to be sent before QueueEmail drops them
+	mailQueueSize = 256
+	// mailBackoff is the delay before the first retry, doubled at each attempt
+	mailBackoff     = 30 * time.Second
+	mailMaxAttempts = 5
+	mailTimeout     = 30 * time.Second
+)
+
+// Email is an email to send, with a plain-text and optionally
+// an HTML version of its body, and extra headers
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string
+}
+
+// Mailer sends the emails
+type Mailer interface {
+	Send(email Email) error
+}
+
+// mailer is the Mailer of the API. It is set in main from the config file
+var mailer Mailer = &MemoryMailer{}
+
+// ErrMailQueueFull is returned when an email is queued while the mail queue is full
+var ErrMailQueueFull = errors.New("the mail queue is full")
+
+// MailLinks are the pages the emails link to, the token of each email being
+// appended to them. DigestOneClick is the one-click unsubscribe route of the
+// API, and is not used if empty
+type MailLinks struct {
+	PasswordReset     string
+	Invitation        string
+	DigestUnsubscribe string
+	DigestOneClick    string
+}
+
+// mailLinks are the MailLinks of the emails. They are set in main from the config file
+var mailLinks = NewMailLinks(Config{})
+
+// NewMailLinks returns the MailLinks of the config, the pages of insapp.fr by default
+func NewMailLinks(config Config) MailLinks {
+	links := MailLinks{
+		PasswordReset:     config.PasswordResetURL,
+		Invitation:        config.InvitationURL,
+		DigestUnsubscribe: config.DigestUnsubscribeURL,
+		DigestOneClick:    config.DigestOneClickURL,
+	}
+	if links.PasswordReset == "" {
+		links.PasswordReset = "https://insapp.fr/reset-password?token="
+	}
+	if links.Invitation == "" {
+		links.Invitation = "https://insapp.fr/invitation?token="
+	}
+	if links.DigestUnsubscribe == "" {
+		links.DigestUnsubscribe = "https://insapp.fr/digest/unsubscribe?token="
+	}
+	return links
+}
+
+// NewMailer returns the Mailer selected by the MailTransport of the config:
+// a SMTPMailer by default, a FileMailer writing the emails to MailDirectory
+// for "file", or a MemoryMailer keeping them for "memory"
+func NewMailer(config Config) (Mailer, error) {
+	switch config.MailTransport {
+	case "", "smtp":
+		return NewSMTPMailer(config), nil
+	case "file":
+		return NewFileMailer(config.MailDirectory, mailFrom(config))
+	case "memory":
+		return &MemoryMailer{}, nil
+	}
+	return nil, fmt.Errorf("unknown mail transport %q", config.MailTransport)
+}
 
+// SendEmail will queue a plain-text email to the given address
 func SendEmail(to string, subject string, body string) {
-	config, _ := Configuration()
-  from := config.Email
-	pass := config.Password
-	cc := config.Email
-
-	msg := "From: " + from + "\n" +
-		"To: " + from + "\n" +
-    "Cc: " + cc + "\n" +
-		"Subject: " + subject + "\n\n" +
-		body
-
-	smtp.SendMail("smtp.gmail.com:587",
-		smtp.PlainAuth("", from, pass, "smtp.gmail.com"),
-		from, []string{from}, []byte(msg))
+	QueueEmail(Email{To: to, Subject: subject, Text: body})
+}
+
+// Message returns the MIME message of the email sent by the given address:
+// a multipart/alternative one if it has an HTML body. It returns an error if
+// an address is invalid or a header holds a line break, which would let its
+// value inject other headers
+func (e Email) Message(from string) ([]byte, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %v", from, err)
+	}
+	recipient, err := mail.ParseAddress(e.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient address %q: %v", e.To, err)
+	}
+	if strings.ContainsAny(e.Subject, "\r\n") {
+		return nil, errors.New("line break in the subject")
+	}
+	keys := []string{}
+	for key, value := range e.Headers {
+		if strings.ContainsAny(key, "\r\n: ") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid header %q", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var msg bytes.Buffer
+	msg.WriteString("From: " + sender.String() + "\r\n")
+	msg.WriteString("To: " + recipient.String() + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", e.Subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	for _, key := range keys {
+		msg.WriteString(key + ": " + e.Headers[key] + "\r\n")
+	}
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	if e.HTML == "" {
+		writeMailPart(&msg, "text/plain", e.Text)
+		return msg.Bytes(), nil
+	}
+	boundary := make([]byte, 16)
+	rand.Read(boundary)
+	separator := hex.EncodeToString(boundary)
+	msg.WriteString("Content-Type: multipart/alternative; boundary=" + separator + "\r\n\r\n")
+	for _, part := range []struct{ contentType, body string }{{"text/plain", e.Text}, {"text/html", e.HTML}} {
+		msg.WriteString("--" + separator + "\r\n")
+		writeMailPart(&msg, part.contentType, part.body)
+		msg.WriteString("\r\n")
+	}
+	msg.WriteString("--" + separator + "--\r\n")
+	return msg.Bytes(), nil
+}
+
+// writeMailPart writes the headers and the quoted-printable body of a part
+func writeMailPart(msg *bytes.Buffer, contentType string, body string) {
+	msg.WriteString("Content-Type: " + contentType + "; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	writer := quotedprintable.NewWriter(msg)
+	writer.Write([]byte(body))
+	writer.Close()
+}
+
+// mailFrom returns the sender address of the config, the Email account by default
+func mailFrom(config Config) string {
+	if config.MailFrom != "" {
+		return config.MailFrom
+	}
+	return config.Email
+}
+
+// SMTPMailer is a Mailer sending the emails through a SMTP server.
+// TLS is SMTPStartTLS, SMTPTLS for an implicit TLS connection such as
+// on the port 465, or SMTPPlain. Username may be empty for no auth
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	TLS      string
+	Username string
+	Password string
+	From     string
+}
+
+// NewSMTPMailer returns the SMTPMailer of the SMTP settings of the config,
+// the Gmail server with the Email account by default
+func NewSMTPMailer(config Config) *SMTPMailer {
+	mailer := &SMTPMailer{
+		Host:     config.SMTPHost,
+		Port:     config.SMTPPort,
+		TLS:      config.SMTPTLS,
+		Username: config.SMTPUsername,
+		Password: config.SMTPPassword,
+		From:     mailFrom(config),
+	}
+	if mailer.Host == "" {
+		mailer.Host = "smtp.gmail.com"
+	}
+	if mailer.Port == 0 {
+		mailer.Port = 587
+	}
+	if mailer.TLS == "" {
+		mailer.TLS = SMTPStartTLS
+	}
+	if mailer.Username == "" && mailer.Password == "" {
+		mailer.Username, mailer.Password = config.Email, config.Password
+	}
+	return mailer
+}
+
+// Send sends the email through the SMTP server
+func (m *SMTPMailer) Send(email Email) error {
+	message, err := email.Message(m.From)
+	if err != nil {
+		return err
+	}
+	sender, _ := mail.ParseAddress(m.From)
+	recipient, _ := mail.ParseAddress(email.To)
+	address := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	config := &tls.Config{ServerName: m.Host}
+	var conn net.Conn
+	if m.TLS == SMTPTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: mailTimeout}, "tcp", address, config)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, mailTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(mailTimeout))
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if m.TLS == SMTPStartTLS {
+		if err := client.StartTLS(config); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(sender.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(recipient.Address); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileMailer is a Mailer writing each email as a .eml file of its
+// Directory instead of sending it, for the development
+type FileMailer struct {
+	Directory string
+	From      string
+}
+
+// NewFileMailer returns the FileMailer of the given directory, created if missing
+func NewFileMailer(directory string, from string) (*FileMailer, error) {
+	if directory == "" {
+		directory = "mails"
+	}
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{Directory: directory, From: from}, nil
+}
+
+// Send writes the email to a new file of the directory
+func (m *FileMailer) Send(email Email) error {
+	message, err := email.Message(m.From)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 4)
+	rand.Read(id)
+	name := time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(id) + ".eml"
+	return ioutil.WriteFile(filepath.Join(m.Directory, name), message, 0644)
+}
+
+// MemoryMailer is a Mailer keeping the emails instead of sending them, to
+// test the emails offline. Sending to an address of Unavailable fails
+type MemoryMailer struct {
+	mutex       sync.Mutex
+	Emails      []Email
+	Unavailable map[string]bool
+}
+
+// Send keeps the given email
+func (m *MemoryMailer) Send(email Email) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.Unavailable[email.To] {
+		return fmt.Errorf("mailbox %s unavailable", email.To)
+	}
+	m.Emails = append(m.Emails, email)
+	return nil
+}
+
+// Sent returns the emails kept so far
+func (m *MemoryMailer) Sent() []Email {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]Email{}, m.Emails...)
+}
+
+// queuedEmail is an Email of the mailQueue, with its number of attempts
+type queuedEmail struct {
+	Email
+	attempts int
+}
+
+// mailQueue holds the emails to send by the mail worker
+var mailQueue = make(chan queuedEmail, mailQueueSize)
+
+// QueueEmail will queue the given email, sent by the mail worker and tried
+// again with a backoff when the Mailer fails, mailMaxAttempts times at most.
+// It returns ErrMailQueueFull rather than waiting if the queue is full
+func QueueEmail(email Email) error {
+	return queueEmail(queuedEmail{Email: email})
+}
+
+func queueEmail(queued queuedEmail) error {
+	select {
+	case mailQueue <- queued:
+		return nil
+	default:
+		log.Println("[error] Mail queue full, dropped email", queued.Subject, "to", queued.To)
+		return ErrMailQueueFull
+	}
+}
+
+// StartMailWorker will start the worker sending the queued emails
+func StartMailWorker() {
+	go func() {
+		for queued := range mailQueue {
+			sendQueuedEmail(queued)
+		}
+	}()
+}
+
+func sendQueuedEmail(queued queuedEmail) {
+	err := mailer.Send(queued.Email)
+	if err == nil {
+		return
+	}
+	queued.attempts++
+	if queued.attempts >= mailMaxAttempts {
+		log.Println("[error] Failed to send email", queued.Subject, "to", queued.To, "after", queued.attempts, "attempts", err)
+		return
+	}
+	log.Println("[error] Email", queued.Subject, "to", queued.To, "attempt", queued.attempts, err)
+	time.AfterFunc(mailBackoff<<uint(queued.attempts-1), func() { queueEmail(queued) })
+}
+
+// MailTemplate is an email of which the subject, plain-text and HTML body
+// are templates, executed with the data of each email
+type MailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// mailFuncs are the functions of the MailTemplate
+var mailFuncs = map[string]interface{}{
+	"date": func(date time.Time) string { return date.In(digestLocation()).Format("02/01 à 15h04") },
+}
+
+// NewMailTemplate returns the MailTemplate of the given templates, and panics
+// if one is invalid. The HTML template escapes the data it is given
+func NewMailTemplate(name string, subject string, text string, html string) *MailTemplate {
+	return &MailTemplate{
+		subject: texttemplate.Must(texttemplate.New(name).Funcs(mailFuncs).Parse(subject)),
+		text:    texttemplate.Must(texttemplate.New(name).Funcs(mailFuncs).Parse(text)),
+		html:    htmltemplate.Must(htmltemplate.New(name).Funcs(mailFuncs).Parse(html)),
+	}
+}
+
+// Render returns the Email to the given address of the template executed with data
+func (t *MailTemplate) Render(to string, data interface{}) (Email, error) {
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return Email{}, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return Email{}, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return Email{}, err
+	}
+	return Email{To: to, Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
 }
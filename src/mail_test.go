@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestMailer replaces the mailer by a MemoryMailer and returns it
+func newTestMailer(t *testing.T) *MemoryMailer {
+	t.Helper()
+	memory := &MemoryMailer{Unavailable: map[string]bool{}}
+	previous := mailer
+	mailer = memory
+	t.Cleanup(func() { mailer = previous })
+	return memory
+}
+
+// expectMessage fails if the email can not be formatted and returns its message
+func expectMessage(t *testing.T, email Email) string {
+	t.Helper()
+	message, err := email.Message("noreply@insapp.fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(message)
+}
+
+func TestEmailMessage(t *testing.T) {
+	text := expectMessage(t, Email{To: "alice@insa-rennes.fr", Subject: "Réinitialisation", Text: "Bonjour"})
+	if !strings.Contains(text, "Subject: =?utf-8?q?R=C3=A9initialisation?=\r\n") || !strings.Contains(text, "Content-Type: text/plain; charset=utf-8\r\n") ||
+		strings.Contains(text, "multipart") {
+		t.Fatalf("expected a plain-text message, got %s", text)
+	}
+	html := expectMessage(t, Email{To: "alice@insa-rennes.fr", Subject: "Digest", Text: "Bonjour", HTML: "<p>Bonjour</p>",
+		Headers: map[string]string{"List-Unsubscribe": "<https://insapp.fr/>"}})
+	if !strings.Contains(html, "Content-Type: multipart/alternative; boundary=") || !strings.Contains(html, "Content-Type: text/html; charset=utf-8\r\n") ||
+		!strings.Contains(html, "List-Unsubscribe: <https://insapp.fr/>\r\n") {
+		t.Fatalf("expected a multipart message, got %s", html)
+	}
+}
+
+func TestEmailMessageRejectsHeaderInjection(t *testing.T) {
+	valid := Email{To: "alice@insa-rennes.fr", Subject: "Bonjour", Text: "Salut"}
+	message, err := valid.Message("Insapp <noreply@insapp.fr>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(message), "From: \"Insapp\" <noreply@insapp.fr>\r\n") || !strings.Contains(string(message), "To: <alice@insa-rennes.fr>\r\n") {
+		t.Fatalf("expected the formatted addresses, got %s", message)
+	}
+	for _, email := range []Email{
+		{To: "alice@insa-rennes.fr\r\nBcc: eve@evil.fr", Subject: "Bonjour"},
+		{To: "not an address", Subject: "Bonjour"},
+		{To: "alice@insa-rennes.fr", Subject: "Bonjour\r\nBcc: eve@evil.fr"},
+		{To: "alice@insa-rennes.fr", Subject: "Bonjour", Headers: map[string]string{"List-Unsubscribe": "<https://insapp.fr/>\nBcc: eve@evil.fr"}},
+		{To: "alice@insa-rennes.fr", Subject: "Bonjour", Headers: map[string]string{"Bcc: eve@evil.fr\r\nX-Test": "1"}},
+	} {
+		if _, err := email.Message("noreply@insapp.fr"); err == nil {
+			t.Fatalf("expected %+v to be rejected", email)
+		}
+	}
+}
+
+func TestMailTemplateEscapesHTML(t *testing.T) {
+	email, err := invitationTemplate.Render("alice@insa-rennes.fr", map[string]string{"Association": "<b>BDE</b>", "URL": "https://insapp.fr/invitation?token=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Subject != "Invitation à gérer <b>BDE</b> sur Insapp" || !strings.Contains(email.Text, "https://insapp.fr/invitation?token=1") {
+		t.Fatalf("unexpected email %+v", email)
+	}
+	if strings.Contains(email.HTML, "<b>BDE</b>") || !strings.Contains(email.HTML, "&lt;b&gt;BDE&lt;/b&gt;") {
+		t.Fatalf("expected the HTML to be escaped, got %s", email.HTML)
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+	directory := t.TempDir()
+	file, err := NewMailer(Config{MailTransport: "file", MailDirectory: directory, Email: "insapp@gmail.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Send(Email{To: "alice@insa-rennes.fr", Subject: "Bonjour", Text: "Salut"}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(directory, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected an email file, got %v", files)
+	}
+	if content, _ := ioutil.ReadFile(files[0]); !strings.Contains(string(content), "From: <insapp@gmail.com>\r\n") {
+		t.Fatalf("expected the email to be sent by the account of the config, got %s", content)
+	}
+	if smtp, _ := NewMailer(Config{Email: "insapp@gmail.com", Password: "secret"}); smtp.(*SMTPMailer).Host != "smtp.gmail.com" || smtp.(*SMTPMailer).Port != 587 {
+		t.Fatalf("expected the Gmail server by default, got %+v", smtp)
+	}
+	if _, err := NewMailer(Config{MailTransport: "pigeon"}); err == nil {
+		t.Fatal("expected an unknown transport to be refused")
+	}
+}
+
+func TestSendQueuedEmail(t *testing.T) {
+	memory := newTestMailer(t)
+	sendQueuedEmail(queuedEmail{Email: Email{To: "alice@insa-rennes.fr", Subject: "Bonjour"}})
+	if sent := memory.Sent(); len(sent) != 1 || sent[0].To != "alice@insa-rennes.fr" {
+		t.Fatalf("expected the email to be sent, got %+v", sent)
+	}
+	memory.Unavailable["bob@insa-rennes.fr"] = true
+	sendQueuedEmail(queuedEmail{Email: Email{To: "bob@insa-rennes.fr", Subject: "Bonjour"}, attempts: mailMaxAttempts - 1})
+	select {
+	case queued := <-mailQueue:
+		t.Fatalf("expected the email not to be retried after %d attempts, got %+v", mailMaxAttempts, queued)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSendDigestEmail(t *testing.T) {
+	newTestStore()
+	memory := newTestMailer(t)
+	association := newTestAssociation(t, "BDE")
+	user, err := AddUser(User{Username: "alice", Email: "alice@insa-rennes.fr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, user, err = FollowAssociation(association.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddPost(Post{Title: "Soirée", Association: association.ID, Date: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	subscription := DigestSubscription{User: user.ID, Token: "token"}
+	memory.Unavailable[user.Email] = true
+	if err := sendDigest(subscription, "2026-W42", time.Now()); err == nil {
+		t.Fatal("expected the digest to fail")
+	}
+	delete(memory.Unavailable, user.Email)
+	if err := sendDigest(subscription, "2026-W42", time.Now()); err != nil {
+		t.Fatalf("expected the failed digest to be sent again, got %v", err)
+	}
+	sent := memory.Sent()
+	if len(sent) != 1 || !strings.Contains(sent[0].Text, "BDE : Soirée") || !strings.HasSuffix(sent[0].Headers["List-Unsubscribe"], "token=token>") {
+		t.Fatalf("expected the digest email, got %+v", sent)
+	}
+}
+
+func TestQueueEmailDoesNotBlock(t *testing.T) {
+	queue := mailQueue
+	mailQueue = make(chan queuedEmail, 1)
+	defer func() { mailQueue = queue }()
+	if err := QueueEmail(Email{To: "alice@insa-rennes.fr"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := QueueEmail(Email{To: "bob@insa-rennes.fr"}); err != ErrMailQueueFull {
+		t.Fatalf("expected ErrMailQueueFull, got %v", err)
+	}
+}
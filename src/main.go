@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -21,6 +22,58 @@ func main() {
 		return
 	}
 
+	mongoStore, err := NewMongoStore(conf)
+	if err != nil {
+		log.Println(err)
+		log.Fatal("[error] Error when connecting to the database. Make sure mongodb is running")
+		return
+	}
+	defer mongoStore.Close()
+	store = mongoStore
+
+	if conf.SessionDuration > 0 {
+		sessionDuration = time.Duration(conf.SessionDuration) * time.Hour
+	}
+	cleanupInterval := time.Hour
+	if conf.SessionCleanupInterval > 0 {
+		cleanupInterval = time.Duration(conf.SessionCleanupInterval) * time.Minute
+	}
+	mailer, err = NewMailer(conf)
+	if err != nil {
+		log.Println(err)
+		log.Fatal("[error] Error when configuring the emails. Make sure the mail settings of the config file are valid")
+		return
+	}
+	mailLinks = NewMailLinks(conf)
+
+	casClient = NewCASClient(conf)
+	if conf.FeedWeights != nil {
+		feedWeights = *conf.FeedWeights
+	}
+
+	if conf.PushWorkers > 0 {
+		pushWorkers = conf.PushWorkers
+	}
+	if conf.PushBatchSize > 0 {
+		pushBatchSize = conf.PushBatchSize
+	}
+	if conf.PushMaxAttempts > 0 {
+		pushMaxAttempts = conf.PushMaxAttempts
+	}
+	pushProviders, err = NewPushProviders(conf)
+	if err != nil {
+		log.Println(err)
+		log.Fatal("[error] Error when loading the push credentials. Make sure the key files of the config file are valid")
+		return
+	}
+
+	// the background jobs start once everything they use is configured
+	StartMailWorker()
+	StartPushWorkers(pushWorkers)
+	go CleanSessionTokens(cleanupInterval)
+	go SendDigests()
+	go SendBroadcasts()
+
 	log.Println("Starting server on 0.0.0.0:" + conf.Port)
 	log.Fatal(http.ListenAndServe(":" + conf.Port, &WithCORS{NewRouter()}))
 }
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// invitationDuration is how long an invitation can be answered
+const invitationDuration = 14 * 24 * time.Hour
+
+// Status of an AssociationMember
+const (
+	MemberPending  = "pending"
+	MemberAccepted = "accepted"
+	MemberDeclined = "declined"
+)
+
+// ErrInvalidInvitation is returned when an invitation is unknown, expired or already answered
+var ErrInvalidInvitation = NewAPIError(http.StatusBadRequest, "invalid_invitation",
+	"the invitation is unknown, expired or already answered", "Invitation invalide ou expirée")
+
+// ErrAlreadyMember is returned when inviting someone already invited or member
+var ErrAlreadyMember = NewAPIError(http.StatusConflict, "already_member",
+	"the user is already a member or invited", "Déjà membre ou invité")
+
+// ErrInvalidMemberRole is returned when a member is invited with a non association role
+var ErrInvalidMemberRole = NewAPIError(http.StatusBadRequest, "invalid_role",
+	"a member can only be an association admin or editor", "Rôle de membre invalide")
+
+// AssociationMember defines how to model a board member of an Association.
+// A student is invited by its User, anybody else by email. An email invitation
+// creates, once accepted, an AssociationUser account linked to the member
+type AssociationMember struct {
+	ID          bson.ObjectId `bson:"_id,omitempty"`
+	Association bson.ObjectId `json:"association" bson:"association"`
+	User        bson.ObjectId `json:"user,omitempty" bson:"user,omitempty"`
+	Email       string        `json:"email,omitempty" bson:"email,omitempty"`
+	// Account is the AssociationUser created for an email invitation
+	Account bson.ObjectId `json:"account,omitempty" bson:"account,omitempty"`
+	Role    Role          `json:"role"`
+	Status  string        `json:"status"`
+	// Token is the SHA-256 of the token emailed to an invited email
+	Token       string        `json:"-" bson:"token,omitempty"`
+	InvitedBy   bson.ObjectId `json:"invitedby" bson:"invitedby"`
+	CreatedAt   time.Time     `json:"createdat"`
+	ExpireAt    time.Time     `json:"expireat"`
+	RespondedAt time.Time     `json:"respondedat,omitempty"`
+}
+
+// AssociationActivity records which member did what on behalf of an association
+type AssociationActivity struct {
+	ID          bson.ObjectId `bson:"_id,omitempty"`
+	Association bson.ObjectId `json:"association" bson:"association"`
+	// Member is the id of the User or AssociationUser who acted
+	Member bson.ObjectId `json:"member" bson:"member"`
+	Action string        `json:"action"`
+	Target bson.ObjectId `json:"target,omitempty" bson:"target,omitempty"`
+	Date   time.Time     `json:"date"`
+}
+
+// InviteMember will invite the given user, or the given email if there is no user,
+// to join the board of the association with the given role, on behalf of the inviter
+func InviteMember(associationID bson.ObjectId, inviter bson.ObjectId, userID bson.ObjectId, email string, role Role) (AssociationMember, error) {
+	if role != RoleAssociationAdmin && role != RoleAssociationEditor {
+		return AssociationMember{}, ErrInvalidMemberRole
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if userID == "" && email == "" {
+		return AssociationMember{}, ErrInvalidInvitation
+	}
+	if userID == "" {
+		if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+			return AssociationMember{}, ValidationError("Adresse email invalide")
+		}
+	}
+	members, err := store.FindAssociationMembers(associationID)
+	if err != nil {
+		return AssociationMember{}, err
+	}
+	for _, member := range members {
+		if member.Status == MemberDeclined || member.isExpired() {
+			continue
+		}
+		if (userID != "" && member.User == userID) || (userID == "" && member.Email == email) {
+			return AssociationMember{}, ErrAlreadyMember
+		}
+	}
+
+	now := time.Now()
+	member := AssociationMember{
+		ID:          bson.NewObjectId(),
+		Association: associationID,
+		User:        userID,
+		Role:        role,
+		Status:      MemberPending,
+		InvitedBy:   inviter,
+		CreatedAt:   now,
+		ExpireAt:    now.Add(invitationDuration),
+	}
+	token := ""
+	if userID == "" {
+		token = generateSessionToken()
+		member.Email = email
+		member.Token = hashPasswordResetToken(token)
+	}
+	if err := store.InsertAssociationMember(member); err != nil {
+		return AssociationMember{}, err
+	}
+	RecordActivity(associationID, inviter, "member:invite", member.ID)
+
+	association, err := GetAssociation(associationID)
+	if err != nil {
+		return AssociationMember{}, err
+	}
+	if userID != "" {
+		message := NewLocalizedText("@{association} t'invite à rejoindre son bureau", "association", strings.ToLower(association.Name))
+		AddNotification(Notification{Sender: associationID, Receiver: userID, Content: member.ID,
+			Message: message.In(defaultLocale), Text: &message, Type: "invitation"})
+	} else {
+		sendInvitation(association, member, token)
+	}
+	return member, nil
+}
+
+// sendInvitation will email the link to accept the invitation to the invited email
+func sendInvitation(association Association, member AssociationMember, token string) {
+	email, err := invitationTemplate.Render(member.Email, bson.M{"Association": association.Name, "URL": mailLinks.Invitation + token})
+	if err != nil {
+		log.Println("[error] Failed to render the invitation to", association.ID.Hex(), err)
+		return
+	}
+	QueueEmail(email)
+}
+
+var invitationTemplate = NewMailTemplate("invitation", `Invitation à gérer {{.Association}} sur Insapp`, `Bonjour,
+
+Tu as été invité à rejoindre le bureau de {{.Association}} sur Insapp.
+Pour accepter et choisir ton mot de passe, suis ce lien, valable deux semaines :
+
+{{.URL}}
+
+Si tu ne t'attendais pas à cette invitation, tu peux ignorer ce message.`, `<!DOCTYPE html>
+<html>
+<body>
+<p>Bonjour,</p>
+<p>Tu as été invité à rejoindre le bureau de <strong>{{.Association}}</strong> sur Insapp.</p>
+<p><a href="{{.URL}}">Accepter l'invitation et choisir ton mot de passe</a>, ce lien est valable deux semaines.</p>
+<p><small>Si tu ne t'attendais pas à cette invitation, tu peux ignorer ce message.</small></p>
+</body>
+</html>
+`)
+
+// GetMember will return the AssociationMember linked to the given ID
+func GetMember(id bson.ObjectId) (AssociationMember, error) {
+	return store.FindAssociationMember(id)
+}
+
+// GetMembers will return the members and pending invitations of the given association
+func GetMembers(associationID bson.ObjectId) ([]AssociationMember, error) {
+	result, err := store.FindAssociationMembers(associationID)
+	if err != nil {
+		return nil, err
+	}
+	return activeMembers(result), nil
+}
+
+// GetMembershipsForUser will return the accepted memberships
+// and pending invitations of the given user
+func GetMembershipsForUser(userID bson.ObjectId) ([]AssociationMember, error) {
+	result, err := store.FindAssociationMembersForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return activeMembers(result), nil
+}
+
+// activeMembers returns the accepted members and pending invitations among the given ones
+func activeMembers(members []AssociationMember) []AssociationMember {
+	result := []AssociationMember{}
+	for _, member := range members {
+		if member.Status == MemberAccepted || (member.Status == MemberPending && !member.isExpired()) {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+// GetMembership will return the accepted membership of the given user in the given association
+func GetMembership(associationID bson.ObjectId, userID bson.ObjectId) (AssociationMember, error) {
+	members, err := GetMembershipsForUser(userID)
+	if err != nil {
+		return AssociationMember{}, err
+	}
+	for _, member := range members {
+		if member.Association == associationID && member.Status == MemberAccepted {
+			return member, nil
+		}
+	}
+	return AssociationMember{}, ErrNotFound
+}
+
+// RespondInvitation will accept or decline the invitation
+// of the given member on behalf of the invited user
+func RespondInvitation(member AssociationMember, userID bson.ObjectId, accept bool) (AssociationMember, error) {
+	if member.User == "" || member.User != userID || member.Status != MemberPending || member.isExpired() {
+		return member, ErrInvalidInvitation
+	}
+	status := MemberDeclined
+	if accept {
+		status = MemberAccepted
+	}
+	if err := store.RespondAssociationMember(member.ID, status, "", time.Now()); err != nil {
+		return member, ErrInvalidInvitation
+	}
+	RecordActivity(member.Association, userID, "member:"+status, member.ID)
+	return store.FindAssociationMember(member.ID)
+}
+
+// AcceptEmailInvitation will accept the invitation emailed with the given token
+// and create the AssociationUser account of the member with the given password
+func AcceptEmailInvitation(token string, password string) (AssociationMember, error) {
+	member, err := store.FindAssociationMemberByToken(hashPasswordResetToken(token))
+	if err != nil || member.Status != MemberPending || member.isExpired() {
+		return member, ErrInvalidInvitation
+	}
+	if err := ValidatePasswordStrength(member.Email, password); err != nil {
+		return member, err
+	}
+	hash, algorithm, err := HashPassword(password)
+	if err != nil {
+		return member, err
+	}
+	account := AssociationUser{
+		ID:          bson.NewObjectId(),
+		Username:    member.Email,
+		Association: member.Association,
+		Password:    hash,
+		Algorithm:   algorithm,
+		Role:        member.Role,
+		Member:      member.ID,
+	}
+	// the account is created first, for an accepted member to always have one
+	if err := store.InsertAssociationUser(account); err != nil {
+		return member, err
+	}
+	if err := store.RespondAssociationMember(member.ID, MemberAccepted, account.ID, time.Now()); err != nil {
+		if err := store.RemoveAssociationUser(account.ID); err != nil {
+			log.Println("[error] Failed to remove the account of the invitation", member.ID.Hex(), err)
+		}
+		return member, ErrInvalidInvitation
+	}
+	RecordActivity(member.Association, account.ID, "member:"+MemberAccepted, member.ID)
+	return store.FindAssociationMember(member.ID)
+}
+
+// DeclineEmailInvitation will decline the invitation emailed with the given token
+func DeclineEmailInvitation(token string) error {
+	member, err := store.FindAssociationMemberByToken(hashPasswordResetToken(token))
+	if err != nil || member.Status != MemberPending || member.isExpired() {
+		return ErrInvalidInvitation
+	}
+	if err := store.RespondAssociationMember(member.ID, MemberDeclined, "", time.Now()); err != nil {
+		return ErrInvalidInvitation
+	}
+	return nil
+}
+
+// RemoveMember will remove the given member, or cancel its invitation,
+// deleting its account and logging out its sessions of the association
+func RemoveMember(member AssociationMember) error {
+	if err := store.RemoveAssociationMember(member.ID); err != nil {
+		return err
+	}
+	if member.Account != "" {
+		if err := store.RemoveAssociationUser(member.Account); err != nil && err != ErrNotFound {
+			return err
+		}
+		if err := RevokeSessionTokensForOwner(member.Account); err != nil {
+			return err
+		}
+	}
+	if member.User != "" {
+		return store.RemoveSessionTokensForMember(member.Association, member.User.Hex())
+	}
+	return nil
+}
+
+// DeleteMembersForAssociation will remove every member of the given association
+func DeleteMembersForAssociation(associationID bson.ObjectId) error {
+	members, err := store.FindAssociationMembers(associationID)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err := RemoveMember(member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMembershipsForUser will remove the given user from every association board
+func DeleteMembershipsForUser(userID bson.ObjectId) error {
+	members, err := store.FindAssociationMembersForUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err := RemoveMember(member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordActivity will record that the given member did the given action on
+// the given target on behalf of the association. A failure is only logged,
+// it must not fail the action itself
+func RecordActivity(associationID bson.ObjectId, memberID bson.ObjectId, action string, target bson.ObjectId) {
+	err := store.InsertAssociationActivity(AssociationActivity{
+		ID:          bson.NewObjectId(),
+		Association: associationID,
+		Member:      memberID,
+		Action:      action,
+		Target:      target,
+		Date:        time.Now(),
+	})
+	if err != nil {
+		log.Println("[error] Failed to record activity", action, "of", associationID.Hex(), err)
+	}
+}
+
+// GetActivities will return the latest activities of the given association
+func GetActivities(associationID bson.ObjectId) ([]AssociationActivity, error) {
+	return store.FindAssociationActivities(associationID, 50)
+}
+
+func (m AssociationMember) isExpired() bool {
+	return m.Status == MemberPending && time.Now().After(m.ExpireAt)
+}
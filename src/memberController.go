@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/freehaha/token-auth"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Invitation is the body expected to invite a member, or to answer an emailed invitation
+type Invitation struct {
+	User     bson.ObjectId `json:"user,omitempty"`
+	Email    string        `json:"email"`
+	Role     Role          `json:"role"`
+	Token    string        `json:"token"`
+	Password string        `json:"password"`
+}
+
+// GetMembersController will answer a JSON of the members
+// and pending invitations of the association linked to the given id
+func GetMembersController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	assocationID := bson.ObjectIdHex(vars["id"])
+	if !VerifyAssociationRequest(r, assocationID) {
+		Forbidden(w)
+		return
+	}
+	members, err := GetMembers(assocationID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"members": members})
+}
+
+// InviteMemberController will invite a student (by its user id)
+// or an email (from the JSON Body) to join the board of the association
+func InviteMemberController(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var invitation Invitation
+	if err := decoder.Decode(&invitation); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	vars := mux.Vars(r)
+	assocationID := bson.ObjectIdHex(vars["id"])
+	if !VerifyAssociationRequest(r, assocationID) {
+		Forbidden(w)
+		return
+	}
+	if invitation.User != "" {
+		if _, err := store.FindUser(invitation.User); err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	member, err := InviteMember(assocationID, GetPrincipal(r).ID, invitation.User, invitation.Email, invitation.Role)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(member)
+}
+
+// RemoveMemberController will remove the member linked to the given
+// memberID from the association, or cancel its invitation
+func RemoveMemberController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	assocationID := bson.ObjectIdHex(vars["id"])
+	if !VerifyAssociationRequest(r, assocationID) {
+		Forbidden(w)
+		return
+	}
+	member, err := GetMember(bson.ObjectIdHex(vars["memberID"]))
+	if err == nil && member.Association != assocationID {
+		err = ErrNotFound
+	}
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := RemoveMember(member); err != nil {
+		WriteError(w, err)
+		return
+	}
+	RecordActivity(assocationID, GetPrincipal(r).ID, "member:remove", member.ID)
+	members, err := GetMembers(assocationID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"members": members})
+}
+
+// GetActivitiesController will answer a JSON of the latest actions
+// made by the members of the association linked to the given id
+func GetActivitiesController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	assocationID := bson.ObjectIdHex(vars["id"])
+	if !VerifyAssociationRequest(r, assocationID) {
+		Forbidden(w)
+		return
+	}
+	activities, err := GetActivities(assocationID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"activities": activities})
+}
+
+// GetMembershipsController will answer a JSON of the associations the user
+// linked to the given id is a member of, and of its pending invitations
+func GetMembershipsController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := bson.ObjectIdHex(vars["id"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	members, err := GetMembershipsForUser(userID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"members": members})
+}
+
+// AcceptInvitationController will accept the invitation linked to the given id
+func AcceptInvitationController(w http.ResponseWriter, r *http.Request) {
+	respondInvitation(w, r, true)
+}
+
+// DeclineInvitationController will decline the invitation linked to the given id
+func DeclineInvitationController(w http.ResponseWriter, r *http.Request) {
+	respondInvitation(w, r, false)
+}
+
+func respondInvitation(w http.ResponseWriter, r *http.Request, accept bool) {
+	vars := mux.Vars(r)
+	member, err := GetMember(bson.ObjectIdHex(vars["id"]))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if !VerifyUserRequest(r, member.User) {
+		Forbidden(w)
+		return
+	}
+	res, err := RespondInvitation(member, member.User, accept)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// AcceptEmailInvitationController will accept the emailed invitation
+// of the given token and create the account with the given password
+func AcceptEmailInvitationController(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var invitation Invitation
+	if err := decoder.Decode(&invitation); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	res, err := AcceptEmailInvitation(invitation.Token, invitation.Password)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// DeclineEmailInvitationController will decline the emailed invitation of the given token
+func DeclineEmailInvitationController(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var invitation Invitation
+	if err := decoder.Decode(&invitation); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	if err := DeclineEmailInvitation(invitation.Token); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"status": "ok"})
+}
+
+// LogMemberController will answer a session token managing the association
+// linked to the given id, to a student who is a member of its board
+func LogMemberController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	assocationID := bson.ObjectIdHex(vars["id"])
+	principal := GetPrincipal(r)
+	member, err := GetMembership(assocationID, principal.ID)
+	if err != nil {
+		Forbidden(w)
+		return
+	}
+	var credentials bson.ObjectId
+	if token, ok := tauth.Get(r).(*SessionToken); ok {
+		credentials = token.Credentials
+	}
+	user, err := GetUser(principal.ID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	roles := append(GetUserRoles(user), member.Role)
+	sessionToken, err := NewSessionToken(Principal{ID: principal.ID, Association: assocationID, Roles: roles}, credentials)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"token": sessionToken.Token, "master": false, "associationID": assocationID, "roles": sessionToken.Roles})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestInviteAndLogMember(t *testing.T) {
+	newTestStore()
+	association := newTestAssociation(t, "BDE")
+	user, token := newTestUser(t, "alice")
+	if _, err := InviteMember(association.ID, bson.NewObjectId(), user.ID, "", RoleStudent); err != ErrInvalidMemberRole {
+		t.Fatalf("expected a non association role to be refused, got %v", err)
+	}
+	member, err := InviteMember(association.ID, bson.NewObjectId(), user.ID, "", RoleAssociationEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InviteMember(association.ID, bson.NewObjectId(), user.ID, "", RoleAssociationEditor); err != ErrAlreadyMember {
+		t.Fatalf("expected a second invitation to be refused, got %v", err)
+	}
+	if w := serveTestRequest("POST", "/association/"+association.ID.Hex()+"/login", "", token); w.Code != http.StatusForbidden {
+		t.Fatalf("expected a pending member not to log in, got %d", w.Code)
+	}
+	if w := serveTestRequest("POST", "/invitation/"+member.ID.Hex(), "", token); w.Code != http.StatusOK {
+		t.Fatalf("expected the invitation to be accepted, got %d", w.Code)
+	}
+
+	w := serveTestRequest("POST", "/association/"+association.ID.Hex()+"/login", "", token)
+	var session struct {
+		Token string `json:"token"`
+		Roles []Role `json:"roles"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected the member to log in, got %d %v", w.Code, err)
+	}
+	if w := serveTestRequest("GET", "/association/"+association.ID.Hex()+"/activity", "", session.Token); w.Code != http.StatusOK {
+		t.Fatalf("expected the member to read the activity, got %d", w.Code)
+	}
+	if activities, _ := GetActivities(association.ID); len(activities) != 2 || activities[0].Action != "member:"+MemberAccepted ||
+		activities[1].Action != "member:invite" {
+		t.Fatalf("expected the invitation and the acceptance to be recorded, got %+v", activities)
+	}
+
+	member, _ = GetMember(member.ID)
+	if err := RemoveMember(member); err != nil {
+		t.Fatal(err)
+	}
+	if w := serveTestRequest("GET", "/association/"+association.ID.Hex()+"/activity", "", session.Token); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the sessions of the removed member to be revoked, got %d", w.Code)
+	}
+}
+
+func TestAcceptEmailInvitation(t *testing.T) {
+	memory := newTestStore()
+	member := AssociationMember{
+		ID:          bson.NewObjectId(),
+		Association: bson.NewObjectId(),
+		Email:       "tresorier@insa-rennes.fr",
+		Role:        RoleAssociationEditor,
+		Status:      MemberPending,
+		Token:       hashPasswordResetToken("token"),
+		ExpireAt:    time.Now().Add(invitationDuration),
+	}
+	if err := memory.InsertAssociationMember(member); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcceptEmailInvitation("token", "short"); err == nil {
+		t.Fatal("expected a weak password to be refused")
+	}
+	member, err := AcceptEmailInvitation("token", "correct horse 42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := memory.FindAssociationUserByID(member.Account)
+	if err != nil || member.Status != MemberAccepted {
+		t.Fatalf("expected the account of the member to be created, got %+v %v", member, err)
+	}
+	user, err := checkLoginForAssociation(Login{Username: "tresorier@insa-rennes.fr", Password: "correct horse 42"})
+	if err != nil || user.ID != account.ID || user.Role != RoleAssociationEditor {
+		t.Fatalf("expected the member to log in as an editor, got %+v %v", user, err)
+	}
+	if _, err := AcceptEmailInvitation("token", "correct horse 42"); err != ErrInvalidInvitation {
+		t.Fatalf("expected the invitation to be answered once, got %v", err)
+	}
+}
+
+// answeredMemberStore is a MemoryStore in which the invitations
+// are answered concurrently, just before being answered
+type answeredMemberStore struct {
+	*MemoryStore
+}
+
+func (s answeredMemberStore) RespondAssociationMember(id bson.ObjectId, status string, account bson.ObjectId, date time.Time) error {
+	return ErrNotFound
+}
+
+func TestAcceptEmailInvitationRemovesAccountOnFailure(t *testing.T) {
+	memory := newTestStore()
+	store = answeredMemberStore{memory}
+	member := AssociationMember{ID: bson.NewObjectId(), Association: bson.NewObjectId(), Email: "tresorier@insa-rennes.fr",
+		Role: RoleAssociationEditor, Status: MemberPending, Token: hashPasswordResetToken("token"), ExpireAt: time.Now().Add(invitationDuration)}
+	if err := memory.InsertAssociationMember(member); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcceptEmailInvitation("token", "correct horse 42"); err != ErrInvalidInvitation {
+		t.Fatalf("expected the invitation answered concurrently to be refused, got %v", err)
+	}
+	if len(memory.associationUsers) != 0 {
+		t.Fatalf("expected the account to be removed, got %+v", memory.associationUsers)
+	}
+}
+
+func TestInviteMemberValidatesEmail(t *testing.T) {
+	newTestStore()
+	newTestMailer(t)
+	association := bson.NewObjectId()
+	for _, email := range []string{"not an email", "a@b.fr\r\nBcc: x@y.fr", "Eve <eve@insa-rennes.fr>"} {
+		if _, err := InviteMember(association, bson.NewObjectId(), "", email, RoleAssociationEditor); err == nil {
+			t.Errorf("expected %q to be rejected", email)
+		}
+	}
+	if len(mailQueue) != 0 {
+		t.Fatal("expected no invitation to be queued")
+	}
+}
@@ -0,0 +1,1478 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MemoryStore is a Store keeping everything in memory.
+// It is meant for tests and local development, nothing survives a restart
+type MemoryStore struct {
+	mutex                   sync.RWMutex
+	users                   map[bson.ObjectId]User
+	associations            map[bson.ObjectId]Association
+	associationUsers        map[bson.ObjectId]AssociationUser
+	events                  map[bson.ObjectId]Event
+	posts                   map[bson.ObjectId]Post
+	credentials             map[bson.ObjectId]Credentials
+	notificationUsers       map[bson.ObjectId]NotificationUser
+	notificationPreferences map[bson.ObjectId]NotificationPreferences
+	notifications           map[bson.ObjectId]Notification
+	sessionTokens           map[string]SessionToken
+	passwordResets          map[bson.ObjectId]PasswordReset
+	members                 map[bson.ObjectId]AssociationMember
+	activities              []AssociationActivity
+	pushJobs                map[bson.ObjectId]PushJob
+	digestSubscriptions     map[bson.ObjectId]DigestSubscription
+	digestRecords           map[bson.ObjectId]DigestRecord
+	broadcasts              map[bson.ObjectId]Broadcast
+}
+
+// NewMemoryStore is the constructor of MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:                   map[bson.ObjectId]User{},
+		associations:            map[bson.ObjectId]Association{},
+		associationUsers:        map[bson.ObjectId]AssociationUser{},
+		events:                  map[bson.ObjectId]Event{},
+		posts:                   map[bson.ObjectId]Post{},
+		credentials:             map[bson.ObjectId]Credentials{},
+		notificationUsers:       map[bson.ObjectId]NotificationUser{},
+		notificationPreferences: map[bson.ObjectId]NotificationPreferences{},
+		notifications:           map[bson.ObjectId]Notification{},
+		sessionTokens:           map[string]SessionToken{},
+		passwordResets:          map[bson.ObjectId]PasswordReset{},
+		members:                 map[bson.ObjectId]AssociationMember{},
+		pushJobs:                map[bson.ObjectId]PushJob{},
+		digestSubscriptions:     map[bson.ObjectId]DigestSubscription{},
+		digestRecords:           map[bson.ObjectId]DigestRecord{},
+		broadcasts:              map[bson.ObjectId]Broadcast{},
+	}
+}
+
+// newID returns the given id, or a brand new one if it is empty,
+// the same way MongoDB fills a missing _id on insert
+func newID(id bson.ObjectId) bson.ObjectId {
+	if id == "" {
+		return bson.NewObjectId()
+	}
+	return id
+}
+
+// addToSet returns a copy of ids with id added if it is not already in it
+func addToSet(ids []bson.ObjectId, id bson.ObjectId) []bson.ObjectId {
+	result := []bson.ObjectId{}
+	for _, existing := range ids {
+		if existing == id {
+			return append(result, ids...)
+		}
+	}
+	return append(append(result, ids...), id)
+}
+
+// contains returns whether id is in ids
+func contains(ids []bson.ObjectId, id bson.ObjectId) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString returns whether value is in values
+func containsString(values []string, value string) bool {
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
+
+// pull returns a copy of ids without id
+func pull(ids []bson.ObjectId, id bson.ObjectId) []bson.ObjectId {
+	result := []bson.ObjectId{}
+	for _, existing := range ids {
+		if existing != id {
+			result = append(result, existing)
+		}
+	}
+	return result
+}
+
+func (s *MemoryStore) InsertUser(user User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user.ID = newID(user.ID)
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *MemoryStore) FindUser(id bson.ObjectId) (User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) FindUserByUsername(username string) (User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, user := range s.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryStore) FindUsers(page Page) (Users, error) {
+	return s.SearchUsers("", page)
+}
+
+func (s *MemoryStore) FindUsersByPromotions(promotions []string) ([]bson.ObjectId, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []bson.ObjectId{}
+	for _, user := range s.users {
+		if containsString(promotions, user.Promotion) {
+			result = append(result, user.ID)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) FindUserIDs() ([]bson.ObjectId, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []bson.ObjectId{}
+	for id := range s.users {
+		result = append(result, id)
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) SearchUsers(query string, page Page) (Users, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	query = strings.ToLower(query)
+	matched := Users{}
+	for _, user := range s.users {
+		if strings.Contains(strings.ToLower(user.Username), query) || strings.Contains(strings.ToLower(user.Name), query) {
+			matched = append(matched, user)
+		}
+	}
+	result := Users{}
+	for _, i := range pageOf(len(matched), func(i int) (time.Time, bson.ObjectId) { return time.Time{}, matched[i].ID }, page, false) {
+		result = append(result, matched[i])
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) UpdateUser(id bson.ObjectId, user User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	result.Name = user.Name
+	result.Description = user.Description
+	result.Email = user.Email
+	result.EmailPublic = user.EmailPublic
+	result.Promotion = user.Promotion
+	result.Gender = user.Gender
+	if user.Locale != "" {
+		result.Locale = user.Locale
+	}
+	s.users[id] = result
+	return nil
+}
+
+func (s *MemoryStore) SetUserRoles(id bson.ObjectId, roles []Role) error {
+	return s.updateUser(id, func(user *User) { user.Roles = append([]Role{}, roles...) })
+}
+
+func (s *MemoryStore) RemoveUser(id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
+
+// updateUser applies change to the user linked to the given id
+func (s *MemoryStore) updateUser(id bson.ObjectId, change func(*User)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&user)
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) AddUserLike(id bson.ObjectId, postID bson.ObjectId) error {
+	return s.updateUser(id, func(user *User) { user.PostsLiked = addToSet(user.PostsLiked, postID) })
+}
+
+func (s *MemoryStore) RemoveUserLike(id bson.ObjectId, postID bson.ObjectId) error {
+	return s.updateUser(id, func(user *User) { user.PostsLiked = pull(user.PostsLiked, postID) })
+}
+
+func (s *MemoryStore) AddUserEvent(id bson.ObjectId, eventID bson.ObjectId) error {
+	return s.updateUser(id, func(user *User) { user.Events = addToSet(user.Events, eventID) })
+}
+
+func (s *MemoryStore) RemoveUserEvent(id bson.ObjectId, eventID bson.ObjectId) error {
+	return s.updateUser(id, func(user *User) { user.Events = pull(user.Events, eventID) })
+}
+
+func (s *MemoryStore) AddUserFollowing(id bson.ObjectId, associationID bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user, ok := s.users[id]
+	if !ok || contains(user.Following, associationID) {
+		return ErrNotFound
+	}
+	user.Following = addToSet(user.Following, associationID)
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) RemoveUserFollowing(id bson.ObjectId, associationID bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user, ok := s.users[id]
+	if !ok || !contains(user.Following, associationID) {
+		return ErrNotFound
+	}
+	user.Following = pull(user.Following, associationID)
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) FindFollowers(associationID bson.ObjectId) ([]bson.ObjectId, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []bson.ObjectId{}
+	for _, user := range s.users {
+		if contains(user.Following, associationID) {
+			result = append(result, user.ID)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) RemoveFollowingForAssociation(associationID bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, user := range s.users {
+		user.Following = pull(user.Following, associationID)
+		s.users[id] = user
+	}
+	return nil
+}
+
+func (s *MemoryStore) InsertAssociation(association Association) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	association.ID = newID(association.ID)
+	s.associations[association.ID] = association
+	return nil
+}
+
+func (s *MemoryStore) FindAssociation(id bson.ObjectId) (Association, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	association, ok := s.associations[id]
+	if !ok {
+		return Association{}, ErrNotFound
+	}
+	return association, nil
+}
+
+func (s *MemoryStore) FindAssociations(page Page) (Associations, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	matched := Associations{}
+	for _, association := range s.associations {
+		matched = append(matched, association)
+	}
+	result := Associations{}
+	for _, i := range pageOf(len(matched), func(i int) (time.Time, bson.ObjectId) { return time.Time{}, matched[i].ID }, page, false) {
+		result = append(result, matched[i])
+	}
+	return result, nil
+}
+
+// updateAssociation applies change to the association linked to the given id
+func (s *MemoryStore) updateAssociation(id bson.ObjectId, change func(*Association)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	association, ok := s.associations[id]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&association)
+	s.associations[id] = association
+	return nil
+}
+
+func (s *MemoryStore) UpdateAssociation(id bson.ObjectId, association Association) error {
+	return s.updateAssociation(id, func(result *Association) {
+		result.Name = association.Name
+		result.Email = association.Email
+		result.Description = association.Description
+		result.Profile = association.Profile
+		result.Cover = association.Cover
+		result.Palette = association.Palette
+		result.SelectedColor = association.SelectedColor
+		result.BgColor = association.BgColor
+		result.FgColor = association.FgColor
+		result.Official = association.Official
+	})
+}
+
+func (s *MemoryStore) IncAssociationFollowers(id bson.ObjectId, delta int) error {
+	return s.updateAssociation(id, func(association *Association) { association.Followers += delta })
+}
+
+func (s *MemoryStore) RemoveAssociation(id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.associations[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.associations, id)
+	return nil
+}
+
+func (s *MemoryStore) AddAssociationEvent(id bson.ObjectId, eventID bson.ObjectId) error {
+	return s.updateAssociation(id, func(association *Association) { association.Events = addToSet(association.Events, eventID) })
+}
+
+func (s *MemoryStore) RemoveAssociationEvent(id bson.ObjectId, eventID bson.ObjectId) error {
+	return s.updateAssociation(id, func(association *Association) { association.Events = pull(association.Events, eventID) })
+}
+
+func (s *MemoryStore) AddAssociationPost(id bson.ObjectId, postID bson.ObjectId) error {
+	return s.updateAssociation(id, func(association *Association) { association.Posts = addToSet(association.Posts, postID) })
+}
+
+func (s *MemoryStore) RemoveAssociationPost(id bson.ObjectId, postID bson.ObjectId) error {
+	return s.updateAssociation(id, func(association *Association) { association.Posts = pull(association.Posts, postID) })
+}
+
+func (s *MemoryStore) InsertAssociationUser(user AssociationUser) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user.ID = newID(user.ID)
+	s.associationUsers[user.ID] = user
+	return nil
+}
+
+func (s *MemoryStore) FindAssociationUser(associationID bson.ObjectId) (AssociationUser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, user := range s.associationUsers {
+		if user.Association == associationID && user.Member == "" {
+			return user, nil
+		}
+	}
+	return AssociationUser{}, ErrNotFound
+}
+
+func (s *MemoryStore) FindAssociationUsersByUsername(username string) ([]AssociationUser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []AssociationUser{}
+	for _, user := range s.associationUsers {
+		if user.Username == username {
+			result = append(result, user)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) FindAssociationUsersByOwner(owner bson.ObjectId) ([]AssociationUser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []AssociationUser{}
+	for _, user := range s.associationUsers {
+		if user.Owner == owner {
+			result = append(result, user)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) FindAssociationUserByID(id bson.ObjectId) (AssociationUser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	user, ok := s.associationUsers[id]
+	if !ok {
+		return AssociationUser{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) UpdateAssociationUserPassword(id bson.ObjectId, password string, algorithm string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user, ok := s.associationUsers[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Password = password
+	user.Algorithm = algorithm
+	s.associationUsers[id] = user
+	return nil
+}
+
+func (s *MemoryStore) RemoveAssociationUser(id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.associationUsers[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.associationUsers, id)
+	return nil
+}
+
+func (s *MemoryStore) InsertEvent(event Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	event.ID = newID(event.ID)
+	s.events[event.ID] = event
+	return nil
+}
+
+func (s *MemoryStore) FindEvent(id bson.ObjectId) (Event, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	event, ok := s.events[id]
+	if !ok {
+		return Event{}, ErrNotFound
+	}
+	return event, nil
+}
+
+func (s *MemoryStore) FindEventsEndingAfter(date time.Time, page Page) (Events, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	matched := Events{}
+	for _, event := range s.events {
+		if event.DateEnd.After(date) {
+			matched = append(matched, event)
+		}
+	}
+	result := Events{}
+	for _, i := range pageOf(len(matched), func(i int) (time.Time, bson.ObjectId) { return matched[i].DateStart, matched[i].ID }, page, false) {
+		result = append(result, matched[i])
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) FindEventsForAssociations(associations []bson.ObjectId, date time.Time, page Page) (Events, error) {
+	events, _ := s.FindEventsEndingAfter(date, Page{})
+	matched := Events{}
+	for _, event := range events {
+		if associations == nil || contains(associations, event.Association) {
+			matched = append(matched, event)
+		}
+	}
+	result := Events{}
+	for _, i := range pageOf(len(matched), func(i int) (time.Time, bson.ObjectId) { return time.Time{}, matched[i].ID }, page, true) {
+		result = append(result, matched[i])
+	}
+	return result, nil
+}
+
+// updateEvent applies change to the event linked to the given id
+func (s *MemoryStore) updateEvent(id bson.ObjectId, change func(*Event)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	event, ok := s.events[id]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&event)
+	s.events[id] = event
+	return nil
+}
+
+func (s *MemoryStore) UpdateEvent(id bson.ObjectId, event Event) error {
+	return s.updateEvent(id, func(result *Event) {
+		result.Name = event.Name
+		result.Description = event.Description
+		result.Status = event.Status
+		result.Image = event.Image
+		result.Palette = event.Palette
+		result.SelectedColor = event.SelectedColor
+		result.DateStart = event.DateStart
+		result.DateEnd = event.DateEnd
+		result.BgColor = event.BgColor
+		result.FgColor = event.FgColor
+	})
+}
+
+func (s *MemoryStore) RemoveEvent(id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.events[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.events, id)
+	return nil
+}
+
+func (s *MemoryStore) AddEventParticipant(id bson.ObjectId, userID bson.ObjectId) error {
+	return s.updateEvent(id, func(event *Event) { event.Participants = addToSet(event.Participants, userID) })
+}
+
+func (s *MemoryStore) RemoveEventParticipant(id bson.ObjectId, userID bson.ObjectId) error {
+	return s.updateEvent(id, func(event *Event) { event.Participants = pull(event.Participants, userID) })
+}
+
+func (s *MemoryStore) InsertPost(post Post) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	post.ID = newID(post.ID)
+	s.posts[post.ID] = post
+	return nil
+}
+
+func (s *MemoryStore) FindPost(id bson.ObjectId) (Post, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	post, ok := s.posts[id]
+	if !ok {
+		return Post{}, ErrNotFound
+	}
+	return post, nil
+}
+
+func (s *MemoryStore) FindPosts() (Posts, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := Posts{}
+	for _, post := range s.posts {
+		result = append(result, post)
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) FindLatestPosts(page Page) (Posts, error) {
+	matched, _ := s.FindPosts()
+	result := Posts{}
+	for _, i := range pageOf(len(matched), func(i int) (time.Time, bson.ObjectId) { return matched[i].Date, matched[i].ID }, page, true) {
+		result = append(result, matched[i])
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) FindPostsForAssociations(associations []bson.ObjectId, page Page) (Posts, error) {
+	posts, _ := s.FindPosts()
+	matched := Posts{}
+	for _, post := range posts {
+		if associations == nil || contains(associations, post.Association) {
+			matched = append(matched, post)
+		}
+	}
+	result := Posts{}
+	for _, i := range pageOf(len(matched), func(i int) (time.Time, bson.ObjectId) { return matched[i].Date, matched[i].ID }, page, true) {
+		result = append(result, matched[i])
+	}
+	return result, nil
+}
+
+// updatePost applies change to the post linked to the given id
+func (s *MemoryStore) updatePost(id bson.ObjectId, change func(*Post)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	post, ok := s.posts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&post)
+	s.posts[id] = post
+	return nil
+}
+
+func (s *MemoryStore) UpdatePost(id bson.ObjectId, post Post) error {
+	return s.updatePost(id, func(result *Post) {
+		result.Title = post.Title
+		result.Description = post.Description
+		result.Image = post.Image
+		result.ImageSize = post.ImageSize
+	})
+}
+
+func (s *MemoryStore) RemovePost(id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.posts[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.posts, id)
+	return nil
+}
+
+func (s *MemoryStore) AddPostLike(id bson.ObjectId, userID bson.ObjectId) error {
+	return s.updatePost(id, func(post *Post) { post.Likes = addToSet(post.Likes, userID) })
+}
+
+func (s *MemoryStore) RemovePostLike(id bson.ObjectId, userID bson.ObjectId) error {
+	return s.updatePost(id, func(post *Post) { post.Likes = pull(post.Likes, userID) })
+}
+
+func (s *MemoryStore) AddPostComment(id bson.ObjectId, comment Comment) error {
+	return s.updatePost(id, func(post *Post) {
+		post.Comments = append(append(Comments{}, post.Comments...), comment)
+	})
+}
+
+func (s *MemoryStore) RemovePostComment(id bson.ObjectId, commentID bson.ObjectId) error {
+	return s.updatePost(id, func(post *Post) {
+		comments := Comments{}
+		for _, comment := range post.Comments {
+			if comment.ID != commentID {
+				comments = append(comments, comment)
+			}
+		}
+		post.Comments = comments
+	})
+}
+
+func (s *MemoryStore) SetPostComments(id bson.ObjectId, comments Comments) error {
+	return s.updatePost(id, func(post *Post) { post.Comments = append(Comments{}, comments...) })
+}
+
+func (s *MemoryStore) InsertCredentials(credentials Credentials) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	credentials.ID = newID(credentials.ID)
+	s.credentials[credentials.ID] = credentials
+	return nil
+}
+
+func (s *MemoryStore) FindCredentials(username string, authToken string) (Credentials, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, credentials := range s.credentials {
+		if credentials.Username == username && credentials.AuthToken == authToken {
+			return credentials, nil
+		}
+	}
+	return Credentials{}, ErrNotFound
+}
+
+func (s *MemoryStore) FindCredentialsForDevice(username string, device string) (Credentials, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, credentials := range s.credentials {
+		if credentials.Username == username && credentials.Device == device {
+			return credentials, nil
+		}
+	}
+	return Credentials{}, ErrNotFound
+}
+
+func (s *MemoryStore) FindCredentialsForUser(userID bson.ObjectId) ([]Credentials, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []Credentials{}
+	for _, credentials := range s.credentials {
+		if credentials.User == userID {
+			result = append(result, credentials)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LastUsed.After(result[j].LastUsed) })
+	return result, nil
+}
+
+func (s *MemoryStore) SetCredentialsLastUsed(id bson.ObjectId, date time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	credentials, ok := s.credentials[id]
+	if !ok {
+		return ErrNotFound
+	}
+	credentials.LastUsed = date
+	s.credentials[id] = credentials
+	return nil
+}
+
+func (s *MemoryStore) RemoveCredentials(id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.credentials[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.credentials, id)
+	return nil
+}
+
+func (s *MemoryStore) RemoveCredentialsForUser(userID bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, credentials := range s.credentials {
+		if credentials.User == userID {
+			delete(s.credentials, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpsertNotificationUser(user NotificationUser) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, existing := range s.notificationUsers {
+		if existing.Token != user.Token {
+			continue
+		}
+		if existing.UserId != user.UserId {
+			delete(s.notificationUsers, id)
+			continue
+		}
+		user.ID = existing.ID
+	}
+	user.ID = newID(user.ID)
+	s.notificationUsers[user.ID] = user
+	return nil
+}
+
+func (s *MemoryStore) FindNotificationUsersForUser(userID bson.ObjectId) ([]NotificationUser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []NotificationUser{}
+	for _, user := range s.notificationUsers {
+		if user.UserId == userID {
+			result = append(result, user)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) FindNotificationUsersForUsers(userIDs []bson.ObjectId) ([]NotificationUser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []NotificationUser{}
+	for _, user := range s.notificationUsers {
+		if contains(userIDs, user.UserId) {
+			result = append(result, user)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) RemoveNotificationUsersForUser(userID bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, user := range s.notificationUsers {
+		if user.UserId == userID {
+			delete(s.notificationUsers, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RemoveNotificationUsersForTokens(tokens []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, user := range s.notificationUsers {
+		for _, token := range tokens {
+			if user.Token == token {
+				delete(s.notificationUsers, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) FindNotificationPreferences(userIDs []bson.ObjectId) ([]NotificationPreferences, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []NotificationPreferences{}
+	for _, userID := range userIDs {
+		if preferences, ok := s.notificationPreferences[userID]; ok {
+			result = append(result, preferences)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) UpsertNotificationPreferences(preferences NotificationPreferences) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.notificationPreferences[preferences.User] = preferences
+	return nil
+}
+
+func (s *MemoryStore) RemoveNotificationPreferences(userID bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.notificationPreferences, userID)
+	return nil
+}
+
+func (s *MemoryStore) InsertNotification(notification Notification) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	notification.ID = newID(notification.ID)
+	s.notifications[notification.ID] = notification
+	return nil
+}
+
+func (s *MemoryStore) FindNotifications(receiver bson.ObjectId, filter NotificationFilter, page Page) (Notifications, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	matched := Notifications{}
+	for _, notification := range s.notifications {
+		if notification.Receiver == receiver && (!filter.UnreadOnly || !notification.Seen) &&
+			(len(filter.Types) == 0 || containsString(filter.Types, notification.Type)) {
+			matched = append(matched, notification)
+		}
+	}
+	result := Notifications{}
+	for _, i := range pageOf(len(matched), func(i int) (time.Time, bson.ObjectId) { return matched[i].Date, matched[i].ID }, page, true) {
+		result = append(result, matched[i])
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) CountNotifications(receiver bson.ObjectId, unreadOnly bool) (int, error) {
+	result, err := s.FindNotifications(receiver, NotificationFilter{UnreadOnly: unreadOnly}, Page{})
+	return len(result), err
+}
+
+func (s *MemoryStore) SetNotificationsDelivery(ids []bson.ObjectId, status string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, id := range ids {
+		if notification, ok := s.notifications[id]; ok && notification.Delivery != PushSent {
+			notification.Delivery = status
+			s.notifications[id] = notification
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) InsertPushJobs(jobs []PushJob) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, job := range jobs {
+		job.ID = newID(job.ID)
+		s.pushJobs[job.ID] = job
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClaimPushJob(now time.Time, lease time.Duration) (PushJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var result PushJob
+	found := false
+	for _, job := range s.pushJobs {
+		if (job.Status == PushPending || job.Status == PushSending) && !job.NextAttempt.After(now) &&
+			(!found || job.NextAttempt.Before(result.NextAttempt)) {
+			result = job
+			found = true
+		}
+	}
+	if !found {
+		return result, ErrNotFound
+	}
+	result.Status = PushSending
+	result.NextAttempt = now.Add(lease)
+	result.Lease = bson.NewObjectId().Hex()
+	s.pushJobs[result.ID] = result
+	return result, nil
+}
+
+func (s *MemoryStore) UpdatePushJob(job PushJob) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, ok := s.pushJobs[job.ID]; !ok || current.Lease != job.Lease {
+		return ErrNotFound
+	}
+	job.Lease = ""
+	if job.Status != PushSent && job.Status != PushFailed {
+		job.DoneAt = time.Time{}
+	}
+	s.pushJobs[job.ID] = job
+	return nil
+}
+
+func (s *MemoryStore) SetNotificationSeen(receiver bson.ObjectId, id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	notification, ok := s.notifications[id]
+	if !ok || notification.Receiver != receiver {
+		return ErrNotFound
+	}
+	notification.Seen = true
+	s.notifications[id] = notification
+	return nil
+}
+
+func (s *MemoryStore) UpsertAggregatedNotification(notification Notification) (Notification, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, previous := range s.notifications {
+		if previous.AggregationKey != notification.AggregationKey {
+			continue
+		}
+		updated := previous
+		updated.Sender = notification.Sender
+		updated.Comment = notification.Comment
+		updated.Message = notification.Message
+		updated.Text = notification.Text
+		updated.Date = notification.Date
+		updated.Seen = false
+		updated.Actors = append([]bson.ObjectId{}, previous.Actors...)
+		if !contains(updated.Actors, notification.Sender) {
+			updated.Actors = append(updated.Actors, notification.Sender)
+		}
+		s.notifications[id] = updated
+		return previous, nil
+	}
+	notification.ID = newID(notification.ID)
+	notification.Actors = []bson.ObjectId{notification.Sender}
+	s.notifications[notification.ID] = notification
+	return Notification{}, ErrNotFound
+}
+
+func (s *MemoryStore) FindAggregatedNotifications(content bson.ObjectId) (Notifications, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := Notifications{}
+	for _, notification := range s.notifications {
+		if notification.Content == content && notification.AggregationKey != "" {
+			result = append(result, notification)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) UpdateAggregatedNotification(notification Notification) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result, ok := s.notifications[notification.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	result.Sender = notification.Sender
+	result.Comment = notification.Comment
+	result.Message = notification.Message
+	result.Text = notification.Text
+	result.Actors = append([]bson.ObjectId{}, notification.Actors...)
+	s.notifications[notification.ID] = result
+	return nil
+}
+
+func (s *MemoryStore) SetNotificationsSeen(receiver bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, notification := range s.notifications {
+		if notification.Receiver == receiver {
+			notification.Seen = true
+			s.notifications[id] = notification
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RemoveNotification(receiver bson.ObjectId, id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	notification, ok := s.notifications[id]
+	if !ok || notification.Receiver != receiver {
+		return ErrNotFound
+	}
+	delete(s.notifications, id)
+	return nil
+}
+
+// removeNotifications removes every notification matching the given filter
+func (s *MemoryStore) removeNotifications(match func(Notification) bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, notification := range s.notifications {
+		if match(notification) {
+			delete(s.notifications, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RemoveNotificationsForReceiver(receiver bson.ObjectId) error {
+	return s.removeNotifications(func(notification Notification) bool { return notification.Receiver == receiver })
+}
+
+func (s *MemoryStore) RemoveNotificationsForContent(content bson.ObjectId) error {
+	return s.removeNotifications(func(notification Notification) bool { return notification.Content == content })
+}
+
+func (s *MemoryStore) RemoveNotificationsForComment(commentID bson.ObjectId) error {
+	return s.removeNotifications(func(notification Notification) bool {
+		return notification.Comment.ID == commentID && notification.AggregationKey == ""
+	})
+}
+
+func (s *MemoryStore) InsertSessionToken(token SessionToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	token.ID = newID(token.ID)
+	s.sessionTokens[token.Token] = token
+	return nil
+}
+
+func (s *MemoryStore) FindSessionToken(token string) (SessionToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result, ok := s.sessionTokens[token]
+	if !ok {
+		return SessionToken{}, ErrNotFound
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) RemoveSessionToken(token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessionTokens, token)
+	return nil
+}
+
+func (s *MemoryStore) RemoveSessionTokensForOwner(owner string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, token := range s.sessionTokens {
+		if token.Owner == owner {
+			delete(s.sessionTokens, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RemoveSessionTokensForCredentials(credentialsID bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, token := range s.sessionTokens {
+		if credentialsID != "" && token.Credentials == credentialsID {
+			delete(s.sessionTokens, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RemoveSessionTokensForAssociation(associationID bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, token := range s.sessionTokens {
+		if token.Association == associationID {
+			delete(s.sessionTokens, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RemoveSessionTokensForMember(associationID bson.ObjectId, owner string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, token := range s.sessionTokens {
+		if token.Association == associationID && token.Owner == owner {
+			delete(s.sessionTokens, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RemoveSessionTokensExpiredBefore(date time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	removed := 0
+	for key, token := range s.sessionTokens {
+		if token.ExpireAt.Before(date) {
+			delete(s.sessionTokens, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (s *MemoryStore) InsertPasswordReset(reset PasswordReset) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	reset.ID = newID(reset.ID)
+	s.passwordResets[reset.ID] = reset
+	return nil
+}
+
+func (s *MemoryStore) FindPasswordReset(token string) (PasswordReset, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, reset := range s.passwordResets {
+		if reset.Token == token {
+			return reset, nil
+		}
+	}
+	return PasswordReset{}, ErrNotFound
+}
+
+func (s *MemoryStore) UsePasswordReset(id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	reset, ok := s.passwordResets[id]
+	if !ok || reset.Used {
+		return ErrNotFound
+	}
+	reset.Used = true
+	s.passwordResets[id] = reset
+	return nil
+}
+
+func (s *MemoryStore) RemovePasswordResetsForUser(userID bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, reset := range s.passwordResets {
+		if reset.User == userID {
+			delete(s.passwordResets, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) InsertAssociationMember(member AssociationMember) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	member.ID = newID(member.ID)
+	s.members[member.ID] = member
+	return nil
+}
+
+func (s *MemoryStore) FindAssociationMember(id bson.ObjectId) (AssociationMember, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	member, ok := s.members[id]
+	if !ok {
+		return AssociationMember{}, ErrNotFound
+	}
+	return member, nil
+}
+
+func (s *MemoryStore) FindAssociationMemberByToken(token string) (AssociationMember, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, member := range s.members {
+		if len(token) > 0 && member.Token == token {
+			return member, nil
+		}
+	}
+	return AssociationMember{}, ErrNotFound
+}
+
+func (s *MemoryStore) findAssociationMembers(keep func(AssociationMember) bool) []AssociationMember {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []AssociationMember{}
+	for _, member := range s.members {
+		if keep(member) {
+			result = append(result, member)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result
+}
+
+func (s *MemoryStore) FindAssociationMembers(associationID bson.ObjectId) ([]AssociationMember, error) {
+	return s.findAssociationMembers(func(member AssociationMember) bool { return member.Association == associationID }), nil
+}
+
+func (s *MemoryStore) FindAssociationMembersForUser(userID bson.ObjectId) ([]AssociationMember, error) {
+	return s.findAssociationMembers(func(member AssociationMember) bool { return member.User == userID }), nil
+}
+
+func (s *MemoryStore) RespondAssociationMember(id bson.ObjectId, status string, account bson.ObjectId, date time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	member, ok := s.members[id]
+	if !ok || member.Status != MemberPending {
+		return ErrNotFound
+	}
+	member.Status = status
+	member.RespondedAt = date
+	if account != "" {
+		member.Account = account
+	}
+	s.members[id] = member
+	return nil
+}
+
+func (s *MemoryStore) RemoveAssociationMember(id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.members[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.members, id)
+	return nil
+}
+
+func (s *MemoryStore) InsertAssociationActivity(activity AssociationActivity) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	activity.ID = newID(activity.ID)
+	s.activities = append(s.activities, activity)
+	return nil
+}
+
+func (s *MemoryStore) FindAssociationActivities(associationID bson.ObjectId, limit int) ([]AssociationActivity, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []AssociationActivity{}
+	for i := len(s.activities) - 1; i >= 0 && len(result) < limit; i-- {
+		if s.activities[i].Association == associationID {
+			result = append(result, s.activities[i])
+		}
+	}
+	return result, nil
+}
+
+// pageOf sorts the given number of items, of which key returns the sort
+// keys, as the MongoStore does and returns the indexes of the ones in the page
+func pageOf(count int, key func(i int) (time.Time, bson.ObjectId), page Page, desc bool) []int {
+	compare := func(date1 time.Time, id1 bson.ObjectId, date2 time.Time, id2 bson.ObjectId) int {
+		result := strings.Compare(string(id1), string(id2))
+		if date1.Before(date2) {
+			result = -1
+		} else if date1.After(date2) {
+			result = 1
+		}
+		if desc {
+			return -result
+		}
+		return result
+	}
+	indexes := []int{}
+	for i := 0; i < count; i++ {
+		date, id := key(i)
+		if page.After == nil || compare(date, id, page.After.Date, page.After.ID) > 0 {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Slice(indexes, func(a, b int) bool {
+		date1, id1 := key(indexes[a])
+		date2, id2 := key(indexes[b])
+		return compare(date1, id1, date2, id2) < 0
+	})
+	if page.Limit > 0 && len(indexes) > page.Limit {
+		indexes = indexes[:page.Limit]
+	}
+	return indexes
+}
+
+func (s *MemoryStore) InsertDigestSubscription(subscription DigestSubscription) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.digestSubscriptions[subscription.User] = subscription
+	return nil
+}
+
+func (s *MemoryStore) FindDigestSubscription(userID bson.ObjectId) (DigestSubscription, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	subscription, ok := s.digestSubscriptions[userID]
+	if !ok {
+		return DigestSubscription{}, ErrNotFound
+	}
+	return subscription, nil
+}
+
+func (s *MemoryStore) FindDigestSubscriptionByToken(token string) (DigestSubscription, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, subscription := range s.digestSubscriptions {
+		if subscription.Token == token {
+			return subscription, nil
+		}
+	}
+	return DigestSubscription{}, ErrNotFound
+}
+
+func (s *MemoryStore) FindDigestSubscriptions() ([]DigestSubscription, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := []DigestSubscription{}
+	for _, subscription := range s.digestSubscriptions {
+		result = append(result, subscription)
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) RemoveDigestSubscription(userID bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.digestSubscriptions[userID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.digestSubscriptions, userID)
+	return nil
+}
+
+func (s *MemoryStore) InsertDigestRecord(record DigestRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, existing := range s.digestRecords {
+		if existing.User == record.User && existing.Week == record.Week {
+			return ErrDuplicate
+		}
+	}
+	record.ID = newID(record.ID)
+	s.digestRecords[record.ID] = record
+	return nil
+}
+
+func (s *MemoryStore) FindLastDigestRecord(userID bson.ObjectId) (DigestRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var result DigestRecord
+	found := false
+	for _, record := range s.digestRecords {
+		if record.User == userID && (!found || record.SentAt.After(result.SentAt)) {
+			result = record
+			found = true
+		}
+	}
+	if !found {
+		return result, ErrNotFound
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) RemoveDigestRecord(id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.digestRecords[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.digestRecords, id)
+	return nil
+}
+
+func (s *MemoryStore) InsertBroadcast(broadcast Broadcast) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	broadcast.ID = newID(broadcast.ID)
+	s.broadcasts[broadcast.ID] = broadcast
+	return nil
+}
+
+func (s *MemoryStore) FindBroadcast(id bson.ObjectId) (Broadcast, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	broadcast, ok := s.broadcasts[id]
+	if !ok {
+		return Broadcast{}, ErrNotFound
+	}
+	return broadcast, nil
+}
+
+func (s *MemoryStore) FindBroadcasts(sender bson.ObjectId, page Page) (Broadcasts, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	matched := Broadcasts{}
+	for _, broadcast := range s.broadcasts {
+		if sender == "" || broadcast.Sender == sender {
+			matched = append(matched, broadcast)
+		}
+	}
+	result := Broadcasts{}
+	for _, i := range pageOf(len(matched), func(i int) (time.Time, bson.ObjectId) { return matched[i].CreatedAt, matched[i].ID }, page, true) {
+		result = append(result, matched[i])
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) ClaimDueBroadcast(now time.Time) (Broadcast, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var result Broadcast
+	found := false
+	for _, broadcast := range s.broadcasts {
+		if broadcast.Status == BroadcastScheduled && !broadcast.ScheduledAt.After(now) &&
+			(!found || broadcast.ScheduledAt.Before(result.ScheduledAt)) {
+			result = broadcast
+			found = true
+		}
+	}
+	if !found {
+		return result, ErrNotFound
+	}
+	result.Status = BroadcastSending
+	s.broadcasts[result.ID] = result
+	return result, nil
+}
+
+func (s *MemoryStore) UpdateBroadcast(broadcast Broadcast) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result, ok := s.broadcasts[broadcast.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	result.Status = broadcast.Status
+	result.SentAt = broadcast.SentAt
+	result.Stats = broadcast.Stats
+	s.broadcasts[broadcast.ID] = result
+	return nil
+}
+
+func (s *MemoryStore) CancelBroadcast(id bson.ObjectId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	broadcast, ok := s.broadcasts[id]
+	if !ok || broadcast.Status != BroadcastScheduled {
+		return ErrNotFound
+	}
+	broadcast.Status = BroadcastCanceled
+	s.broadcasts[id] = broadcast
+	return nil
+}
+
+func (s *MemoryStore) CountBroadcastDeliveries(id bson.ObjectId) (BroadcastStats, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var result BroadcastStats
+	for _, notification := range s.notifications {
+		if notification.Content != id {
+			continue
+		}
+		switch notification.Delivery {
+		case PushPending, PushSending:
+			result.Pending++
+		case PushSent:
+			result.Sent++
+		case PushFailed:
+			result.Failed++
+		}
+		if notification.Seen {
+			result.Read++
+		}
+	}
+	return result, nil
+}
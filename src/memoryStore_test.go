@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// newTestStore replaces the store by an empty MemoryStore
+func newTestStore() *MemoryStore {
+	memory := NewMemoryStore()
+	store = memory
+	return memory
+}
+
+// newTestAssociation adds an association of the given name
+func newTestAssociation(t *testing.T, name string) Association {
+	t.Helper()
+	association, err := AddAssociation(Association{Name: name, Email: strings.ToLower(name) + "@insa-rennes.fr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return association
+}
+
+func TestMemoryStoreLikes(t *testing.T) {
+	newTestStore()
+	user, err := AddUser(User{Username: "Alice"})
+	if err != nil || user.Username != "alice" {
+		t.Fatalf("expected the username to be lowered, got %q %v", user.Username, err)
+	}
+	association := newTestAssociation(t, "BDE")
+	if _, err := AddPost(Post{Association: association.ID, Date: time.Now()}); err == nil {
+		t.Fatal("expected a post without title to be refused")
+	}
+	post, err := AddPost(Post{Title: "Soirée", Association: association.ID, Date: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LikePostWithUser(post.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	post, user, err = LikePostWithUser(post.ID, user.ID)
+	if err != nil || len(post.Likes) != 1 || len(user.PostsLiked) != 1 {
+		t.Fatalf("expected one like on both sides, got %v and %v %v", post.Likes, user.PostsLiked, err)
+	}
+	post, user, err = DislikePostWithUser(post.ID, user.ID)
+	if err != nil || len(post.Likes) != 0 || len(user.PostsLiked) != 0 {
+		t.Fatalf("expected no like left, got %v and %v %v", post.Likes, user.PostsLiked, err)
+	}
+}
+
+func TestMemoryStoreDeleteUser(t *testing.T) {
+	memory := newTestStore()
+	user, _ := AddUser(User{Username: "alice"})
+	other, _ := AddUser(User{Username: "bob"})
+	association := newTestAssociation(t, "BDE")
+	post, _ := AddPost(Post{Title: "Soirée", Association: association.ID, Date: time.Now()})
+	event, _ := AddEvent(Event{Name: "Gala", Association: association.ID, DateEnd: time.Now().Add(time.Hour)})
+	LikePostWithUser(post.ID, user.ID)
+	AddParticipant(event.ID, user.ID)
+	CommentPost(post.ID, Comment{ID: bson.NewObjectId(), User: user.ID, Content: "Super"})
+	CommentPost(post.ID, Comment{ID: bson.NewObjectId(), User: other.ID, Content: "Génial"})
+
+	user, _ = GetUser(user.ID)
+	if err := DeleteUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetUser(user.ID); err != ErrNotFound {
+		t.Fatalf("expected the user to be removed from the store, got %v", err)
+	}
+	if _, ok := memory.users[user.ID]; ok {
+		t.Fatal("expected the user to be removed from the store")
+	}
+	post, _ = GetPost(post.ID)
+	if len(post.Likes) != 0 || len(post.Comments) != 1 || post.Comments[0].User != other.ID {
+		t.Fatalf("expected only the comment of bob to be left, got %+v", post)
+	}
+	if event, _ = GetEvent(event.ID); len(event.Participants) != 0 {
+		t.Fatalf("expected no participant left, got %v", event.Participants)
+	}
+}
+
+func TestMemoryStoreFutureEvents(t *testing.T) {
+	newTestStore()
+	association := newTestAssociation(t, "BDE")
+	AddEvent(Event{Name: "Passé", Association: association.ID, DateEnd: time.Now().Add(-time.Hour)})
+	future, _ := AddEvent(Event{Name: "Gala", Association: association.ID, DateEnd: time.Now().Add(time.Hour)})
+	if events, _, err := GetFutureEvents(Page{Limit: 10}); err != nil || len(events) != 1 || events[0].ID != future.ID {
+		t.Fatalf("expected only the future event, got %+v %v", events, err)
+	}
+}
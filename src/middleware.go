@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ParamType is the type of a path parameter of a Route.
+// Every parameter of a pattern must be declared with its type
+type ParamType string
+
+// Params maps the name of the path parameters of a Route to their type
+type Params map[string]ParamType
+
+// Types of the path parameters
+const (
+	ParamObjectID  ParamType = "objectid"
+	ParamUsername  ParamType = "username"
+	ParamImageName ParamType = "image"
+	ParamTicket    ParamType = "ticket"
+)
+
+var (
+	usernameParamRegexp  = regexp.MustCompile(`^[\p{L}\p{N} ._'-]{1,64}$`)
+	imageNameParamRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	ticketParamRegexp    = regexp.MustCompile(`^[A-Za-z0-9._-]{1,256}$`)
+	patternParamRegexp   = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+)
+
+// Valid returns whether the given value is a valid parameter of this type
+func (t ParamType) Valid(value string) bool {
+	switch t {
+	case ParamObjectID:
+		return bson.IsObjectIdHex(value)
+	case ParamUsername:
+		return usernameParamRegexp.MatchString(value)
+	case ParamImageName:
+		return imageNameParamRegexp.MatchString(value)
+	case ParamTicket:
+		return ticketParamRegexp.MatchString(value)
+	}
+	return false
+}
+
+// InvalidParamError returns the APIError of a malformed path parameter
+func InvalidParamError(name string) *APIError {
+	return NewAPIError(http.StatusBadRequest, "invalid_param", "the path parameter "+name+" is malformed", "Mauvais Format")
+}
+
+// ValidateParams will answer a 400 if a path parameter of the request is not
+// of the declared type, so the handler can parse them without checking
+func ValidateParams(params Params, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range mux.Vars(r) {
+			if !params[name].Valid(value) {
+				WriteError(w, InvalidParamError(name))
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// checkParams panics if a parameter of the pattern of the given route is not declared
+func checkParams(route Route) {
+	for _, match := range patternParamRegexp.FindAllStringSubmatch(route.Pattern, -1) {
+		if _, ok := route.Params[match[1]]; !ok {
+			log.Panicln("[error] Undeclared parameter", match[1], "in route", route.Name)
+		}
+	}
+}
+
+// Recover will log the stack of a panicking handler and answer a JSON 500
+// instead of dropping the connection
+func Recover(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("[error] Panic on %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+				WriteError(w, ErrInternal)
+			}
+		}()
+		handler.ServeHTTP(w, r)
+	})
+}
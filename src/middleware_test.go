@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParamTypes(t *testing.T) {
+	for value, valid := range map[string]bool{"jdupont": true, "Jean Dupont": true, "../etc": false, "a/b": false} {
+		if ParamUsername.Valid(value) != valid {
+			t.Errorf("username %q: expected valid %v", value, valid)
+		}
+	}
+	if ParamImageName.Valid("../image") || !ParamImageName.Valid("5a1b2c3d") {
+		t.Error("expected only plain image names to be valid")
+	}
+	if ParamType("unknown").Valid("anything") {
+		t.Error("expected an undeclared parameter to be invalid")
+	}
+}
+
+func TestCheckParamsPanicsOnUndeclaredParam(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected an undeclared parameter to panic")
+		}
+	}()
+	checkParams(Route{Name: "GetPost", Pattern: "/post/{id}", Params: Params{}})
+}
+
+func TestRecoverAnswersInternalError(t *testing.T) {
+	handler := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user *User
+		w.Write([]byte(user.Username))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	expectError(t, w, http.StatusInternalServerError, ErrInternal.Code)
+}
@@ -0,0 +1,1305 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoStore is the Store backed by the MongoDB database.
+// It holds a single pooled session created at startup
+type MongoStore struct {
+	session  *mgo.Session
+	database string
+}
+
+// NewMongoStore is the constructor of MongoStore. It dials the
+// database described in the given Config and keeps the session as a pool
+func NewMongoStore(config Config) (*MongoStore, error) {
+	url := config.MongoURL
+	if url == "" {
+		url = "127.0.0.1"
+	}
+	database := config.MongoDatabase
+	if database == "" {
+		database = "insapp"
+	}
+	timeout := time.Duration(config.MongoTimeout) * time.Second
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	mode, err := mongoMode(config.MongoMode)
+	if err != nil {
+		return nil, err
+	}
+	session, err := mgo.DialWithTimeout(url, timeout)
+	if err != nil {
+		return nil, err
+	}
+	session.SetMode(mode, true)
+	session.SetSyncTimeout(timeout)
+	if config.MongoSocketTimeout > 0 {
+		session.SetSocketTimeout(time.Duration(config.MongoSocketTimeout) * time.Second)
+	}
+	if config.MongoPoolLimit > 0 {
+		session.SetPoolLimit(config.MongoPoolLimit)
+	}
+	result := &MongoStore{session: session, database: database}
+	if err := result.ensureIndexes(); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return result, nil
+}
+
+// ensureIndexes creates the indexes needed by the queries of the store
+func (s *MongoStore) ensureIndexes() error {
+	session := s.copy()
+	defer session.Close()
+	db := session.DB(s.database)
+	indexes := map[string][]mgo.Index{
+		"credentials": {
+			{Key: []string{"username", "authtoken"}},
+			{Key: []string{"user"}},
+		},
+		"session_token": {
+			{Key: []string{"token"}, Unique: true},
+			{Key: []string{"owner"}},
+			{Key: []string{"credentials"}},
+			{Key: []string{"association", "owner"}},
+			{Key: []string{"expireat"}},
+		},
+		"digest_subscription": {
+			{Key: []string{"token"}, Unique: true},
+		},
+		"broadcast": {
+			{Key: []string{"status", "scheduledat"}},
+			{Key: []string{"sender", "-createdat", "-_id"}},
+			{Key: []string{"-createdat", "-_id"}},
+		},
+		"digest_record": {
+			{Key: []string{"user", "week"}, Unique: true},
+			{Key: []string{"user", "-sentat"}},
+		},
+		"password_reset": {
+			{Key: []string{"token"}, Unique: true},
+			{Key: []string{"expireat"}, ExpireAfter: time.Second},
+		},
+		"association_member": {
+			{Key: []string{"association"}},
+			{Key: []string{"user"}},
+			{Key: []string{"token"}, Unique: true, Sparse: true},
+		},
+		"user": {
+			{Key: []string{"following"}},
+		},
+		"notification_user": {
+			{Key: []string{"userid"}},
+			{Key: []string{"token"}},
+		},
+		"push_job": {
+			{Key: []string{"status", "nextattempt"}},
+			{Key: []string{"doneat"}, ExpireAfter: 7 * 24 * time.Hour, Sparse: true},
+		},
+		"post": {
+			{Key: []string{"-date", "-_id"}},
+			{Key: []string{"association", "-date", "-_id"}},
+		},
+		"event": {
+			{Key: []string{"datestart", "_id"}},
+			{Key: []string{"dateend"}},
+			{Key: []string{"association", "-_id"}},
+		},
+		"notification": {
+			{Key: []string{"receiver", "-date", "-_id"}},
+			{Key: []string{"receiver", "seen", "-date", "-_id"}},
+			{Key: []string{"receiver", "type", "-date", "-_id"}},
+			{Key: []string{"aggregationkey"}, Unique: true, Sparse: true},
+			{Key: []string{"content", "aggregationkey"}},
+			{Key: []string{"content", "delivery"}},
+		},
+		"association_activity": {
+			{Key: []string{"association", "-date"}},
+		},
+	}
+	for collection, list := range indexes {
+		for _, index := range list {
+			if err := db.C(collection).EnsureIndex(index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mongoMode returns the mgo.Mode matching the read mode of the Config
+func mongoMode(mode string) (mgo.Mode, error) {
+	switch strings.ToLower(mode) {
+	case "", "monotonic":
+		return mgo.Monotonic, nil
+	case "primary", "strong":
+		return mgo.Primary, nil
+	case "primarypreferred":
+		return mgo.PrimaryPreferred, nil
+	case "secondary":
+		return mgo.Secondary, nil
+	case "secondarypreferred":
+		return mgo.SecondaryPreferred, nil
+	case "nearest":
+		return mgo.Nearest, nil
+	case "eventual":
+		return mgo.Eventual, nil
+	}
+	return mgo.Monotonic, errors.New("unknown mongo read mode: " + mode)
+}
+
+// Close closes the pooled session
+func (s *MongoStore) Close() {
+	s.session.Close()
+}
+
+// copy returns a copy of the pooled session, reusing its
+// sockets and settings. The caller must close it
+func (s *MongoStore) copy() *mgo.Session {
+	return s.session.Copy()
+}
+
+// one runs the given query on a single document of the collection,
+// translating mgo.ErrNotFound into ErrNotFound
+func one(query *mgo.Query, result interface{}) error {
+	err := query.One(result)
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// update updates a single document of the collection,
+// translating mgo.ErrNotFound into ErrNotFound
+func update(db *mgo.Collection, selector interface{}, change interface{}) error {
+	err := db.Update(selector, change)
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// findPage runs the given query on the page of a list sorted by the given
+// date field then by id, or by id only if the field is empty.
+// An empty Page finds the whole list
+func findPage(db *mgo.Collection, query bson.M, page Page, field string, desc bool, result interface{}) error {
+	fields := []string{"_id"}
+	if field != "" {
+		fields = []string{field, "_id"}
+	}
+	op := "$gt"
+	if desc {
+		op = "$lt"
+		for i := range fields {
+			fields[i] = "-" + fields[i]
+		}
+	}
+	if page.After != nil {
+		after := bson.M{"_id": bson.M{op: page.After.ID}}
+		if field != "" {
+			after = bson.M{"$or": []bson.M{
+				{field: bson.M{op: page.After.Date}},
+				{field: page.After.Date, "_id": bson.M{op: page.After.ID}},
+			}}
+		}
+		query = bson.M{"$and": []bson.M{query, after}}
+	}
+	return db.Find(query).Sort(fields...).Limit(page.Limit).All(result)
+}
+
+// removeID removes the document of the collection with the given id,
+// translating mgo.ErrNotFound into ErrNotFound
+func removeID(db *mgo.Collection, id bson.ObjectId) error {
+	err := db.RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *MongoStore) InsertUser(user User) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("user").Insert(user)
+}
+
+func (s *MongoStore) FindUser(id bson.ObjectId) (User, error) {
+	session := s.copy()
+	defer session.Close()
+	var result User
+	err := one(session.DB(s.database).C("user").FindId(id), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindUserByUsername(username string) (User, error) {
+	session := s.copy()
+	defer session.Close()
+	var result User
+	err := one(session.DB(s.database).C("user").Find(bson.M{"username": username}), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindUsers(page Page) (Users, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Users
+	err := findPage(session.DB(s.database).C("user"), bson.M{}, page, "", false, &result)
+	return result, err
+}
+
+func (s *MongoStore) FindUsersByPromotions(promotions []string) ([]bson.ObjectId, error) {
+	session := s.copy()
+	defer session.Close()
+	var users []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	err := session.DB(s.database).C("user").Find(bson.M{"promotion": bson.M{"$in": promotions}}).Select(bson.M{"_id": 1}).All(&users)
+	result := []bson.ObjectId{}
+	for _, user := range users {
+		result = append(result, user.ID)
+	}
+	return result, err
+}
+
+func (s *MongoStore) FindUserIDs() ([]bson.ObjectId, error) {
+	session := s.copy()
+	defer session.Close()
+	var users []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	err := session.DB(s.database).C("user").Find(nil).Select(bson.M{"_id": 1}).All(&users)
+	result := []bson.ObjectId{}
+	for _, user := range users {
+		result = append(result, user.ID)
+	}
+	return result, err
+}
+
+func (s *MongoStore) SearchUsers(query string, page Page) (Users, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Users
+	regex := bson.M{"$regex": bson.RegEx{Pattern: `^.*` + regexp.QuoteMeta(query) + `.*`, Options: "i"}}
+	err := findPage(session.DB(s.database).C("user"), bson.M{"$or": []interface{}{
+		bson.M{"username": regex}, bson.M{"name": regex}}}, page, "", false, &result)
+	return result, err
+}
+
+func (s *MongoStore) UpdateUser(id bson.ObjectId, user User) error {
+	session := s.copy()
+	defer session.Close()
+	set := bson.M{
+		"name":        user.Name,
+		"description": user.Description,
+		"email":       user.Email,
+		"emailpublic": user.EmailPublic,
+		"promotion":   user.Promotion,
+		"gender":      user.Gender,
+	}
+	// the clients which do not know the locale leave it unchanged
+	if user.Locale != "" {
+		set["locale"] = user.Locale
+	}
+	change := bson.M{"$set": set}
+	return update(session.DB(s.database).C("user"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) SetUserRoles(id bson.ObjectId, roles []Role) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{"roles": roles}}
+	return update(session.DB(s.database).C("user"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemoveUser(id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	return removeID(session.DB(s.database).C("user"), id)
+}
+
+func (s *MongoStore) AddUserLike(id bson.ObjectId, postID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$addToSet": bson.M{"postsliked": postID}}
+	return update(session.DB(s.database).C("user"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemoveUserLike(id bson.ObjectId, postID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$pull": bson.M{"postsliked": postID}}
+	return update(session.DB(s.database).C("user"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) AddUserEvent(id bson.ObjectId, eventID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$addToSet": bson.M{"events": eventID}}
+	return update(session.DB(s.database).C("user"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemoveUserEvent(id bson.ObjectId, eventID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$pull": bson.M{"events": eventID}}
+	return update(session.DB(s.database).C("user"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) AddUserFollowing(id bson.ObjectId, associationID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$push": bson.M{"following": associationID}}
+	return update(session.DB(s.database).C("user"), bson.M{"_id": id, "following": bson.M{"$ne": associationID}}, change)
+}
+
+func (s *MongoStore) RemoveUserFollowing(id bson.ObjectId, associationID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$pull": bson.M{"following": associationID}}
+	return update(session.DB(s.database).C("user"), bson.M{"_id": id, "following": associationID}, change)
+}
+
+func (s *MongoStore) FindFollowers(associationID bson.ObjectId) ([]bson.ObjectId, error) {
+	session := s.copy()
+	defer session.Close()
+	var users []User
+	err := session.DB(s.database).C("user").Find(bson.M{"following": associationID}).Select(bson.M{"_id": 1}).All(&users)
+	result := []bson.ObjectId{}
+	for _, user := range users {
+		result = append(result, user.ID)
+	}
+	return result, err
+}
+
+func (s *MongoStore) RemoveFollowingForAssociation(associationID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$pull": bson.M{"following": associationID}}
+	_, err := session.DB(s.database).C("user").UpdateAll(bson.M{"following": associationID}, change)
+	return err
+}
+
+func (s *MongoStore) InsertAssociation(association Association) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("association").Insert(association)
+}
+
+func (s *MongoStore) FindAssociation(id bson.ObjectId) (Association, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Association
+	err := one(session.DB(s.database).C("association").FindId(id), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindAssociations(page Page) (Associations, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Associations
+	err := findPage(session.DB(s.database).C("association"), bson.M{}, page, "", false, &result)
+	return result, err
+}
+
+func (s *MongoStore) UpdateAssociation(id bson.ObjectId, association Association) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{
+		"name":          association.Name,
+		"email":         association.Email,
+		"description":   association.Description,
+		"profile":       association.Profile,
+		"cover":         association.Cover,
+		"palette":       association.Palette,
+		"selectedcolor": association.SelectedColor,
+		"bgcolor":       association.BgColor,
+		"fgcolor":       association.FgColor,
+		"official":      association.Official,
+	}}
+	return update(session.DB(s.database).C("association"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemoveAssociation(id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	return removeID(session.DB(s.database).C("association"), id)
+}
+
+func (s *MongoStore) AddAssociationEvent(id bson.ObjectId, eventID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$addToSet": bson.M{"events": eventID}}
+	return update(session.DB(s.database).C("association"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemoveAssociationEvent(id bson.ObjectId, eventID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$pull": bson.M{"events": eventID}}
+	return update(session.DB(s.database).C("association"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) AddAssociationPost(id bson.ObjectId, postID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$addToSet": bson.M{"posts": postID}}
+	return update(session.DB(s.database).C("association"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemoveAssociationPost(id bson.ObjectId, postID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$pull": bson.M{"posts": postID}}
+	return update(session.DB(s.database).C("association"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) InsertAssociationUser(user AssociationUser) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("association_user").Insert(user)
+}
+
+func (s *MongoStore) FindAssociationUser(associationID bson.ObjectId) (AssociationUser, error) {
+	session := s.copy()
+	defer session.Close()
+	var result AssociationUser
+	selector := bson.M{"association": associationID, "member": bson.M{"$exists": false}}
+	err := one(session.DB(s.database).C("association_user").Find(selector), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindAssociationUsersByUsername(username string) ([]AssociationUser, error) {
+	session := s.copy()
+	defer session.Close()
+	var result []AssociationUser
+	err := session.DB(s.database).C("association_user").Find(bson.M{"username": username}).All(&result)
+	return result, err
+}
+
+func (s *MongoStore) FindAssociationUsersByOwner(owner bson.ObjectId) ([]AssociationUser, error) {
+	session := s.copy()
+	defer session.Close()
+	var result []AssociationUser
+	err := session.DB(s.database).C("association_user").Find(bson.M{"owner": owner}).All(&result)
+	return result, err
+}
+
+func (s *MongoStore) FindAssociationUserByID(id bson.ObjectId) (AssociationUser, error) {
+	session := s.copy()
+	defer session.Close()
+	var result AssociationUser
+	err := one(session.DB(s.database).C("association_user").FindId(id), &result)
+	return result, err
+}
+
+func (s *MongoStore) IncAssociationFollowers(id bson.ObjectId, delta int) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$inc": bson.M{"followers": delta}}
+	return update(session.DB(s.database).C("association"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) UpdateAssociationUserPassword(id bson.ObjectId, password string, algorithm string) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{"password": password, "algorithm": algorithm}}
+	return update(session.DB(s.database).C("association_user"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemoveAssociationUser(id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	return removeID(session.DB(s.database).C("association_user"), id)
+}
+
+func (s *MongoStore) InsertEvent(event Event) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("event").Insert(event)
+}
+
+func (s *MongoStore) FindEvent(id bson.ObjectId) (Event, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Event
+	err := one(session.DB(s.database).C("event").FindId(id), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindEventsEndingAfter(date time.Time, page Page) (Events, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Events
+	err := findPage(session.DB(s.database).C("event"), bson.M{"dateend": bson.M{"$gt": date}}, page, "datestart", false, &result)
+	return result, err
+}
+
+func (s *MongoStore) FindEventsForAssociations(associations []bson.ObjectId, date time.Time, page Page) (Events, error) {
+	session := s.copy()
+	defer session.Close()
+	query := bson.M{"dateend": bson.M{"$gt": date}}
+	if associations != nil {
+		query["association"] = bson.M{"$in": associations}
+	}
+	var result Events
+	err := findPage(session.DB(s.database).C("event"), query, page, "", true, &result)
+	return result, err
+}
+
+func (s *MongoStore) UpdateEvent(id bson.ObjectId, event Event) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{
+		"name":          event.Name,
+		"description":   event.Description,
+		"status":        event.Status,
+		"image":         event.Image,
+		"palette":       event.Palette,
+		"selectedcolor": event.SelectedColor,
+		"datestart":     event.DateStart,
+		"dateend":       event.DateEnd,
+		"bgcolor":       event.BgColor,
+		"fgcolor":       event.FgColor,
+	}}
+	return update(session.DB(s.database).C("event"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemoveEvent(id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	return removeID(session.DB(s.database).C("event"), id)
+}
+
+func (s *MongoStore) AddEventParticipant(id bson.ObjectId, userID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$addToSet": bson.M{"participants": userID}}
+	return update(session.DB(s.database).C("event"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemoveEventParticipant(id bson.ObjectId, userID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$pull": bson.M{"participants": userID}}
+	return update(session.DB(s.database).C("event"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) InsertPost(post Post) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("post").Insert(post)
+}
+
+func (s *MongoStore) FindPost(id bson.ObjectId) (Post, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Post
+	err := one(session.DB(s.database).C("post").FindId(id), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindPosts() (Posts, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Posts
+	err := session.DB(s.database).C("post").Find(bson.M{}).All(&result)
+	return result, err
+}
+
+func (s *MongoStore) FindLatestPosts(page Page) (Posts, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Posts
+	err := findPage(session.DB(s.database).C("post"), bson.M{}, page, "date", true, &result)
+	return result, err
+}
+
+func (s *MongoStore) FindPostsForAssociations(associations []bson.ObjectId, page Page) (Posts, error) {
+	session := s.copy()
+	defer session.Close()
+	query := bson.M{}
+	if associations != nil {
+		query["association"] = bson.M{"$in": associations}
+	}
+	var result Posts
+	err := findPage(session.DB(s.database).C("post"), query, page, "date", true, &result)
+	return result, err
+}
+
+func (s *MongoStore) UpdatePost(id bson.ObjectId, post Post) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{
+		"title":       post.Title,
+		"description": post.Description,
+		"image":       post.Image,
+		"imageSize":   post.ImageSize,
+	}}
+	return update(session.DB(s.database).C("post"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemovePost(id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	return removeID(session.DB(s.database).C("post"), id)
+}
+
+func (s *MongoStore) AddPostLike(id bson.ObjectId, userID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$addToSet": bson.M{"likes": userID}}
+	return update(session.DB(s.database).C("post"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemovePostLike(id bson.ObjectId, userID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$pull": bson.M{"likes": userID}}
+	return update(session.DB(s.database).C("post"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) AddPostComment(id bson.ObjectId, comment Comment) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$addToSet": bson.M{"comments": comment}}
+	return update(session.DB(s.database).C("post"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemovePostComment(id bson.ObjectId, commentID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$pull": bson.M{"comments": bson.M{"_id": commentID}}}
+	return update(session.DB(s.database).C("post"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) SetPostComments(id bson.ObjectId, comments Comments) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{"comments": comments}}
+	return update(session.DB(s.database).C("post"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) InsertCredentials(credentials Credentials) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("credentials").Insert(credentials)
+}
+
+func (s *MongoStore) FindCredentials(username string, authToken string) (Credentials, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Credentials
+	err := one(session.DB(s.database).C("credentials").Find(bson.M{"username": username, "authtoken": authToken}), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindCredentialsForDevice(username string, device string) (Credentials, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Credentials
+	err := one(session.DB(s.database).C("credentials").Find(bson.M{"username": username, "device": device}), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindCredentialsForUser(userID bson.ObjectId) ([]Credentials, error) {
+	session := s.copy()
+	defer session.Close()
+	var result []Credentials
+	err := session.DB(s.database).C("credentials").Find(bson.M{"user": userID}).Sort("-lastused").All(&result)
+	return result, err
+}
+
+func (s *MongoStore) SetCredentialsLastUsed(id bson.ObjectId, date time.Time) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{"lastused": date}}
+	return update(session.DB(s.database).C("credentials"), bson.M{"_id": id}, change)
+}
+
+func (s *MongoStore) RemoveCredentials(id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	return removeID(session.DB(s.database).C("credentials"), id)
+}
+
+func (s *MongoStore) RemoveCredentialsForUser(userID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("credentials").RemoveAll(bson.M{"user": userID})
+	return err
+}
+
+func (s *MongoStore) UpsertNotificationUser(user NotificationUser) error {
+	session := s.copy()
+	defer session.Close()
+	db := session.DB(s.database).C("notification_user")
+	if _, err := db.RemoveAll(bson.M{"token": user.Token, "userid": bson.M{"$ne": user.UserId}}); err != nil {
+		return err
+	}
+	change := bson.M{"$set": bson.M{
+		"os":         user.Os,
+		"appversion": user.AppVersion,
+		"locale":     user.Locale,
+		"lastseen":   user.LastSeen,
+	}}
+	_, err := db.Upsert(bson.M{"userid": user.UserId, "token": user.Token}, change)
+	return err
+}
+
+func (s *MongoStore) FindNotificationUsersForUser(userID bson.ObjectId) ([]NotificationUser, error) {
+	session := s.copy()
+	defer session.Close()
+	var result []NotificationUser
+	err := session.DB(s.database).C("notification_user").Find(bson.M{"userid": userID}).All(&result)
+	return result, err
+}
+
+func (s *MongoStore) FindNotificationUsersForUsers(userIDs []bson.ObjectId) ([]NotificationUser, error) {
+	session := s.copy()
+	defer session.Close()
+	var result []NotificationUser
+	err := session.DB(s.database).C("notification_user").Find(bson.M{"userid": bson.M{"$in": userIDs}}).All(&result)
+	return result, err
+}
+
+func (s *MongoStore) RemoveNotificationUsersForUser(userID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("notification_user").RemoveAll(bson.M{"userid": userID})
+	return err
+}
+
+func (s *MongoStore) RemoveNotificationUsersForTokens(tokens []string) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("notification_user").RemoveAll(bson.M{"token": bson.M{"$in": tokens}})
+	return err
+}
+
+func (s *MongoStore) FindNotificationPreferences(userIDs []bson.ObjectId) ([]NotificationPreferences, error) {
+	session := s.copy()
+	defer session.Close()
+	result := []NotificationPreferences{}
+	err := session.DB(s.database).C("notification_preferences").Find(bson.M{"_id": bson.M{"$in": userIDs}}).All(&result)
+	return result, err
+}
+
+func (s *MongoStore) UpsertNotificationPreferences(preferences NotificationPreferences) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("notification_preferences").UpsertId(preferences.User, preferences)
+	return err
+}
+
+func (s *MongoStore) RemoveNotificationPreferences(userID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("notification_preferences").RemoveAll(bson.M{"_id": userID})
+	return err
+}
+
+func (s *MongoStore) InsertNotification(notification Notification) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("notification").Insert(notification)
+}
+
+func (s *MongoStore) FindNotifications(receiver bson.ObjectId, filter NotificationFilter, page Page) (Notifications, error) {
+	session := s.copy()
+	defer session.Close()
+	query := bson.M{"receiver": receiver}
+	if filter.UnreadOnly {
+		query["seen"] = false
+	}
+	if len(filter.Types) > 0 {
+		query["type"] = bson.M{"$in": filter.Types}
+	}
+	var result Notifications
+	err := findPage(session.DB(s.database).C("notification"), query, page, "date", true, &result)
+	return result, err
+}
+
+func (s *MongoStore) CountNotifications(receiver bson.ObjectId, unreadOnly bool) (int, error) {
+	session := s.copy()
+	defer session.Close()
+	query := bson.M{"receiver": receiver}
+	if unreadOnly {
+		query["seen"] = false
+	}
+	return session.DB(s.database).C("notification").Find(query).Count()
+}
+
+func (s *MongoStore) SetNotificationsDelivery(ids []bson.ObjectId, status string) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{"delivery": status}}
+	selector := bson.M{"_id": bson.M{"$in": ids}, "delivery": bson.M{"$ne": PushSent}}
+	_, err := session.DB(s.database).C("notification").UpdateAll(selector, change)
+	return err
+}
+
+func (s *MongoStore) InsertPushJobs(jobs []PushJob) error {
+	session := s.copy()
+	defer session.Close()
+	documents := []interface{}{}
+	for _, job := range jobs {
+		documents = append(documents, job)
+	}
+	return session.DB(s.database).C("push_job").Insert(documents...)
+}
+
+func (s *MongoStore) ClaimPushJob(now time.Time, lease time.Duration) (PushJob, error) {
+	session := s.copy()
+	defer session.Close()
+	var result PushJob
+	query := bson.M{"status": bson.M{"$in": []string{PushPending, PushSending}}, "nextattempt": bson.M{"$lte": now}}
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": PushSending, "nextattempt": now.Add(lease), "lease": bson.NewObjectId().Hex()}},
+		ReturnNew: true,
+	}
+	_, err := session.DB(s.database).C("push_job").Find(query).Sort("nextattempt").Apply(change, &result)
+	if err == mgo.ErrNotFound {
+		return result, ErrNotFound
+	}
+	return result, err
+}
+
+func (s *MongoStore) UpdatePushJob(job PushJob) error {
+	session := s.copy()
+	defer session.Close()
+	selector := bson.M{"_id": job.ID, "lease": job.Lease}
+	return update(session.DB(s.database).C("push_job"), selector, pushJobChange(job))
+}
+
+// pushJobChange returns the update of the given PushJob. Its doneat, on
+// which the expiry index of the jobs is, is only set once it is done, so
+// that a job to retry does not expire
+func pushJobChange(job PushJob) bson.M {
+	set := bson.M{
+		"recipients":  job.Recipients,
+		"status":      job.Status,
+		"attempts":    job.Attempts,
+		"nextattempt": job.NextAttempt,
+		"lasterror":   job.LastError,
+	}
+	unset := bson.M{"lease": ""}
+	if job.Status == PushSent || job.Status == PushFailed {
+		set["doneat"] = job.DoneAt
+	} else {
+		unset["doneat"] = ""
+	}
+	return bson.M{"$set": set, "$unset": unset}
+}
+
+func (s *MongoStore) SetNotificationSeen(receiver bson.ObjectId, id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{"seen": true}}
+	return update(session.DB(s.database).C("notification"), bson.M{"_id": id, "receiver": receiver}, change)
+}
+
+func (s *MongoStore) SetNotificationsSeen(receiver bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{"seen": true}}
+	_, err := session.DB(s.database).C("notification").UpdateAll(bson.M{"receiver": receiver, "seen": false}, change)
+	return err
+}
+
+func (s *MongoStore) RemoveNotification(receiver bson.ObjectId, id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	err := session.DB(s.database).C("notification").Remove(bson.M{"_id": id, "receiver": receiver})
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *MongoStore) UpsertAggregatedNotification(notification Notification) (Notification, error) {
+	session := s.copy()
+	defer session.Close()
+	var previous Notification
+	change := mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{
+				"sender":  notification.Sender,
+				"comment": notification.Comment,
+				"message": notification.Message,
+				"text":    notification.Text,
+				"date":    notification.Date,
+				"seen":    false,
+			},
+			"$addToSet": bson.M{"actors": notification.Sender},
+			"$setOnInsert": bson.M{
+				"_id":      notification.ID,
+				"receiver": notification.Receiver,
+				"type":     notification.Type,
+				"content":  notification.Content,
+				"delivery": notification.Delivery,
+			},
+		},
+		Upsert: true,
+	}
+	query := session.DB(s.database).C("notification").Find(bson.M{"aggregationkey": notification.AggregationKey})
+	info, err := query.Apply(change, &previous)
+	if mgo.IsDup(err) {
+		// a concurrent upsert inserted the notification first
+		info, err = query.Apply(change, &previous)
+	}
+	if err != nil {
+		return previous, err
+	}
+	if info.UpsertedId != nil {
+		return previous, ErrNotFound
+	}
+	return previous, nil
+}
+
+func (s *MongoStore) FindAggregatedNotifications(content bson.ObjectId) (Notifications, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Notifications
+	err := session.DB(s.database).C("notification").Find(bson.M{"content": content, "aggregationkey": bson.M{"$exists": true}}).All(&result)
+	return result, err
+}
+
+func (s *MongoStore) UpdateAggregatedNotification(notification Notification) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{
+		"sender":  notification.Sender,
+		"comment": notification.Comment,
+		"message": notification.Message,
+		"text":    notification.Text,
+		"actors":  notification.Actors,
+	}}
+	return update(session.DB(s.database).C("notification"), bson.M{"_id": notification.ID}, change)
+}
+
+func (s *MongoStore) RemoveNotificationsForReceiver(receiver bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("notification").RemoveAll(bson.M{"receiver": receiver})
+	return err
+}
+
+func (s *MongoStore) RemoveNotificationsForContent(content bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("notification").RemoveAll(bson.M{"content": content})
+	return err
+}
+
+func (s *MongoStore) RemoveNotificationsForComment(commentID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("notification").RemoveAll(bson.M{"comment._id": commentID, "aggregationkey": bson.M{"$exists": false}})
+	return err
+}
+
+func (s *MongoStore) InsertSessionToken(token SessionToken) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("session_token").Insert(token)
+}
+
+func (s *MongoStore) FindSessionToken(token string) (SessionToken, error) {
+	session := s.copy()
+	defer session.Close()
+	var result SessionToken
+	err := one(session.DB(s.database).C("session_token").Find(bson.M{"token": token}), &result)
+	return result, err
+}
+
+func (s *MongoStore) RemoveSessionToken(token string) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("session_token").RemoveAll(bson.M{"token": token})
+	return err
+}
+
+func (s *MongoStore) RemoveSessionTokensForOwner(owner string) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("session_token").RemoveAll(bson.M{"owner": owner})
+	return err
+}
+
+func (s *MongoStore) RemoveSessionTokensForCredentials(credentialsID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("session_token").RemoveAll(bson.M{"credentials": credentialsID})
+	return err
+}
+
+func (s *MongoStore) RemoveSessionTokensForAssociation(associationID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("session_token").RemoveAll(bson.M{"association": associationID})
+	return err
+}
+
+func (s *MongoStore) RemoveSessionTokensForMember(associationID bson.ObjectId, owner string) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("session_token").RemoveAll(bson.M{"association": associationID, "owner": owner})
+	return err
+}
+
+func (s *MongoStore) RemoveSessionTokensExpiredBefore(date time.Time) (int, error) {
+	session := s.copy()
+	defer session.Close()
+	info, err := session.DB(s.database).C("session_token").RemoveAll(bson.M{"expireat": bson.M{"$lt": date}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
+}
+
+func (s *MongoStore) InsertDigestSubscription(subscription DigestSubscription) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("digest_subscription").Insert(subscription)
+}
+
+func (s *MongoStore) FindDigestSubscription(userID bson.ObjectId) (DigestSubscription, error) {
+	session := s.copy()
+	defer session.Close()
+	var result DigestSubscription
+	err := one(session.DB(s.database).C("digest_subscription").FindId(userID), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindDigestSubscriptionByToken(token string) (DigestSubscription, error) {
+	session := s.copy()
+	defer session.Close()
+	var result DigestSubscription
+	err := one(session.DB(s.database).C("digest_subscription").Find(bson.M{"token": token}), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindDigestSubscriptions() ([]DigestSubscription, error) {
+	session := s.copy()
+	defer session.Close()
+	result := []DigestSubscription{}
+	err := session.DB(s.database).C("digest_subscription").Find(nil).All(&result)
+	return result, err
+}
+
+func (s *MongoStore) RemoveDigestSubscription(userID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	return removeID(session.DB(s.database).C("digest_subscription"), userID)
+}
+
+func (s *MongoStore) InsertDigestRecord(record DigestRecord) error {
+	session := s.copy()
+	defer session.Close()
+	err := session.DB(s.database).C("digest_record").Insert(record)
+	if mgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (s *MongoStore) FindLastDigestRecord(userID bson.ObjectId) (DigestRecord, error) {
+	session := s.copy()
+	defer session.Close()
+	var result DigestRecord
+	err := one(session.DB(s.database).C("digest_record").Find(bson.M{"user": userID}).Sort("-sentat"), &result)
+	return result, err
+}
+
+func (s *MongoStore) RemoveDigestRecord(id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	return removeID(session.DB(s.database).C("digest_record"), id)
+}
+
+func (s *MongoStore) InsertPasswordReset(reset PasswordReset) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("password_reset").Insert(reset)
+}
+
+func (s *MongoStore) FindPasswordReset(token string) (PasswordReset, error) {
+	session := s.copy()
+	defer session.Close()
+	var result PasswordReset
+	err := one(session.DB(s.database).C("password_reset").Find(bson.M{"token": token}), &result)
+	return result, err
+}
+
+func (s *MongoStore) UsePasswordReset(id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{"used": true}}
+	return update(session.DB(s.database).C("password_reset"), bson.M{"_id": id, "used": false}, change)
+}
+
+func (s *MongoStore) RemovePasswordResetsForUser(userID bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	_, err := session.DB(s.database).C("password_reset").RemoveAll(bson.M{"user": userID})
+	return err
+}
+
+func (s *MongoStore) InsertAssociationMember(member AssociationMember) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("association_member").Insert(member)
+}
+
+func (s *MongoStore) FindAssociationMember(id bson.ObjectId) (AssociationMember, error) {
+	session := s.copy()
+	defer session.Close()
+	var result AssociationMember
+	err := one(session.DB(s.database).C("association_member").FindId(id), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindAssociationMemberByToken(token string) (AssociationMember, error) {
+	session := s.copy()
+	defer session.Close()
+	var result AssociationMember
+	err := one(session.DB(s.database).C("association_member").Find(bson.M{"token": token}), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindAssociationMembers(associationID bson.ObjectId) ([]AssociationMember, error) {
+	session := s.copy()
+	defer session.Close()
+	var result []AssociationMember
+	err := session.DB(s.database).C("association_member").Find(bson.M{"association": associationID}).Sort("createdat").All(&result)
+	return result, err
+}
+
+func (s *MongoStore) FindAssociationMembersForUser(userID bson.ObjectId) ([]AssociationMember, error) {
+	session := s.copy()
+	defer session.Close()
+	var result []AssociationMember
+	err := session.DB(s.database).C("association_member").Find(bson.M{"user": userID}).Sort("createdat").All(&result)
+	return result, err
+}
+
+func (s *MongoStore) RespondAssociationMember(id bson.ObjectId, status string, account bson.ObjectId, date time.Time) error {
+	session := s.copy()
+	defer session.Close()
+	fields := bson.M{"status": status, "respondedat": date}
+	if account != "" {
+		fields["account"] = account
+	}
+	selector := bson.M{"_id": id, "status": MemberPending}
+	return update(session.DB(s.database).C("association_member"), selector, bson.M{"$set": fields})
+}
+
+func (s *MongoStore) RemoveAssociationMember(id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	return removeID(session.DB(s.database).C("association_member"), id)
+}
+
+func (s *MongoStore) InsertAssociationActivity(activity AssociationActivity) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("association_activity").Insert(activity)
+}
+
+func (s *MongoStore) FindAssociationActivities(associationID bson.ObjectId, limit int) ([]AssociationActivity, error) {
+	session := s.copy()
+	defer session.Close()
+	var result []AssociationActivity
+	err := session.DB(s.database).C("association_activity").Find(bson.M{"association": associationID}).Sort("-date").Limit(limit).All(&result)
+	return result, err
+}
+
+func (s *MongoStore) InsertBroadcast(broadcast Broadcast) error {
+	session := s.copy()
+	defer session.Close()
+	return session.DB(s.database).C("broadcast").Insert(broadcast)
+}
+
+func (s *MongoStore) FindBroadcast(id bson.ObjectId) (Broadcast, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Broadcast
+	err := one(session.DB(s.database).C("broadcast").FindId(id), &result)
+	return result, err
+}
+
+func (s *MongoStore) FindBroadcasts(sender bson.ObjectId, page Page) (Broadcasts, error) {
+	session := s.copy()
+	defer session.Close()
+	query := bson.M{}
+	if sender != "" {
+		query["sender"] = sender
+	}
+	result := Broadcasts{}
+	err := findPage(session.DB(s.database).C("broadcast"), query, page, "createdat", true, &result)
+	return result, err
+}
+
+func (s *MongoStore) ClaimDueBroadcast(now time.Time) (Broadcast, error) {
+	session := s.copy()
+	defer session.Close()
+	var result Broadcast
+	query := bson.M{"status": BroadcastScheduled, "scheduledat": bson.M{"$lte": now}}
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"status": BroadcastSending}}, ReturnNew: true}
+	_, err := session.DB(s.database).C("broadcast").Find(query).Sort("scheduledat").Apply(change, &result)
+	if err == mgo.ErrNotFound {
+		return result, ErrNotFound
+	}
+	return result, err
+}
+
+func (s *MongoStore) UpdateBroadcast(broadcast Broadcast) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{
+		"status": broadcast.Status,
+		"sentat": broadcast.SentAt,
+		"stats":  broadcast.Stats,
+	}}
+	return update(session.DB(s.database).C("broadcast"), bson.M{"_id": broadcast.ID}, change)
+}
+
+func (s *MongoStore) CancelBroadcast(id bson.ObjectId) error {
+	session := s.copy()
+	defer session.Close()
+	change := bson.M{"$set": bson.M{"status": BroadcastCanceled}}
+	return update(session.DB(s.database).C("broadcast"), bson.M{"_id": id, "status": BroadcastScheduled}, change)
+}
+
+func (s *MongoStore) CountBroadcastDeliveries(id bson.ObjectId) (BroadcastStats, error) {
+	session := s.copy()
+	defer session.Close()
+	db := session.DB(s.database).C("notification")
+	var result BroadcastStats
+	counts := []struct {
+		query bson.M
+		count *int
+	}{
+		{bson.M{"content": id, "delivery": bson.M{"$in": []string{PushPending, PushSending}}}, &result.Pending},
+		{bson.M{"content": id, "delivery": PushSent}, &result.Sent},
+		{bson.M{"content": id, "delivery": PushFailed}, &result.Failed},
+		{bson.M{"content": id, "seen": true}, &result.Read},
+	}
+	for _, count := range counts {
+		n, err := db.Find(count.query).Count()
+		if err != nil {
+			return result, err
+		}
+		*count.count = n
+	}
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMongoMode(t *testing.T) {
+	modes := map[string]mgo.Mode{
+		"":                   mgo.Monotonic,
+		"Primary":            mgo.Primary,
+		"strong":             mgo.Primary,
+		"secondaryPreferred": mgo.SecondaryPreferred,
+		"nearest":            mgo.Nearest,
+	}
+	for name, expected := range modes {
+		if mode, err := mongoMode(name); err != nil || mode != expected {
+			t.Fatalf("expected %q to be mode %v, got %v %v", name, expected, mode, err)
+		}
+	}
+}
+
+func TestNewMongoStoreRejectsUnknownMode(t *testing.T) {
+	if _, err := NewMongoStore(Config{MongoMode: "fastest"}); err == nil {
+		t.Fatal("expected an unknown read mode to be rejected before dialing")
+	}
+}
+
+func TestPushJobChangeOnlySetsDoneAtOnceDone(t *testing.T) {
+	retry := pushJobChange(PushJob{Status: PushPending, Attempts: 1, NextAttempt: time.Now()})
+	if _, ok := retry["$set"].(bson.M)["doneat"]; ok {
+		t.Fatalf("expected a job to retry not to be given a doneat, got %+v", retry)
+	}
+	if _, ok := retry["$unset"].(bson.M)["doneat"]; !ok {
+		t.Fatalf("expected the doneat of a job to retry to be removed, got %+v", retry)
+	}
+	for _, status := range []string{PushSent, PushFailed} {
+		done := pushJobChange(PushJob{Status: status, DoneAt: time.Now()})
+		if doneAt, ok := done["$set"].(bson.M)["doneat"].(time.Time); !ok || doneAt.IsZero() {
+			t.Fatalf("expected a %s job to be given a doneat, got %+v", status, done)
+		}
+		if _, ok := done["$unset"].(bson.M)["lease"]; !ok {
+			t.Fatalf("expected the lease of a %s job to be cleared, got %+v", status, done)
+		}
+	}
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"strconv"
 	"time"
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-// NotificationUser defines how to model a NotificationUser
+// NotificationUser defines how to model a NotificationUser,
+// the registration of a device of a user to the pushes
 type NotificationUser struct {
 	ID          bson.ObjectId   `bson:"_id,omitempty"`
 	UserId      bson.ObjectId   `json:"userid"`
 	Token       string          `json:"token"`
 	Os          string          `json:"os"`
+	AppVersion  string          `json:"appversion" bson:"appversion,omitempty"`
+	Locale      string          `json:"locale" bson:"locale,omitempty"`
+	LastSeen    time.Time       `json:"lastseen" bson:"lastseen,omitempty"`
 }
 
 // NotificationUser defines how to model a NotificationUser
@@ -22,105 +26,344 @@ type Notification struct {
 	Content			bson.ObjectId		`json:"content"`
 	Comment			Comment					`json:"comment,omitempty" bson:",omitempty"`
 	Message			string					`json:"message"`
+	// Text is the Message to render in the locale of the receiver
+	Text        *LocalizedText  `json:"-" bson:"text,omitempty"`
 	Seen				bool						`json:"seen"`
 	Date				time.Time				`json:"date"`
 	Type				string					`json:"type"`
+	// Actors are the users of an aggregated notification, the Sender being
+	// the last of them
+	Actors      []bson.ObjectId `json:"actors,omitempty" bson:"actors,omitempty"`
+	// AggregationKey is unique to the aggregated notification of a receiver,
+	// type and content, and empty for the other notifications
+	AggregationKey string       `json:"-" bson:"aggregationkey,omitempty"`
+	// Delivery is the status of the push of the notification to the device
+	Delivery    string          `json:"delivery,omitempty" bson:"delivery,omitempty"`
 }
 
 type Notifications []Notification
 
+// NotificationFilter selects the notifications of an inbox: the
+// unread ones only if UnreadOnly is set, of the given Types if any
+type NotificationFilter struct {
+	UnreadOnly bool
+	Types      []string
+}
+
+
+// CreateOrUpdateNotificationUser will register the device of the given token
+// to the pushes of the user. A user has a registration per device, and a token
+// registered by another user before is moved to this one
+func CreateOrUpdateNotificationUser(user NotificationUser) error {
+	if len(user.Token) == 0 {
+		return ValidationError("Token de notification manquant")
+	}
+	user.Locale = supportedLocale(user.Locale)
+	user.LastSeen = time.Now()
+	return store.UpsertNotificationUser(user)
+}
+
+func AddNotification(notification Notification) (Notification, error) {
+	notification.ID = bson.NewObjectId()
+	notification.Date = time.Now()
+	notification.Seen = false
+	if err := store.InsertNotification(notification); err != nil {
+		return notification, err
+	}
+	publishNotification(notification)
+	return notification, nil
+}
+
+// GetNotificationsForUser will return the given page of the notifications
+// of the user matching the filter, latest first, and the cursor of the next
+// page if there is one
+func GetNotificationsForUser(userID bson.ObjectId, filter NotificationFilter, page Page) (Notifications, *Cursor, error) {
+	notifications, err := store.FindNotifications(userID, filter, page.peek())
+	if err != nil {
+		return notifications, nil, err
+	}
+	locale := userLocale(userID)
+	for i := range notifications {
+		notifications[i] = notifications[i].In(locale)
+	}
+	if !page.more(len(notifications)) {
+		return notifications, nil, nil
+	}
+	notifications = notifications[:page.Limit]
+	last := notifications[len(notifications)-1]
+	return notifications, &Cursor{Date: last.Date, ID: last.ID}, nil
+}
+
+func GetUnreadNotificationsForUser(userID bson.ObjectId) (Notifications, error) {
+	return store.FindNotifications(userID, NotificationFilter{UnreadOnly: true}, Page{})
+}
+
+// GetUnreadCount will return the number of unread notifications of the user,
+// shown as the badge of the app in the pushes, the stream and the inbox
+func GetUnreadCount(userID bson.ObjectId) (int, error) {
+	return store.CountNotifications(userID, true)
+}
+
+// ReadNotificationForUser will mark the given notification of the user as
+// seen and return the unread count. It returns ErrNotFound if the user did
+// not receive it
+func ReadNotificationForUser(userID bson.ObjectId, notifID bson.ObjectId) (int, error) {
+	if err := store.SetNotificationSeen(userID, notifID); err != nil {
+		return 0, err
+	}
+	return inboxChanged(userID)
+}
+
+// ReadAllNotificationsForUser will mark every notification of the user as seen
+func ReadAllNotificationsForUser(userID bson.ObjectId) (int, error) {
+	if err := store.SetNotificationsSeen(userID); err != nil {
+		return 0, err
+	}
+	return inboxChanged(userID)
+}
+
+// DeleteNotificationForUser will delete the given notification of the user and
+// return the unread count. It returns ErrNotFound if the user did not receive it
+func DeleteNotificationForUser(userID bson.ObjectId, notifID bson.ObjectId) (int, error) {
+	if err := store.RemoveNotification(userID, notifID); err != nil {
+		return 0, err
+	}
+	return inboxChanged(userID)
+}
+
+// DeleteAllNotificationsForUser will delete every notification of the user
+func DeleteAllNotificationsForUser(userID bson.ObjectId) (int, error) {
+	if err := store.RemoveNotificationsForReceiver(userID); err != nil {
+		return 0, err
+	}
+	return inboxChanged(userID)
+}
+
+// inboxChanged streams the unread count of the user after a change
+// of the inbox, and returns it
+func inboxChanged(userID bson.ObjectId) (int, error) {
+	count, err := GetUnreadCount(userID)
+	if err != nil {
+		return 0, err
+	}
+	publishUnread(userID, count)
+	return count, nil
+}
+
+// aggregatedTexts are the types of the notifications aggregated in a single
+// one per content and receiver, with their texts for one actor, two actors,
+// and more actors
+var aggregatedTexts = map[string][3]string{
+	"comment": {
+		"@{user} a commenté \"{post}\"",
+		"@{user} et 1 autre personne ont commenté \"{post}\"",
+		"@{user} et {others} autres personnes ont commenté \"{post}\"",
+	},
+	"reply": {
+		"@{user} t'a répondu sur \"{post}\"",
+		"@{user} et 1 autre personne t'ont répondu sur \"{post}\"",
+		"@{user} et {others} autres personnes t'ont répondu sur \"{post}\"",
+	},
+	"like": {
+		"@{user} a aimé \"{post}\"",
+		"@{user} et 1 autre personne ont aimé \"{post}\"",
+		"@{user} et {others} autres personnes ont aimé \"{post}\"",
+	},
+}
 
-func CreateOrUpdateNotificationUser(user NotificationUser){
-	if len(user.Token) == 0 { return }
-  session, _ := mgo.Dial("127.0.0.1")
-  defer session.Close()
-  session.SetMode(mgo.Monotonic, true)
-  db := session.DB("insapp").C("notification_user")
-  res, _ := db.Find(bson.M{"userid": user.UserId}).Count()
-  if res > 0 {
-  	db.Update(bson.M{"userid": user.UserId}, bson.M{"$set": bson.M{ "token": user.Token, "os": user.Os }})
-  }else{
-    db.Insert(user)
-  }
+// aggregationKey returns the AggregationKey of the given notification
+func aggregationKey(notification Notification) string {
+	return notification.Receiver.Hex() + "/" + notification.Type + "/" + notification.Content.Hex()
 }
 
-func AddNotification(notification Notification) Notification {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
+// AggregateNotification will add the sender of the given notification to the
+// notification of the same type and content of the receiver, which becomes
+// unread again, or add the notification if the receiver has none. It returns
+// the notification, and whether it is new to the receiver: added or read before
+func AggregateNotification(notification Notification) (Notification, bool, error) {
 	notification.ID = bson.NewObjectId()
 	notification.Date = time.Now()
 	notification.Seen = false
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("notification")
-	db.Insert(notification)
-	return notification
-}
-
-func GetNotificationsForUser(userID bson.ObjectId) Notifications {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("notification")
-	var result Notifications
-	db.Find(bson.M{"receiver" : userID}).Sort("-date").Limit(30).All(&result)
-	return result
-}
-
-func GetUnreadNotificationsForUser(userID bson.ObjectId) Notifications {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("notification")
-	var result Notifications
-	db.Find(bson.M{"receiver" : userID, "seen": false}).Sort("-date").Limit(30).All(&result)
-	return result
-}
-
-func ReadNotificationForUser(userID bson.ObjectId, notifID bson.ObjectId) Notifications{
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("notification")
-	db.Update(bson.M{"_id": notifID}, bson.M{"$set": bson.M{"seen": true}})
-	return GetNotificationsForUser(userID)
-}
-
-func DeleteNotificationsForUser(id bson.ObjectId){
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("notification")
-	db.RemoveAll(bson.M{"receiver": id})
-}
-
-func DeleteNotificationsForComment(id bson.ObjectId){
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("notification")
-	db.RemoveAll(bson.M{"comment._id": id})
-}
-
-func DeleteNotificationsForPost(id bson.ObjectId){
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("notification")
-	db.RemoveAll(bson.M{"content": id})
-}
-
-func DeleteNotificationsForEvent(id bson.ObjectId){
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("notification")
-	db.RemoveAll(bson.M{"content": id})
-}
-
-func DeleteNotificationTokenForUser(id bson.ObjectId){
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("notification_user")
-	db.RemoveAll(bson.M{"userid": id})
+	notification.Actors = []bson.ObjectId{notification.Sender}
+	notification.AggregationKey = aggregationKey(notification)
+	previous, err := store.UpsertAggregatedNotification(notification)
+	if err == ErrNotFound {
+		publishNotification(notification)
+		return notification, true, nil
+	}
+	if err != nil {
+		return notification, false, err
+	}
+	notification.ID = previous.ID
+	notification.Delivery = previous.Delivery
+	notification.Actors = previous.Actors
+	if !contains(previous.Actors, notification.Sender) {
+		notification.Actors = append(notification.Actors, notification.Sender)
+	}
+	publishNotification(notification)
+	return notification, previous.Seen, nil
+}
+
+// In returns the notification with its Message rendered in the given locale
+func (n Notification) In(locale string) Notification {
+	if n.Text == nil {
+		return n
+	}
+	text := *n.Text
+	if forms, ok := aggregatedTexts[n.Type]; ok && len(n.Actors) > 1 {
+		text.Text = forms[1]
+		if len(n.Actors) > 2 {
+			text.Text = forms[2]
+		}
+		text.Params = map[string]string{"others": strconv.Itoa(len(n.Actors) - 1)}
+		for name, value := range n.Text.Params {
+			text.Params[name] = value
+		}
+	}
+	n.Message = text.In(locale)
+	return n
+}
+
+// userLocale returns the locale of the profile of the given user
+func userLocale(userID bson.ObjectId) string {
+	user, err := store.FindUser(userID)
+	if err != nil {
+		return defaultLocale
+	}
+	return localeOrDefault(user.Locale)
+}
+
+func DeleteNotificationsForUser(id bson.ObjectId) error {
+	return store.RemoveNotificationsForReceiver(id)
+}
+
+// DeleteNotificationsForComment will delete the notifications of the given
+// comment removed from the post, and recompute the aggregated notifications of
+// the post its author is an actor of from the remaining comments of the post
+func DeleteNotificationsForComment(post Post, comment Comment) error {
+	if err := store.RemoveNotificationsForComment(comment.ID); err != nil {
+		return err
+	}
+	notifications, err := store.FindAggregatedNotifications(post.ID)
+	if err != nil {
+		return err
+	}
+	for _, notification := range notifications {
+		if notification.Type == "like" || !contains(notification.Actors, comment.User) {
+			continue
+		}
+		if err := recomputeAggregatedNotification(notification, post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recomputeAggregatedNotification will keep as actors of the given aggregated
+// notification of the post the ones still having a comment of the post it
+// counts, the last of these comments becoming the one of the notification.
+// It deletes the notification if no actor is left
+func recomputeAggregatedNotification(notification Notification, post Post) error {
+	authors := map[bson.ObjectId]bson.ObjectId{}
+	for _, comment := range post.Comments {
+		authors[comment.ID] = comment.User
+	}
+	var last *Comment
+	actors := []bson.ObjectId{}
+	for i, comment := range post.Comments {
+		counts := comment.User != notification.Receiver
+		if notification.Type == "reply" {
+			counts = counts && comment.ReplyTo != "" && authors[comment.ReplyTo] == notification.Receiver
+		}
+		if !counts || !contains(notification.Actors, comment.User) {
+			continue
+		}
+		last = &post.Comments[i]
+		if !contains(actors, comment.User) {
+			actors = append(actors, comment.User)
+		}
+	}
+	if last == nil {
+		return store.RemoveNotification(notification.Receiver, notification.ID)
+	}
+	// the sender is the last of the actors
+	for i, actor := range actors {
+		if actor == last.User {
+			actors = append(append(actors[:i:i], actors[i+1:]...), actor)
+			break
+		}
+	}
+	user, err := GetUser(last.User)
+	if err != nil {
+		return err
+	}
+	text := NewLocalizedText(aggregatedTexts[notification.Type][0], "user", user.Username, "post", post.Title)
+	notification.Sender = last.User
+	notification.Comment = *last
+	notification.Actors = actors
+	notification.Text = &text
+	notification.Message = text.In(defaultLocale)
+	return store.UpdateAggregatedNotification(notification)
+}
+
+// DeleteNotificationsForLike will remove the given user from the actors of
+// the like notifications of the post they no longer like, the last of the
+// remaining users liking the post becoming the sender. It deletes the
+// notifications no user is left in
+func DeleteNotificationsForLike(post Post, userID bson.ObjectId) error {
+	notifications, err := store.FindAggregatedNotifications(post.ID)
+	if err != nil {
+		return err
+	}
+	for _, notification := range notifications {
+		if notification.Type != "like" || !contains(notification.Actors, userID) {
+			continue
+		}
+		actors := []bson.ObjectId{}
+		for _, actor := range notification.Actors {
+			if actor != userID && contains(post.Likes, actor) {
+				actors = append(actors, actor)
+			}
+		}
+		if len(actors) == 0 {
+			if err := store.RemoveNotification(notification.Receiver, notification.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		user, err := GetUser(actors[len(actors)-1])
+		if err != nil {
+			return err
+		}
+		text := NewLocalizedText(aggregatedTexts["like"][0], "user", user.Username, "post", post.Title)
+		notification.Sender = user.ID
+		notification.Actors = actors
+		notification.Text = &text
+		notification.Message = text.In(defaultLocale)
+		if err := store.UpdateAggregatedNotification(notification); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DeleteNotificationsForPost(id bson.ObjectId) error {
+	return store.RemoveNotificationsForContent(id)
+}
+
+func DeleteNotificationsForEvent(id bson.ObjectId) error {
+	return store.RemoveNotificationsForContent(id)
+}
+
+func DeleteNotificationTokenForUser(id bson.ObjectId) error {
+	return store.RemoveNotificationUsersForUser(id)
+}
+
+// DeleteNotificationTokens will forget the devices of the given tokens,
+// reported by the push services as unregistered or invalid
+func DeleteNotificationTokens(tokens []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	return store.RemoveNotificationUsersForTokens(tokens)
 }
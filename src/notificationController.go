@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"gopkg.in/mgo.v2/bson"
 	"github.com/gorilla/mux"
 )
@@ -13,40 +14,132 @@ import (
 func UpdateNotificationUserController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var user NotificationUser
-	decoder.Decode(&user)
+	if err := decoder.Decode(&user); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
 	isValid := VerifyUserRequest(r, user.UserId)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
+		return
+	}
+	if user.Locale == "" && r.Header.Get("Accept-Language") != "" {
+		user.Locale = requestLocale(r)
+	}
+	if err := CreateOrUpdateNotificationUser(user); err != nil {
+		WriteError(w, err)
 		return
 	}
-	CreateOrUpdateNotificationUser(user)
 	json.NewEncoder(w).Encode(bson.M{"status": "ok"})
 }
 
+// GetNotificationController will answer a JSON of a page of the notifications
+// of the user, 30 by default. The "type" query parameter filters them by
+// types, separated by commas, and "unread" set to true keeps the unread ones
 func GetNotificationController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["userID"]
 	isValid := VerifyUserRequest(r, bson.ObjectIdHex(userID))
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
 		return
 	}
-	res := GetNotificationsForUser(bson.ObjectIdHex(userID))
+	page, err := ParsePage(r, 30)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	filter := NotificationFilter{UnreadOnly: r.URL.Query().Get("unread") == "true"}
+	if types := r.URL.Query().Get("type"); types != "" {
+		filter.Types = strings.Split(types, ",")
+	}
+	res, next, err := GetNotificationsForUser(bson.ObjectIdHex(userID), filter, page)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	SetNextLink(w, r, page, next)
 	json.NewEncoder(w).Encode(bson.M{"notifications": res})
 }
 
+// GetUnreadCountController will answer a JSON of the number of unread
+// notifications of the user, the badge of the app
+func GetUnreadCountController(w http.ResponseWriter, r *http.Request) {
+	userID := bson.ObjectIdHex(mux.Vars(r)["userID"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	count, err := GetUnreadCount(userID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"unread": count})
+}
+
+// ReadNotificationController will mark the notification as seen
+// and answer a JSON of the number of unread notifications
+func ReadNotificationController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := bson.ObjectIdHex(vars["userID"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	count, err := ReadNotificationForUser(userID, bson.ObjectIdHex(vars["id"]))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"unread": count})
+}
+
+// ReadAllNotificationsController will mark every notification of the user
+// as seen and answer a JSON of the number of unread notifications
+func ReadAllNotificationsController(w http.ResponseWriter, r *http.Request) {
+	userID := bson.ObjectIdHex(mux.Vars(r)["userID"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	count, err := ReadAllNotificationsForUser(userID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"unread": count})
+}
+
+// DeleteNotificationController will delete the notification
+// and answer a JSON of the number of unread notifications
 func DeleteNotificationController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	userID := vars["userID"]
-	notifID := vars["id"]
-	isValid := VerifyUserRequest(r, bson.ObjectIdHex(userID))
-	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+	userID := bson.ObjectIdHex(vars["userID"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
 		return
 	}
-	res := ReadNotificationForUser(bson.ObjectIdHex(userID), bson.ObjectIdHex(notifID))
-	json.NewEncoder(w).Encode(bson.M{"notifications": res})
+	count, err := DeleteNotificationForUser(userID, bson.ObjectIdHex(vars["id"]))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"unread": count})
+}
+
+// DeleteAllNotificationsController will delete every notification of
+// the user and answer a JSON of the number of unread notifications
+func DeleteAllNotificationsController(w http.ResponseWriter, r *http.Request) {
+	userID := bson.ObjectIdHex(mux.Vars(r)["userID"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	count, err := DeleteAllNotificationsForUser(userID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"unread": count})
 }
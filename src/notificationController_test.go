@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// newTestInbox adds a notification of each given type to the given user,
+// a millisecond apart, and returns them oldest first
+func newTestInbox(t *testing.T, userID bson.ObjectId, types ...string) Notifications {
+	t.Helper()
+	result := Notifications{}
+	for _, notificationType := range types {
+		notification, err := AddNotification(Notification{Receiver: userID, Type: notificationType, Content: bson.NewObjectId()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, notification)
+		time.Sleep(time.Millisecond)
+	}
+	return result
+}
+
+// expectUnread fails if the response is not the given number of unread notifications
+func expectUnread(t *testing.T, w *httptest.ResponseRecorder, count int) {
+	t.Helper()
+	var body struct {
+		Unread int `json:"unread"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || w.Code != http.StatusOK || body.Unread != count {
+		t.Fatalf("expected %d unread notifications, got %d %+v %v", count, w.Code, body, err)
+	}
+}
+
+func TestNotificationInboxFilters(t *testing.T) {
+	newTestStore()
+	alice, token := newTestUser(t, "alice")
+	inbox := newTestInbox(t, alice.ID, "tag", "post", "event", "tag")
+	if _, err := ReadNotificationForUser(alice.ID, inbox[3].ID); err != nil {
+		t.Fatal(err)
+	}
+	for url, expected := range map[string][]bson.ObjectId{
+		"":                      {inbox[3].ID, inbox[2].ID, inbox[1].ID, inbox[0].ID},
+		"?unread=true":          {inbox[2].ID, inbox[1].ID, inbox[0].ID},
+		"?type=tag":             {inbox[3].ID, inbox[0].ID},
+		"?type=post,event":      {inbox[2].ID, inbox[1].ID},
+		"?type=tag&unread=true": {inbox[0].ID},
+	} {
+		w := serveTestRequest("GET", "/notification/"+alice.ID.Hex()+url, "", token)
+		var body struct {
+			Notifications Notifications `json:"notifications"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: expected the notifications, got %d %v", url, w.Code, err)
+		}
+		if len(body.Notifications) != len(expected) {
+			t.Fatalf("%s: expected %d notifications, got %+v", url, len(expected), body.Notifications)
+		}
+		for i, id := range expected {
+			if body.Notifications[i].ID != id {
+				t.Fatalf("%s: expected the notification %d to be %s, got %+v", url, i, id.Hex(), body.Notifications)
+			}
+		}
+	}
+}
+
+func TestNotificationInboxReadAndDelete(t *testing.T) {
+	newTestStore()
+	alice, token := newTestUser(t, "alice")
+	bob, bobToken := newTestUser(t, "bob")
+	inbox := newTestInbox(t, alice.ID, "tag", "post", "event")
+	url := "/notification/" + alice.ID.Hex()
+
+	expectUnread(t, serveTestRequest("GET", url+"/unread", "", token), 3)
+	expectUnread(t, serveTestRequest("PUT", url+"/"+inbox[0].ID.Hex()+"/read", "", token), 2)
+	expectError(t, serveTestRequest("PUT", "/notification/"+bob.ID.Hex()+"/"+inbox[1].ID.Hex()+"/read", "", bobToken), http.StatusNotFound, ErrContentNotFound.Code)
+	expectError(t, serveTestRequest("DELETE", url+"/"+inbox[1].ID.Hex(), "", bobToken), http.StatusForbidden, ErrForbidden.Code)
+	expectUnread(t, serveTestRequest("DELETE", url+"/"+inbox[1].ID.Hex(), "", token), 1)
+	expectError(t, serveTestRequest("DELETE", url+"/"+inbox[1].ID.Hex(), "", token), http.StatusNotFound, ErrContentNotFound.Code)
+	expectUnread(t, serveTestRequest("PUT", url+"/read", "", token), 0)
+	if count, _ := store.CountNotifications(alice.ID, false); count != 2 {
+		t.Fatalf("expected 2 notifications left, got %d", count)
+	}
+	expectUnread(t, serveTestRequest("DELETE", url, "", token), 0)
+	if count, _ := store.CountNotifications(alice.ID, false); count != 0 {
+		t.Fatalf("expected the inbox to be empty, got %d", count)
+	}
+}
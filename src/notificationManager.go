@@ -1,154 +1,298 @@
 package main
 
 import (
-  apns "github.com/anachronistic/apns"
+  "encoding/base64"
   "encoding/json"
-  "gopkg.in/mgo.v2"
+  "errors"
   "gopkg.in/mgo.v2/bson"
   "fmt"
-  "net/http"
-  "bytes"
+  "log"
+  "sync"
 )
 
-func getiOSUsers(user string) []NotificationUser {
-  session, _ := mgo.Dial("127.0.0.1")
-  defer session.Close()
-  session.SetMode(mgo.Monotonic, true)
-  db := session.DB("insapp").C("notification_user")
+// filterDevicesByOs returns, among the given devices, the ones running the given os
+func filterDevicesByOs(os string, devices []NotificationUser) []NotificationUser {
   var result []NotificationUser
-  if user == "" {
-    db.Find(bson.M{"os": "iOS"}).All(&result)
-  }else{
-    db.Find(bson.M{"os": "iOS", "userid": user}).All(&result)
+  for _, device := range devices {
+    if device.Os == os {
+      result = append(result, device)
+    }
   }
   return result
 }
 
-func getAndroidUsers(user string) []NotificationUser {
-  session, _ := mgo.Dial("127.0.0.1")
-  defer session.Close()
-  session.SetMode(mgo.Monotonic, true)
-  db := session.DB("insapp").C("notification_user")
-  var result []NotificationUser
-  if user == "" {
-    db.Find(bson.M{"os": "android"}).All(&result)
-  }else{
-    db.Find(bson.M{"os": "android", "userid": user}).All(&result)
+// getAudience returns the users to notify of a post or an event of the
+// given association: its followers, or every user for a broadcast
+func getAudience(association bson.ObjectId, broadcast bool) []bson.ObjectId {
+  if broadcast {
+    users, err := store.FindUserIDs()
+    if err != nil {
+      log.Println("[error] Failed to get the users", err)
+      return nil
+    }
+    return users
   }
-  return result
+  followers, err := GetFollowers(association)
+  if err != nil {
+    log.Println("[error] Failed to get the followers of", association.Hex(), err)
+    return nil
+  }
+  return followers
 }
 
-func getNotificationUserForUser(user bson.ObjectId) NotificationUser {
-  session, _ := mgo.Dial("127.0.0.1")
-  defer session.Close()
-  session.SetMode(mgo.Monotonic, true)
-  db := session.DB("insapp").C("notification_user")
-  var result NotificationUser
-  db.Find(bson.M{"userid": user}).One(&result)
-  return result
+// TriggerNotificationForUser will notify the receiver
+// that the sender tagged them in the given comment
+func TriggerNotificationForUser(sender bson.ObjectId, receiver bson.ObjectId, content bson.ObjectId, message LocalizedText, comment Comment){
+  notification := Notification{Sender: sender, Content: content, Message: message.In(defaultLocale), Text: &message, Comment: comment, Type: "tag"}
+  triggerNotification(notification, []bson.ObjectId{receiver})
+}
+
+// TriggerNotificationForComment will notify the author of the comment the given
+// comment replies to, and the board of the association of the post, that the
+// user of the comment commented it. The notifications of a post are aggregated
+// in a single one per receiver
+func TriggerNotificationForComment(post Post, comment Comment){
+  user, err := GetUser(comment.User)
+  if err != nil {
+    log.Println("[error] Failed to get the author of comment", comment.ID.Hex(), err)
+    return
+  }
+  notified := []bson.ObjectId{comment.User}
+  if comment.ReplyTo != "" {
+    if parent, err := GetComment(post.ID, comment.ReplyTo); err == nil && !contains(notified, parent.User) {
+      message := NewLocalizedText(aggregatedTexts["reply"][0], "user", user.Username, "post", post.Title)
+      notification := Notification{Sender: comment.User, Content: post.ID, Message: message.In(defaultLocale), Text: &message, Comment: comment, Type: "reply"}
+      triggerNotification(notification, []bson.ObjectId{parent.User})
+      notified = append(notified, parent.User)
+    }
+  }
+  board := getBoard(post.Association, notified)
+  if len(board) == 0 {
+    return
+  }
+  message := NewLocalizedText(aggregatedTexts["comment"][0], "user", user.Username, "post", post.Title)
+  notification := Notification{Sender: comment.User, Content: post.ID, Message: message.In(defaultLocale), Text: &message, Comment: comment, Type: "comment"}
+  triggerNotification(notification, board)
 }
 
-func TriggerNotificationForUser(sender bson.ObjectId, receiver bson.ObjectId, content bson.ObjectId, message string, comment Comment){
-  notification := Notification{Sender: sender, Content: content, Message: message, Comment: comment, Type: "tag"}
-  user := getNotificationUserForUser(receiver)
-  if user.Os == "iOS" {
-    triggeriOSNotification(notification, []NotificationUser{user})
+// TriggerNotificationForLike will notify the board of the association of the
+// post that the given user liked it. The likes of a post are aggregated in a
+// single notification per receiver
+func TriggerNotificationForLike(post Post, userID bson.ObjectId){
+  user, err := GetUser(userID)
+  if err != nil {
+    log.Println("[error] Failed to get the user liking post", post.ID.Hex(), err)
+    return
   }
-  if user.Os == "android" {
-    triggerAndroidNotification(notification, []NotificationUser{user})
+  board := getBoard(post.Association, []bson.ObjectId{userID})
+  if len(board) == 0 {
+    return
   }
+  message := NewLocalizedText(aggregatedTexts["like"][0], "user", user.Username, "post", post.Title)
+  notification := Notification{Sender: userID, Content: post.ID, Message: message.In(defaultLocale), Text: &message, Type: "like"}
+  triggerNotification(notification, board)
 }
 
-func TriggerNotificationForEvent(sender bson.ObjectId, content bson.ObjectId, message string){
-  notification := Notification{Sender: sender, Content: content, Message: message, Type: "event"}
-  iOSUsers := getiOSUsers("")
-  androidUsers := getAndroidUsers("")
-  triggeriOSNotification(notification, iOSUsers)
-  triggerAndroidNotification(notification, androidUsers)
+// getBoard returns the users who are accepted members of the given
+// association, but the excluded ones
+func getBoard(association bson.ObjectId, excluded []bson.ObjectId) []bson.ObjectId {
+  members, err := GetMembers(association)
+  if err != nil {
+    log.Println("[error] Failed to get the members of", association.Hex(), err)
+    return nil
+  }
+  board := []bson.ObjectId{}
+  for _, member := range members {
+    if member.Status == MemberAccepted && member.User != "" && !contains(excluded, member.User) && !contains(board, member.User) {
+      board = append(board, member.User)
+    }
+  }
+  return board
 }
 
-func TriggerNotificationForPost(sender bson.ObjectId, content bson.ObjectId, message string){
-  notification := Notification{Sender: sender, Content: content, Message: message, Type: "post"}
-  iOSUsers := getiOSUsers("")
-  androidUsers := getAndroidUsers("")
-  triggeriOSNotification(notification, iOSUsers)
-  triggerAndroidNotification(notification, androidUsers)
+// TriggerNotificationForEvent will notify the followers of the sender association,
+// or every user if broadcast is set, of the event linked to content
+func TriggerNotificationForEvent(sender bson.ObjectId, content bson.ObjectId, message LocalizedText, broadcast bool){
+  notification := Notification{Sender: sender, Content: content, Message: message.In(defaultLocale), Text: &message, Type: "event"}
+  triggerNotification(notification, getAudience(sender, broadcast))
 }
 
-func triggerAndroidNotification(notification Notification, users []NotificationUser){
-  done := make(chan bool)
-  for _, user := range users {
-    notification.Receiver = user.UserId
-    notification = AddNotification(notification)
-    number := len(GetUnreadNotificationsForUser(user.UserId))
-    go sendAndroidNotificationToDevice(user.Token, notification, number, done)
-  }
-  <- done
+// TriggerNotificationForPost will notify the followers of the sender association,
+// or every user if broadcast is set, of the post linked to content
+func TriggerNotificationForPost(sender bson.ObjectId, content bson.ObjectId, message LocalizedText, broadcast bool){
+  notification := Notification{Sender: sender, Content: content, Message: message.In(defaultLocale), Text: &message, Type: "post"}
+  triggerNotification(notification, getAudience(sender, broadcast))
 }
 
-func triggeriOSNotification(notification Notification, users []NotificationUser){
-  done := make(chan bool)
-  for _, user := range users {
-    notification.Receiver = user.UserId
-    notification = AddNotification(notification)
-    number := len(GetUnreadNotificationsForUser(user.UserId))
-    go sendiOSNotificationToDevice(user.Token, notification, number, done)
+// triggerNotification will add the given notification to the inbox of the given
+// users and queue its pushes to their devices, delivered by the push workers
+func triggerNotification(notification Notification, receivers []bson.ObjectId){
+  if err := EnqueueNotification(notification, receivers); err != nil {
+    log.Println("[error] Failed to queue notification", notification.Type, notification.Content.Hex(), err)
   }
-  <- done
 }
 
-func sendiOSNotificationToDevice(token string, notification Notification, number int, done chan bool) {
-
-  payload := apns.NewPayload()
-  payload.Alert = notification.Message
-  payload.Badge = number
-  payload.Sound = "bingbong.aiff"
+// PushProvider sends the pushes of a PushJob to the push service of its os
+type PushProvider interface {
+  Send(job PushJob) PushResult
+}
 
-  pn := apns.NewPushNotification()
-  pn.DeviceToken = token
-  pn.AddPayload(payload)
-  pn.Set("id", notification.ID)
-  pn.Set("type", notification.Type)
-  pn.Set("sender", notification.Sender)
-  pn.Set("content", notification.Content)
-  pn.Set("message", notification.Message)
-  if notification.Type == "tag" {
-    pn.Set("comment", notification.Comment.ID)
-  }
+// PushResult is the outcome of sending a PushJob: the recipients to try
+// again later and the ones which can not be delivered. Unregistered are the
+// failed recipients of which the token is unregistered or invalid, to forget
+type PushResult struct {
+  Retry        []PushRecipient
+  Failed       []PushRecipient
+  Unregistered []PushRecipient
+  Err          error
+}
 
-  config, _ := Configuration()
+// pushProviders are the PushProvider of each os.
+// They are set in main from the config file
+var pushProviders = map[string]PushProvider{}
 
-  if config.Environment == "staging" {
-    client := apns.NewClient("gateway.sandbox.push.apple.com:2195", "InsappDevCert.pem", "InsappDev.pem")
-    client.Send(pn)
-    pn.PayloadString()
-  }else{
-    client := apns.NewClient("gateway.push.apple.com:2195", "InsappProdCert.pem", "InsappProd.pem")
-    client.Send(pn)
-    pn.PayloadString()
+// NewPushProviders returns the PushProvider of each os selected by the config:
+// FCM for android and APNs for iOS, or a FakePushProvider for both when
+// PushProvider is "fake". An os without credentials is left out
+func NewPushProviders(config Config) (map[string]PushProvider, error) {
+  if config.PushProvider == "fake" {
+    fake := &FakePushProvider{}
+    return map[string]PushProvider{"iOS": fake, "android": fake}, nil
   }
+  result := map[string]PushProvider{}
+  if config.FCMCredentials != "" {
+    provider, err := NewFCMProvider(config.FCMCredentials, config.FCMProject)
+    if err != nil {
+      return nil, err
+    }
+    result["android"] = provider
+  }
+  if config.APNsKey != "" {
+    provider, err := NewAPNsProvider(config.APNsKey, config.APNsKeyID, config.APNsTeamID, config.APNsTopic, config.Environment == "staging")
+    if err != nil {
+      return nil, err
+    }
+    result["iOS"] = provider
+  }
+  return result, nil
+}
 
-  done <- true
+// sendPushJob will send the given job with the PushProvider of its os
+func sendPushJob(job PushJob) PushResult {
+  provider, ok := pushProviders[job.Os]
+  if !ok {
+    return PushResult{Failed: job.Recipients, Err: fmt.Errorf("no push provider for os %q", job.Os)}
+  }
+  return provider.Send(job)
 }
 
-func sendAndroidNotificationToDevice(token string, notification Notification, number int, done chan bool) {
-  url := "https://android.googleapis.com/gcm/send"
-  notifJson, _ := json.Marshal(notification)
-  var jsonStr = "{\"registration_ids\":[\"" + token + "\"], \"data\":" + string(notifJson) + "}"
-  req, err := http.NewRequest("POST", url, bytes.NewBufferString(jsonStr))
+// pushOutcome is the outcome of a push to a single device
+type pushOutcome int
+
+const (
+  pushDelivered pushOutcome = iota
+  pushRetry
+  pushFailed
+  pushUnregistered
+)
+
+// pushConcurrency is how many devices of a PushJob a provider pushes to at once
+const pushConcurrency = 8
 
-  config, _ := Configuration()
+// sendEach will push to each recipient of the given job with send,
+// pushConcurrency at a time, and gather the outcomes in a PushResult
+func sendEach(job PushJob, send func(recipient PushRecipient) (pushOutcome, error)) PushResult {
+  var mutex sync.Mutex
+  var wait sync.WaitGroup
+  slots := make(chan struct{}, pushConcurrency)
+  result := PushResult{}
+  for _, recipient := range job.Recipients {
+    wait.Add(1)
+    slots <- struct{}{}
+    go func(recipient PushRecipient) {
+      defer func() { <-slots; wait.Done() }()
+      outcome, err := send(recipient)
+      mutex.Lock()
+      defer mutex.Unlock()
+      if err != nil {
+        result.Err = err
+      }
+      switch outcome {
+      case pushRetry:
+        result.Retry = append(result.Retry, recipient)
+      case pushFailed:
+        result.Failed = append(result.Failed, recipient)
+      case pushUnregistered:
+        result.Failed = append(result.Failed, recipient)
+        result.Unregistered = append(result.Unregistered, recipient)
+      }
+    }(recipient)
+  }
+  wait.Wait()
+  return result
+}
 
-  req.Header.Set("Authorization", "key=" + config.GoogleKey)
-  req.Header.Set("Content-Type", "application/json")
+// pushData returns the fields of the given notification sent along the push,
+// for the app to open its content, the message being in the locale of the device
+func pushData(notification Notification, recipient PushRecipient) map[string]string {
+  notification = notification.In(recipient.Locale)
+  data := map[string]string{
+    "id":      recipient.Notification.Hex(),
+    "type":    notification.Type,
+    "sender":  notification.Sender.Hex(),
+    "content": notification.Content.Hex(),
+    "message": notification.Message,
+  }
+  if notification.Comment.ID != "" {
+    data["comment"] = notification.Comment.ID.Hex()
+  }
+  return data
+}
 
-  client := &http.Client{}
-  resp, err := client.Do(req)
+// signJWT returns the JSON Web Token of the given header and claims, signed by sign
+func signJWT(header map[string]interface{}, claims map[string]interface{}, sign func(data []byte) ([]byte, error)) (string, error) {
+  encodedHeader, _ := json.Marshal(header)
+  encodedClaims, _ := json.Marshal(claims)
+  data := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(encodedClaims)
+  signature, err := sign([]byte(data))
   if err != nil {
-      panic(err)
+    return "", err
+  }
+  return data + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// FakePushProvider is a PushProvider recording the jobs instead of sending them,
+// to test the delivery offline. The recipients of which the token is in
+// Unregistered fail, the ones of which the token is in Unavailable are retried
+type FakePushProvider struct {
+  mutex        sync.Mutex
+  Jobs         []PushJob
+  Unregistered map[string]bool
+  Unavailable  map[string]bool
+}
+
+// Send records the given job
+func (p *FakePushProvider) Send(job PushJob) PushResult {
+  p.mutex.Lock()
+  defer p.mutex.Unlock()
+  p.Jobs = append(p.Jobs, job)
+  result := PushResult{}
+  for _, recipient := range job.Recipients {
+    if p.Unregistered[recipient.Token] {
+      result.Failed = append(result.Failed, recipient)
+      result.Unregistered = append(result.Unregistered, recipient)
+      result.Err = errors.New("unregistered token")
+    } else if p.Unavailable[recipient.Token] {
+      result.Retry = append(result.Retry, recipient)
+      result.Err = errors.New("unavailable")
+    }
   }
-  defer resp.Body.Close()
-  fmt.Println("response Status:", resp.Status)
+  return result
+}
 
-  done <- true
+// Sent returns the jobs recorded so far
+func (p *FakePushProvider) Sent() []PushJob {
+  p.mutex.Lock()
+  defer p.mutex.Unlock()
+  return append([]PushJob{}, p.Jobs...)
 }
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Status of a PushJob, and delivery of the Notification it pushes
+const (
+	PushPending = "pending"
+	PushSending = "sending"
+	PushSent    = "sent"
+	PushFailed  = "failed"
+)
+
+const (
+	// pushLease is how long a worker owns a claimed PushJob. A job still
+	// sending after it, because its worker died, is claimed again, and the
+	// late worker can no longer update it
+	pushLease = 2 * time.Minute
+	// pushBackoff is the delay before the first retry, doubled at each attempt
+	pushBackoff    = 30 * time.Second
+	pushMaxBackoff = time.Hour
+	// pushPollInterval is how often an idle worker looks for due jobs
+	pushPollInterval = 15 * time.Second
+)
+
+// pushWorkers, pushBatchSize and pushMaxAttempts tune the delivery of the
+// PushJob. They are replaced in main by the ones of the config file, if any
+var (
+	pushWorkers     = 4
+	pushBatchSize   = 500
+	pushMaxAttempts = 6
+)
+
+// pushSignal wakes up an idle worker when jobs are enqueued
+var pushSignal = make(chan struct{}, 1)
+
+// PushRecipient is a device a PushJob pushes a Notification to
+type PushRecipient struct {
+	Notification bson.ObjectId `json:"notification"`
+	User         bson.ObjectId `json:"user"`
+	Token        string        `json:"token"`
+	// Locale is the language of the device, in which the message is pushed
+	Locale string `json:"locale,omitempty" bson:"locale,omitempty"`
+	// Badge is the number of unread notifications of the user, set when sending
+	Badge int `json:"-" bson:"-"`
+}
+
+// PushJob is a batch of pushes of the same Notification to devices of the
+// same os, persisted until delivered. Notification is the template of the
+// Notification of each recipient, without its ID and Receiver
+type PushJob struct {
+	ID           bson.ObjectId   `bson:"_id,omitempty"`
+	Os           string          `json:"os"`
+	Notification Notification    `json:"notification"`
+	Recipients   []PushRecipient `json:"recipients"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	NextAttempt  time.Time       `json:"nextattempt"`
+	LastError    string          `json:"lasterror,omitempty" bson:"lasterror,omitempty"`
+	CreatedAt    time.Time       `json:"createdat"`
+	DoneAt       time.Time       `json:"doneat,omitempty" bson:"doneat,omitempty"`
+	// Lease identifies the claim of the worker sending the job, which is
+	// the only one allowed to update it
+	Lease string `json:"-" bson:"lease,omitempty"`
+}
+
+// EnqueueNotification will add the in-app Notification to the inbox of each
+// given user, and queue the pushes to their iOS and android devices in batches
+// of devices of the same os. The users who disabled the notification in their
+// NotificationPreferences are not pushed, and the pushes to the users in their
+// quiet hours are deferred to the end of them
+func EnqueueNotification(notification Notification, receivers []bson.ObjectId) error {
+	preferences, err := getNotificationPreferences(receivers)
+	if err != nil {
+		return err
+	}
+	devices, err := store.FindNotificationUsersForUsers(receivers)
+	if err != nil {
+		return err
+	}
+	userDevices := map[bson.ObjectId][]NotificationUser{}
+	for _, device := range append(filterDevicesByOs("iOS", devices), filterDevicesByOs("android", devices)...) {
+		userDevices[device.UserId] = append(userDevices[device.UserId], device)
+	}
+
+	now := time.Now()
+	type batchKey struct {
+		os string
+		at time.Time
+	}
+	batches := map[batchKey][]PushRecipient{}
+	received := map[bson.ObjectId]bool{}
+	for _, receiver := range receivers {
+		if received[receiver] {
+			continue
+		}
+		received[receiver] = true
+		preference := preferences[receiver]
+		pushed := preference.Allows(notification) && len(userDevices[receiver]) > 0
+		inbox := notification
+		inbox.Receiver = receiver
+		if pushed {
+			inbox.Delivery = PushPending
+		}
+		inbox, push, err := receiveNotification(inbox)
+		if err != nil {
+			log.Println("[error] Failed to add notification for", receiver.Hex(), err)
+			continue
+		}
+		if !pushed || !push {
+			// an aggregated notification still unread is not pushed again
+			continue
+		}
+		at := now
+		if until := preference.DeferUntil(now); !until.IsZero() {
+			at = until.UTC()
+		}
+		for _, device := range userDevices[receiver] {
+			key := batchKey{os: device.Os, at: at}
+			batches[key] = append(batches[key], PushRecipient{Notification: inbox.ID, User: receiver, Token: device.Token, Locale: device.Locale})
+		}
+	}
+
+	jobs := []PushJob{}
+	for key, recipients := range batches {
+		for start := 0; start < len(recipients); start += pushBatchSize {
+			end := start + pushBatchSize
+			if end > len(recipients) {
+				end = len(recipients)
+			}
+			jobs = append(jobs, PushJob{
+				ID:           bson.NewObjectId(),
+				Os:           key.os,
+				Notification: notification,
+				Recipients:   recipients[start:end],
+				Status:       PushPending,
+				NextAttempt:  key.at,
+				CreatedAt:    now,
+			})
+		}
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	if err := store.InsertPushJobs(jobs); err != nil {
+		return err
+	}
+	select {
+	case pushSignal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// receiveNotification adds the given notification to the inbox of its receiver,
+// aggregated with the previous one if of an aggregated type. It returns whether
+// to push it
+func receiveNotification(notification Notification) (Notification, bool, error) {
+	if _, ok := aggregatedTexts[notification.Type]; ok {
+		return AggregateNotification(notification)
+	}
+	notification, err := AddNotification(notification)
+	return notification, err == nil, err
+}
+
+// StartPushWorkers will start the given number of workers delivering the
+// queued PushJob, including the ones left undelivered by a previous run
+func StartPushWorkers(workers int) {
+	for i := 0; i < workers; i++ {
+		go pushWorker()
+	}
+}
+
+func pushWorker() {
+	for {
+		job, err := store.ClaimPushJob(time.Now(), pushLease)
+		if err == nil {
+			deliverPushJob(job)
+			continue
+		}
+		if err != ErrNotFound {
+			log.Println("[error] Failed to claim a push job", err)
+		}
+		select {
+		case <-pushSignal:
+		case <-time.After(pushPollInterval):
+		}
+	}
+}
+
+// deliverPushJob will send the given job, record the delivery of its
+// notifications and reschedule it with the recipients to try again
+func deliverPushJob(job PushJob) {
+	for i, recipient := range job.Recipients {
+		job.Recipients[i].Badge, _ = GetUnreadCount(recipient.User)
+	}
+	result := sendPushJob(job)
+	job.Attempts++
+	retry := result.Retry
+	failed := result.Failed
+	if len(retry) > 0 && job.Attempts >= pushMaxAttempts {
+		failed = append(failed, retry...)
+		retry = nil
+	}
+
+	pending := map[string]bool{}
+	for _, recipient := range append(retry, failed...) {
+		pending[recipient.Token] = true
+	}
+	sent := []bson.ObjectId{}
+	for _, recipient := range job.Recipients {
+		if !pending[recipient.Token] {
+			sent = append(sent, recipient.Notification)
+		}
+	}
+	setNotificationsDelivery(sent, PushSent)
+	setNotificationsDelivery(recipientNotifications(failed), PushFailed)
+	if err := DeleteNotificationTokens(recipientTokens(result.Unregistered)); err != nil {
+		log.Println("[error] Failed to remove", len(result.Unregistered), "unregistered devices", err)
+	}
+
+	if result.Err != nil {
+		log.Println("[error] Push job", job.ID.Hex(), "attempt", job.Attempts, result.Err)
+		job.LastError = result.Err.Error()
+	}
+	if len(retry) > 0 {
+		job.Status = PushPending
+		job.Recipients = retry
+		job.NextAttempt = time.Now().Add(pushBackoffFor(job.Attempts))
+	} else {
+		job.Status = PushSent
+		if len(sent) == 0 {
+			job.Status = PushFailed
+		}
+		job.DoneAt = time.Now()
+	}
+	if err := store.UpdatePushJob(job); err == ErrNotFound {
+		log.Println("[error] Lost the lease of push job", job.ID.Hex(), "before updating it")
+	} else if err != nil {
+		log.Println("[error] Failed to update push job", job.ID.Hex(), err)
+	}
+}
+
+// pushBackoffFor returns the delay before the attempt following the given one
+func pushBackoffFor(attempts int) time.Duration {
+	backoff := pushBackoff
+	for i := 1; i < attempts && backoff < pushMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > pushMaxBackoff {
+		return pushMaxBackoff
+	}
+	return backoff
+}
+
+func recipientNotifications(recipients []PushRecipient) []bson.ObjectId {
+	result := []bson.ObjectId{}
+	for _, recipient := range recipients {
+		result = append(result, recipient.Notification)
+	}
+	return result
+}
+
+func recipientTokens(recipients []PushRecipient) []string {
+	result := []string{}
+	for _, recipient := range recipients {
+		result = append(result, recipient.Token)
+	}
+	return result
+}
+
+func setNotificationsDelivery(ids []bson.ObjectId, status string) {
+	if len(ids) == 0 {
+		return
+	}
+	if err := store.SetNotificationsDelivery(ids, status); err != nil {
+		log.Println("[error] Failed to set the delivery of", len(ids), "notifications", err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// newTestPushJob replaces the store and the push providers by a MemoryStore
+// and a FakePushProvider, and queues a job pushing a notification to a
+// device of the given token. It returns the claimed job
+func newTestPushJob(t *testing.T, token string) (*MemoryStore, *FakePushProvider, PushJob) {
+	t.Helper()
+	memory := newTestStore()
+	fake := &FakePushProvider{Unregistered: map[string]bool{}, Unavailable: map[string]bool{}}
+	providers := pushProviders
+	pushProviders = map[string]PushProvider{"iOS": fake}
+	t.Cleanup(func() { pushProviders = providers })
+
+	user, _ := newTestUser(t, "alice")
+	if err := CreateOrUpdateNotificationUser(NotificationUser{UserId: user.ID, Token: token, Os: "iOS"}); err != nil {
+		t.Fatal(err)
+	}
+	notification, err := AddNotification(Notification{Receiver: user.ID, Content: bson.NewObjectId(), Type: "tag", Message: "@bob t'a taggé", Delivery: PushPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := PushJob{
+		Os:           "iOS",
+		Notification: notification,
+		Recipients:   []PushRecipient{{Notification: notification.ID, User: user.ID, Token: token}},
+		Status:       PushPending,
+		CreatedAt:    time.Now(),
+	}
+	if err := memory.InsertPushJobs([]PushJob{job}); err != nil {
+		t.Fatal(err)
+	}
+	if job, err = memory.ClaimPushJob(time.Now(), pushLease); err != nil {
+		t.Fatal(err)
+	}
+	return memory, fake, job
+}
+
+// expectDelivery fails if the job and its notification are not of the given statuses
+func expectDelivery(t *testing.T, memory *MemoryStore, job PushJob, jobStatus string, delivery string) PushJob {
+	t.Helper()
+	job = memory.pushJobs[job.ID]
+	if job.Status != jobStatus {
+		t.Fatalf("expected the job to be %s, got %s", jobStatus, job.Status)
+	}
+	if notification := memory.notifications[job.Notification.ID]; notification.Delivery != delivery {
+		t.Fatalf("expected the notification to be %s, got %s", delivery, notification.Delivery)
+	}
+	return job
+}
+
+func TestEnqueueNotificationBatchesPerOs(t *testing.T) {
+	memory := newTestStore()
+	batchSize := pushBatchSize
+	pushBatchSize = 2
+	t.Cleanup(func() { pushBatchSize = batchSize })
+	users := []bson.ObjectId{}
+	for i, os := range []string{"iOS", "iOS", "iOS", "android"} {
+		user, _ := newTestUser(t, "user"+string(rune('a'+i)))
+		if err := CreateOrUpdateNotificationUser(NotificationUser{UserId: user.ID, Token: user.Username, Os: os}); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user.ID)
+	}
+	notification := Notification{Sender: bson.NewObjectId(), Content: bson.NewObjectId(), Message: "@bde a posté une nouvelle news", Type: "post"}
+	if err := EnqueueNotification(notification, users); err != nil {
+		t.Fatal(err)
+	}
+	recipients := map[string]int{}
+	for _, job := range memory.pushJobs {
+		if job.Status != PushPending || len(job.Recipients) > pushBatchSize {
+			t.Fatalf("unexpected job %+v", job)
+		}
+		recipients[job.Os] += len(job.Recipients)
+	}
+	if len(memory.pushJobs) != 3 || recipients["iOS"] != 3 || recipients["android"] != 1 {
+		t.Fatalf("expected 3 jobs for 3 iOS and 1 android devices, got %d jobs for %v", len(memory.pushJobs), recipients)
+	}
+	for _, user := range users {
+		notifications, _, _ := GetNotificationsForUser(user, NotificationFilter{}, Page{Limit: 10})
+		if len(notifications) != 1 || notifications[0].Delivery != PushPending {
+			t.Fatalf("expected a pending notification for %s, got %+v", user.Hex(), notifications)
+		}
+	}
+}
+
+func TestClaimPushJobLease(t *testing.T) {
+	memory := newTestStore()
+	job := PushJob{ID: bson.NewObjectId(), Os: "iOS", Status: PushPending, NextAttempt: time.Now(), CreatedAt: time.Now()}
+	if err := memory.InsertPushJobs([]PushJob{job}); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := memory.ClaimPushJob(time.Now(), pushLease)
+	if err != nil || claimed.ID != job.ID || claimed.Status != PushSending {
+		t.Fatalf("expected the job to be claimed, got %+v %v", claimed, err)
+	}
+	if _, err := memory.ClaimPushJob(time.Now(), pushLease); err != ErrNotFound {
+		t.Fatalf("expected a claimed job not to be claimed twice, got %v", err)
+	}
+	if _, err := memory.ClaimPushJob(time.Now().Add(pushLease), pushLease); err != nil {
+		t.Fatalf("expected the job to be claimed again once its lease is over, got %v", err)
+	}
+}
+
+func TestUpdatePushJobRequiresLease(t *testing.T) {
+	memory, _, late := newTestPushJob(t, "token")
+	current, err := memory.ClaimPushJob(time.Now().Add(pushLease), pushLease)
+	if err != nil || current.Lease == late.Lease {
+		t.Fatalf("expected the job to be claimed again with a new lease, got %+v %v", current, err)
+	}
+	deliverPushJob(late)
+	if job := memory.pushJobs[late.ID]; job.Status != PushSending || job.Lease != current.Lease {
+		t.Fatalf("expected the late worker not to update the job, got %+v", job)
+	}
+	deliverPushJob(current)
+	if job := memory.pushJobs[late.ID]; job.Status != PushSent || job.Lease != "" {
+		t.Fatalf("expected the job to be sent by the worker holding the lease, got %+v", job)
+	}
+}
+
+func TestDeliverPushJobFailsUnsupportedOs(t *testing.T) {
+	memory := newTestStore()
+	user, _ := newTestUser(t, "alice")
+	notification, err := AddNotification(Notification{Receiver: user.ID, Content: bson.NewObjectId(), Type: "post", Delivery: PushPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := PushJob{ID: bson.NewObjectId(), Os: "web", Notification: notification, Status: PushSending,
+		Recipients: []PushRecipient{{Notification: notification.ID, User: user.ID, Token: "token"}}}
+	if err := memory.InsertPushJobs([]PushJob{job}); err != nil {
+		t.Fatal(err)
+	}
+	deliverPushJob(job)
+	if job = memory.pushJobs[job.ID]; job.Status != PushFailed || job.Attempts != 1 || job.LastError == "" || job.DoneAt.IsZero() {
+		t.Fatalf("expected the job to fail, got %+v", job)
+	}
+	if delivery := memory.notifications[notification.ID].Delivery; delivery != PushFailed {
+		t.Fatalf("expected the notification to be failed, got %s", delivery)
+	}
+}
+
+func TestDeliverPushJobSends(t *testing.T) {
+	memory, fake, job := newTestPushJob(t, "token")
+	deliverPushJob(job)
+	if sent := fake.Sent(); len(sent) != 1 || sent[0].Recipients[0].Badge != 1 {
+		t.Fatalf("expected one push with a badge of 1, got %+v", sent)
+	}
+	job = expectDelivery(t, memory, job, PushSent, PushSent)
+	if job.Attempts != 1 || job.DoneAt.IsZero() {
+		t.Fatalf("expected the job to be done after 1 attempt, got %+v", job)
+	}
+}
+
+func TestDeliverPushJobRetries(t *testing.T) {
+	memory, fake, job := newTestPushJob(t, "token")
+	fake.Unavailable["token"] = true
+	for attempt := 1; attempt <= 2; attempt++ {
+		before := time.Now()
+		deliverPushJob(job)
+		job = expectDelivery(t, memory, job, PushPending, PushPending)
+		if job.Attempts != attempt || job.LastError == "" || len(job.Recipients) != 1 {
+			t.Fatalf("expected attempt %d to be recorded, got %+v", attempt, job)
+		}
+		if !job.DoneAt.IsZero() || job.Lease != "" {
+			t.Fatalf("expected the job to retry not to be done nor claimed, got %+v", job)
+		}
+		if backoff := job.NextAttempt.Sub(before); backoff < pushBackoffFor(attempt) || backoff > pushBackoffFor(attempt)+time.Second {
+			t.Fatalf("expected a backoff of %s after attempt %d, got %s", pushBackoffFor(attempt), attempt, backoff)
+		}
+	}
+	if _, err := memory.ClaimPushJob(time.Now(), pushLease); err != ErrNotFound {
+		t.Fatalf("expected the job not to be due before its backoff, got %v", err)
+	}
+}
+
+func TestDeliverPushJobFailsAfterMaxAttempts(t *testing.T) {
+	memory, fake, job := newTestPushJob(t, "token")
+	fake.Unavailable["token"] = true
+	job.Attempts = pushMaxAttempts - 1
+	deliverPushJob(job)
+	job = expectDelivery(t, memory, job, PushFailed, PushFailed)
+	if job.Attempts != pushMaxAttempts || job.DoneAt.IsZero() {
+		t.Fatalf("expected the job to be done after %d attempts, got %+v", pushMaxAttempts, job)
+	}
+}
+
+func TestDeliverPushJobRemovesUnregisteredDevice(t *testing.T) {
+	memory, fake, job := newTestPushJob(t, "token")
+	fake.Unregistered["token"] = true
+	deliverPushJob(job)
+	expectDelivery(t, memory, job, PushFailed, PushFailed)
+	devices, err := memory.FindNotificationUsersForUser(job.Recipients[0].User)
+	if err != nil || len(devices) != 0 {
+		t.Fatalf("expected the device to be removed, got %v %v", devices, err)
+	}
+}
+
+func TestPushBackoff(t *testing.T) {
+	if pushBackoffFor(1) != pushBackoff || pushBackoffFor(2) != 2*pushBackoff || pushBackoffFor(3) != 4*pushBackoff {
+		t.Fatalf("expected the backoff to double, got %s, %s and %s", pushBackoffFor(1), pushBackoffFor(2), pushBackoffFor(3))
+	}
+	if pushBackoffFor(30) != pushMaxBackoff {
+		t.Fatalf("expected the backoff to be capped, got %s", pushBackoffFor(30))
+	}
+}
+
+func TestEnqueueNotificationAlwaysFillsInbox(t *testing.T) {
+	memory := newTestStore()
+	alice, _ := newTestUser(t, "alice")
+	bob, _ := newTestUser(t, "bob")
+	carol, _ := newTestUser(t, "carol")
+	for _, user := range []User{bob, carol} {
+		if err := CreateOrUpdateNotificationUser(NotificationUser{UserId: user.ID, Token: user.Username, Os: "iOS"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := UpdateNotificationPreferences(carol.ID, NotificationPreferences{Types: map[string]bool{"tag": false}}); err != nil {
+		t.Fatal(err)
+	}
+	notification := Notification{Sender: bson.NewObjectId(), Content: bson.NewObjectId(), Message: "@dave t'a taggé", Type: "tag"}
+	if err := EnqueueNotification(notification, []bson.ObjectId{alice.ID, bob.ID, carol.ID, bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []User{alice, bob, carol} {
+		if count, _ := GetUnreadCount(user.ID); count != 1 {
+			t.Fatalf("expected %s to have 1 notification, got %d", user.Username, count)
+		}
+	}
+	if len(memory.pushJobs) != 1 {
+		t.Fatalf("expected 1 push job, got %d", len(memory.pushJobs))
+	}
+	for _, job := range memory.pushJobs {
+		if len(job.Recipients) != 1 || job.Recipients[0].User != bob.ID {
+			t.Fatalf("expected only bob to be pushed, got %+v", job.Recipients)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCreateOrUpdateNotificationUserPerDevice(t *testing.T) {
+	memory := newTestStore()
+	alice, bob := bson.NewObjectId(), bson.NewObjectId()
+	for _, device := range []NotificationUser{
+		{UserId: alice, Token: "phone", Os: "iOS"},
+		{UserId: alice, Token: "tablet", Os: "android"},
+		{UserId: alice, Token: "phone", Os: "iOS", AppVersion: "3.1"},
+	} {
+		if err := CreateOrUpdateNotificationUser(device); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CreateOrUpdateNotificationUser(NotificationUser{UserId: alice, Os: "iOS"}); err == nil {
+		t.Fatal("expected a device without token to be refused")
+	}
+	if devices, _ := memory.FindNotificationUsersForUser(alice); len(devices) != 2 {
+		t.Fatalf("expected a registration per device, got %+v", devices)
+	}
+
+	if err := CreateOrUpdateNotificationUser(NotificationUser{UserId: bob, Token: "phone", Os: "iOS"}); err != nil {
+		t.Fatal(err)
+	}
+	if devices, _ := memory.FindNotificationUsersForUser(alice); len(devices) != 1 || devices[0].Token != "tablet" {
+		t.Fatalf("expected the phone to be moved to bob, got %+v", devices)
+	}
+	if err := DeleteNotificationTokens([]string{"tablet"}); err != nil {
+		t.Fatal(err)
+	}
+	if devices, _ := memory.FindNotificationUsersForUser(alice); len(devices) != 0 {
+		t.Fatalf("expected the tablet to be removed, got %+v", devices)
+	}
+}
+
+// newTestComment comments the given post as the given user, replying to the given comment if any,
+// and triggers its notifications
+func newTestComment(t *testing.T, post Post, user User, replyTo bson.ObjectId) Comment {
+	t.Helper()
+	comment := Comment{ID: bson.NewObjectId(), User: user.ID, Content: "Super", Date: time.Now(), ReplyTo: replyTo}
+	if _, err := CommentPost(post.ID, comment); err != nil {
+		t.Fatal(err)
+	}
+	TriggerNotificationForComment(post, comment)
+	return comment
+}
+
+// expectInbox fails if the inbox of the given user does not hold exactly the given messages
+func expectInbox(t *testing.T, userID bson.ObjectId, messages ...string) Notifications {
+	t.Helper()
+	notifications, _, err := GetNotificationsForUser(userID, NotificationFilter{}, Page{Limit: 10})
+	if err != nil || len(notifications) != len(messages) {
+		t.Fatalf("expected the messages %v, got %+v %v", messages, notifications, err)
+	}
+	for i, message := range messages {
+		if notifications[i].Message != message {
+			t.Fatalf("expected the messages %v, got %+v", messages, notifications)
+		}
+	}
+	return notifications
+}
+
+func TestCommentNotificationsAreAggregated(t *testing.T) {
+	memory := newTestStore()
+	association := newTestAssociation(t, "BDE")
+	alice, _ := newTestUser(t, "alice")
+	member, err := InviteMember(association.ID, bson.NewObjectId(), alice.ID, "", RoleAssociationEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RespondInvitation(member, alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateOrUpdateNotificationUser(NotificationUser{UserId: alice.ID, Token: "alice", Os: "iOS"}); err != nil {
+		t.Fatal(err)
+	}
+	post, err := AddPost(Post{Title: "News", Association: association.ID, Date: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// forget the invitation of alice
+	if err := DeleteNotificationsForUser(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := newTestUser(t, "bob")
+	carol, _ := newTestUser(t, "carol")
+	newTestComment(t, post, bob, "")
+	newTestComment(t, post, carol, "")
+	newTestComment(t, post, bob, "")
+	notifications := expectInbox(t, alice.ID, `@bob et 1 autre personne ont commenté "News"`)
+	if len(notifications[0].Actors) != 2 || notifications[0].Sender != bob.ID {
+		t.Fatalf("expected bob and carol to be the actors, got %+v", notifications[0])
+	}
+	if len(memory.pushJobs) != 1 {
+		t.Fatalf("expected an unread aggregated notification not to be pushed again, got %d jobs", len(memory.pushJobs))
+	}
+
+	if _, err := ReadNotificationForUser(alice.ID, notifications[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	dave, _ := newTestUser(t, "dave")
+	newTestComment(t, post, dave, "")
+	notifications = expectInbox(t, alice.ID, `@dave et 2 autres personnes ont commenté "News"`)
+	if notifications[0].Seen || len(memory.pushJobs) != 2 {
+		t.Fatalf("expected the notification read before to be unread and pushed again, got %+v and %d jobs", notifications[0], len(memory.pushJobs))
+	}
+}
+
+func TestReplyNotifiesAuthor(t *testing.T) {
+	newTestStore()
+	association := newTestAssociation(t, "BDE")
+	post, err := AddPost(Post{Title: "News", Association: association.ID, Date: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _ := newTestUser(t, "bob")
+	carol, _ := newTestUser(t, "carol")
+	if err := CreateOrUpdateNotificationUser(NotificationUser{UserId: bob.ID, Token: "bob", Os: "android"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CommentPost(post.ID, Comment{ID: bson.NewObjectId(), User: carol.ID, Content: "Super", ReplyTo: bson.NewObjectId()}); err == nil {
+		t.Fatal("expected a reply to an unknown comment to be refused")
+	}
+	comment := newTestComment(t, post, bob, "")
+	newTestComment(t, post, bob, comment.ID)
+	expectInbox(t, bob.ID)
+	newTestComment(t, post, carol, comment.ID)
+	expectInbox(t, bob.ID, `@carol t'a répondu sur "News"`)
+}
+
+func TestUncommentPostRecomputesAggregatedNotification(t *testing.T) {
+	memory := newTestStore()
+	alice, _ := newTestUser(t, "alice")
+	bob, _ := newTestUser(t, "bob")
+	carol, _ := newTestUser(t, "carol")
+	post := Post{ID: bson.NewObjectId(), Title: "News"}
+	if err := memory.InsertPost(post); err != nil {
+		t.Fatal(err)
+	}
+	comments := []Comment{}
+	for _, user := range []User{bob, carol} {
+		comment := Comment{ID: bson.NewObjectId(), User: user.ID, Content: "Super", Date: time.Now()}
+		if err := memory.AddPostComment(post.ID, comment); err != nil {
+			t.Fatal(err)
+		}
+		text := NewLocalizedText(aggregatedTexts["comment"][0], "user", user.Username, "post", post.Title)
+		notification := Notification{Sender: user.ID, Receiver: alice.ID, Content: post.ID, Comment: comment, Message: text.In(defaultLocale), Text: &text, Type: "comment"}
+		if _, _, err := AggregateNotification(notification); err != nil {
+			t.Fatal(err)
+		}
+		comments = append(comments, comment)
+	}
+
+	if _, err := UncommentPost(post.ID, comments[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	notifications, _ := memory.FindAggregatedNotifications(post.ID)
+	if len(notifications) != 1 {
+		t.Fatalf("expected the aggregated notification to be kept, got %d", len(notifications))
+	}
+	notification := notifications[0]
+	if len(notification.Actors) != 1 || notification.Sender != bob.ID || notification.Comment.ID != comments[0].ID {
+		t.Fatalf("expected bob to be the only actor, got %+v", notification)
+	}
+	if message := notification.In(defaultLocale).Message; message != "@bob a commenté \"News\"" {
+		t.Fatalf("expected the message of bob, got %q", message)
+	}
+
+	if _, err := UncommentPost(post.ID, comments[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if notifications, _ := memory.FindAggregatedNotifications(post.ID); len(notifications) != 0 {
+		t.Fatalf("expected the notification without actor to be deleted, got %+v", notifications)
+	}
+}
+
+func TestLikeNotificationsAreAggregated(t *testing.T) {
+	newTestStore()
+	association := newTestAssociation(t, "BDE")
+	alice, _ := newTestUser(t, "alice")
+	member, err := InviteMember(association.ID, bson.NewObjectId(), alice.ID, "", RoleAssociationEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RespondInvitation(member, alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	post, err := AddPost(Post{Title: "News", Association: association.ID, Date: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteNotificationsForUser(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := newTestUser(t, "bob")
+	carol, _ := newTestUser(t, "carol")
+	for _, user := range []User{bob, carol, alice} {
+		liked, _, err := LikePostWithUser(post.ID, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		TriggerNotificationForLike(liked, user.ID)
+	}
+	notifications := expectInbox(t, alice.ID, `@carol et 1 autre personne ont aimé "News"`)
+	if len(notifications[0].Actors) != 2 || notifications[0].Comment.ID != "" {
+		t.Fatalf("expected bob and carol to be the actors, got %+v", notifications[0])
+	}
+
+	if _, _, err := DislikePostWithUser(post.ID, carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectInbox(t, alice.ID, `@bob a aimé "News"`)
+	if _, _, err := DislikePostWithUser(post.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectInbox(t, alice.ID)
+}
+
+func TestPushDataHoldsComment(t *testing.T) {
+	comment := Comment{ID: bson.NewObjectId()}
+	recipient := PushRecipient{Notification: bson.NewObjectId()}
+	for _, kind := range []string{"tag", "comment", "reply"} {
+		if data := pushData(Notification{Type: kind, Comment: comment}, recipient); data["comment"] != comment.ID.Hex() {
+			t.Fatalf("expected the %s push to hold the comment, got %v", kind, data)
+		}
+	}
+	if data := pushData(Notification{Type: "like"}, recipient); data["comment"] != "" {
+		t.Fatalf("expected a like push not to hold a comment, got %v", data)
+	}
+}
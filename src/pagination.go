@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// maxPageLimit is the maximum number of items a list endpoint answers at once
+const maxPageLimit = 100
+
+// ErrInvalidPage is returned when the limit or the cursor of a list request is malformed
+var ErrInvalidPage = NewAPIError(http.StatusBadRequest, "invalid_page",
+	"the limit must be a positive number and the cursor the one of a next link", "Mauvais Format")
+
+// Cursor is the position of the last item of a page. Date is the sort
+// key of the list, if any, and ID breaks the ties. It is opaque to the
+// clients, who only send back the cursor of the next link
+type Cursor struct {
+	Date time.Time     `json:"d"`
+	ID   bson.ObjectId `json:"i"`
+}
+
+// Page defines which items of a list are requested:
+// at most Limit items, from the one following After
+type Page struct {
+	Limit int
+	After *Cursor
+}
+
+// Encode returns the opaque string of the cursor
+func (c Cursor) Encode() string {
+	return encodeCursor(c)
+}
+
+// DecodeCursor returns the Cursor encoded in the given string
+func DecodeCursor(value string) (Cursor, error) {
+	var cursor Cursor
+	if err := decodeCursor(value, &cursor); err != nil || !cursor.ID.Valid() {
+		return cursor, ErrInvalidPage
+	}
+	return cursor, nil
+}
+
+// encodeCursor returns the opaque string of the given position
+func encodeCursor(position interface{}) string {
+	data, _ := json.Marshal(position)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes the position encoded by encodeCursor in the given string
+func decodeCursor(value string, position interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, position) != nil {
+		return ErrInvalidPage
+	}
+	return nil
+}
+
+// ParsePage returns the Page requested by the "limit" and "cursor"
+// query parameters, limited to defaultLimit items by default
+func ParsePage(r *http.Request, defaultLimit int) (Page, error) {
+	limit, err := parseLimit(r, defaultLimit)
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{Limit: limit}
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		cursor, err := DecodeCursor(value)
+		if err != nil {
+			return page, err
+		}
+		page.After = &cursor
+	}
+	return page, nil
+}
+
+// ParseOptionalPage returns the Page requested by the "limit" and "cursor"
+// query parameters as ParsePage does, or an empty Page, finding the whole
+// list, if the request has neither. It keeps unpaginated the lists the apps
+// fetched whole before
+func ParseOptionalPage(r *http.Request, defaultLimit int) (Page, error) {
+	query := r.URL.Query()
+	if query.Get("limit") == "" && query.Get("cursor") == "" {
+		return Page{}, nil
+	}
+	return ParsePage(r, defaultLimit)
+}
+
+// parseLimit returns the "limit" query parameter, defaultLimit
+// if there is none, and at most maxPageLimit
+func parseLimit(r *http.Request, defaultLimit int) (int, error) {
+	limit := defaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return 0, ErrInvalidPage
+		}
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return limit, nil
+}
+
+// peek returns the Page asking the store for one more item
+// than requested, to know if there is a next page. An empty
+// Page is kept as is, to find the whole list
+func (p Page) peek() Page {
+	if p.Limit == 0 {
+		return p
+	}
+	return Page{Limit: p.Limit + 1, After: p.After}
+}
+
+// more returns whether the given number of items
+// fetched with peek goes beyond this page
+func (p Page) more(count int) bool {
+	return p.Limit > 0 && count > p.Limit
+}
+
+// SetNextLink will set the Link header pointing to the page following
+// the given cursor, keeping the other query parameters of the request.
+// It must be called before writing the body
+func SetNextLink(w http.ResponseWriter, r *http.Request, page Page, next *Cursor) {
+	if next != nil {
+		setNextLink(w, r, page.Limit, next.Encode())
+	}
+}
+
+// setNextLink will set the Link header pointing to the
+// page of the given limit from the given encoded cursor
+func setNextLink(w http.ResponseWriter, r *http.Request, limit int, cursor string) {
+	query := r.URL.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("cursor", cursor)
+	w.Header().Set("Link", "<"+r.URL.Path+"?"+query.Encode()+">; rel=\"next\"")
+}
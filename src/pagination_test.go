@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParsePage(t *testing.T) {
+	cursor := Cursor{Date: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC), ID: bson.NewObjectId()}
+	page, err := ParsePage(httptest.NewRequest("GET", "/post?limit=500&cursor="+cursor.Encode(), nil), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Limit != maxPageLimit || page.After == nil || page.After.ID != cursor.ID || !page.After.Date.Equal(cursor.Date) {
+		t.Fatalf("expected the limit to be capped and the cursor decoded, got %+v", page)
+	}
+	if page, _ := ParsePage(httptest.NewRequest("GET", "/post", nil), 20); page.Limit != 20 || page.After != nil {
+		t.Fatalf("expected the default page, got %+v", page)
+	}
+	for _, query := range []string{"limit=0", "limit=ten", "cursor=notacursor", "cursor=e30"} {
+		if _, err := ParsePage(httptest.NewRequest("GET", "/post?"+query, nil), 20); err != ErrInvalidPage {
+			t.Errorf("%s: expected ErrInvalidPage, got %v", query, err)
+		}
+	}
+}
+
+func TestFutureEventsPagesBreakTies(t *testing.T) {
+	newTestStore()
+	association := newTestAssociation(t, "BDE")
+	start := time.Now().Add(time.Hour)
+	for i := 0; i < 5; i++ {
+		if _, err := AddEvent(Event{Name: "Gala", Association: association.ID, DateStart: start, DateEnd: start.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := map[bson.ObjectId]bool{}
+	page := Page{Limit: 2}
+	for pages := 1; ; pages++ {
+		events, next, err := GetFutureEvents(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			if seen[event.ID] {
+				t.Fatalf("event %s answered twice", event.ID.Hex())
+			}
+			seen[event.ID] = true
+		}
+		if next == nil {
+			if pages != 3 {
+				t.Fatalf("expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		page.After = next
+	}
+	if len(seen) != 5 {
+		t.Fatalf("expected the 5 events, got %d", len(seen))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms used to hash the password of an AssociationUser.
+// An empty Algorithm means a legacy MD5 hash
+const (
+	passwordAlgorithmMD5    = "md5"
+	passwordAlgorithmBcrypt = "bcrypt"
+)
+
+// passwordMinLength is the minimum length of a new password in characters,
+// and passwordMaxLength the maximum one in bytes, beyond which bcrypt fails
+const (
+	passwordMinLength = 10
+	passwordMaxLength = 72
+)
+
+// HashPassword will hash the given password with the current algorithm
+// and return the hash along with the name of the algorithm used
+func HashPassword(password string) (string, string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+	return string(hash), passwordAlgorithmBcrypt, nil
+}
+
+// dummyPasswordHash is compared to the password of a login of an unknown
+// username, for it to take as long as the one of a known username
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("insapp dummy password"), bcrypt.DefaultCost)
+
+// CheckPassword tells whether the given password matches
+// the hash stored for the given AssociationUser
+func CheckPassword(user AssociationUser, password string) bool {
+	switch user.Algorithm {
+	case passwordAlgorithmBcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+	case "", passwordAlgorithmMD5:
+		return user.Password == GetMD5Hash(password)
+	}
+	return false
+}
+
+// checkDummyPassword will compare the given password to dummyPasswordHash
+func checkDummyPassword(password string) {
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
+
+// NeedsRehash tells whether the password of the given
+// AssociationUser is not hashed with the current algorithm
+func NeedsRehash(user AssociationUser) bool {
+	return user.Algorithm != passwordAlgorithmBcrypt
+}
+
+// ValidatePasswordStrength will return an error if the given
+// password is too weak to be used for the given username
+func ValidatePasswordStrength(username string, password string) error {
+	if utf8.RuneCountInString(password) < passwordMinLength {
+		return ValidationError("Le mot de passe doit contenir au moins 10 caractères")
+	}
+	if len(password) > passwordMaxLength {
+		return ValidationError("Le mot de passe doit faire au plus 72 octets")
+	}
+	var hasLetter, hasDigit, hasOther bool
+	for _, char := range password {
+		switch {
+		case unicode.IsLetter(char):
+			hasLetter = true
+		case unicode.IsDigit(char):
+			hasDigit = true
+		default:
+			hasOther = true
+		}
+	}
+	if !hasLetter || !(hasDigit || hasOther) {
+		return ValidationError("Le mot de passe doit contenir des lettres et des chiffres ou symboles")
+	}
+	if len(username) > 0 && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return ValidationError("Le mot de passe ne doit pas contenir l'identifiant")
+	}
+	return nil
+}
+
+func GetMD5Hash(text string) string {
+	hasher := md5.New()
+	hasher.Write([]byte(text))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
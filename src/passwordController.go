@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// forgotPasswordByAddress and forgotPasswordByIP limit the reset links sent
+// to an address, and asked from an IP
+var (
+	forgotPasswordByAddress = newRateLimiter(3, time.Hour)
+	forgotPasswordByIP      = newRateLimiter(20, time.Hour)
+)
+
+// PasswordChange is the body expected to change or reset a password
+type PasswordChange struct {
+	Token       string `json:"token"`
+	OldPassword string `json:"oldpassword"`
+	NewPassword string `json:"newpassword"`
+}
+
+// ChangeAssociationPasswordController will change the password of the account of
+// the association linked to the given id used to log in, given its current password
+func ChangeAssociationPasswordController(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var change PasswordChange
+	if err := decoder.Decode(&change); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	vars := mux.Vars(r)
+	assocationID := vars["id"]
+	isValid := VerifyAssociationRequest(r, bson.ObjectIdHex(assocationID))
+	if !isValid {
+		Forbidden(w)
+		return
+	}
+	// a member of the board logged in through the CAS has no password
+	principal := GetPrincipal(r)
+	if principal.HasRole(RoleStudent) {
+		Forbidden(w)
+		return
+	}
+	user, err := store.FindAssociationUserByID(principal.ID)
+	if err == nil && user.Association != bson.ObjectIdHex(assocationID) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := ChangePassword(user, change.OldPassword, change.NewPassword); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"status": "ok"})
+}
+
+// ForgotAssociationPasswordController will email a reset link to the association
+// account with the given username. It always answers ok to not disclose the
+// accounts, but to an IP asking too many links. An address is sent a few
+// links per hour at most
+func ForgotAssociationPasswordController(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var login Login
+	if err := decoder.Decode(&login); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	now := time.Now()
+	if !forgotPasswordByIP.Allow(clientIP(r), now) {
+		WriteError(w, ErrRateLimited)
+		return
+	}
+	if len(login.Username) > 0 && forgotPasswordByAddress.Allow(strings.ToLower(login.Username), now) {
+		go func(username string) {
+			if err := RequestPasswordReset(username); err != nil {
+				log.Println("[error] Failed to send a password reset to", username, err)
+			}
+		}(login.Username)
+	}
+	json.NewEncoder(w).Encode(bson.M{"status": "ok"})
+}
+
+// ResetAssociationPasswordController will set the new password
+// of the association account linked to the emailed reset token
+func ResetAssociationPasswordController(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var change PasswordChange
+	if err := decoder.Decode(&change); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	if err := ResetPassword(change.Token, change.NewPassword); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"status": "ok"})
+}
+
+// ForceResetAssociationPasswordController lets a super user invalidate the
+// password of the association linked to the given id and email it a reset link
+func ForceResetAssociationPasswordController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	assocationID := vars["id"]
+	user, err := store.FindAssociationUser(bson.ObjectIdHex(assocationID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := ForcePasswordReset(user); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"status": "ok"})
+}
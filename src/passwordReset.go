@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// passwordResetDuration is how long a reset link sent by email stays valid
+const passwordResetDuration = time.Hour
+
+// ErrInvalidPasswordReset is returned when a reset token is unknown, expired or already used
+var ErrInvalidPasswordReset = NewAPIError(http.StatusBadRequest, "invalid_reset_token",
+	"the reset token is unknown, expired or already used", "Lien de réinitialisation invalide ou expiré")
+
+// ErrWrongPassword is returned when the current password given to change it is wrong
+var ErrWrongPassword = NewAPIError(http.StatusForbidden, "wrong_password",
+	"the current password is wrong", "Mot de passe incorrect")
+
+// PasswordReset defines how to model a single-use token
+// allowing an AssociationUser to choose a new password.
+// Only the SHA-256 of the token is stored, the token itself is only sent by email
+type PasswordReset struct {
+	ID        bson.ObjectId `bson:"_id,omitempty"`
+	Token     string        `json:"-"`
+	User      bson.ObjectId `json:"user" bson:"user"`
+	Used      bool          `json:"used"`
+	CreatedAt time.Time     `json:"createdat"`
+	ExpireAt  time.Time     `json:"expireat"`
+}
+
+// RequestPasswordReset will email a reset link to the AssociationUser with the given
+// username. Nothing is sent, and no error returned, if the username is unknown
+func RequestPasswordReset(username string) error {
+	users, err := store.FindAssociationUsersByUsername(username)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := SendPasswordReset(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendPasswordReset will create a new PasswordReset for
+// the given AssociationUser and email the link to its username
+func SendPasswordReset(user AssociationUser) error {
+	token := generateSessionToken()
+	now := time.Now()
+	reset := PasswordReset{
+		ID:        bson.NewObjectId(),
+		Token:     hashPasswordResetToken(token),
+		User:      user.ID,
+		Used:      false,
+		CreatedAt: now,
+		ExpireAt:  now.Add(passwordResetDuration),
+	}
+	if err := store.InsertPasswordReset(reset); err != nil {
+		return err
+	}
+	email, err := passwordResetTemplate.Render(user.Username, bson.M{"Username": user.Username, "URL": mailLinks.PasswordReset + token})
+	if err != nil {
+		return err
+	}
+	return QueueEmail(email)
+}
+
+var passwordResetTemplate = NewMailTemplate("passwordreset", `Réinitialisation de ton mot de passe Insapp`, `Bonjour,
+
+Une réinitialisation du mot de passe du compte Insapp {{.Username}} a été demandée.
+Pour choisir un nouveau mot de passe, suis ce lien, valable une heure :
+
+{{.URL}}
+
+Si tu n'es pas à l'origine de cette demande, tu peux ignorer ce message.`, `<!DOCTYPE html>
+<html>
+<body>
+<p>Bonjour,</p>
+<p>Une réinitialisation du mot de passe du compte Insapp <strong>{{.Username}}</strong> a été demandée.</p>
+<p><a href="{{.URL}}">Choisir un nouveau mot de passe</a>, ce lien est valable une heure.</p>
+<p><small>Si tu n'es pas à l'origine de cette demande, tu peux ignorer ce message.</small></p>
+</body>
+</html>
+`)
+
+// ResetPassword will set the given password on the AssociationUser
+// of the given reset token, and log out all of its sessions
+func ResetPassword(token string, password string) error {
+	reset, err := store.FindPasswordReset(hashPasswordResetToken(token))
+	if err != nil || reset.Used || time.Now().After(reset.ExpireAt) {
+		return ErrInvalidPasswordReset
+	}
+	user, err := store.FindAssociationUserByID(reset.User)
+	if err != nil {
+		return ErrInvalidPasswordReset
+	}
+	if err := ValidatePasswordStrength(user.Username, password); err != nil {
+		return err
+	}
+	if err := store.UsePasswordReset(reset.ID); err != nil {
+		return ErrInvalidPasswordReset
+	}
+	if err := setAssociationUserPassword(user, password); err != nil {
+		return err
+	}
+	store.RemovePasswordResetsForUser(user.ID)
+	return nil
+}
+
+// ChangePassword will replace the password of the given AssociationUser
+// if the current one is right, and log out all of its sessions
+func ChangePassword(user AssociationUser, current string, password string) error {
+	if !CheckPassword(user, current) {
+		return ErrWrongPassword
+	}
+	if err := ValidatePasswordStrength(user.Username, password); err != nil {
+		return err
+	}
+	return setAssociationUserPassword(user, password)
+}
+
+// ForcePasswordReset will make the current password of the given AssociationUser
+// unusable, log out all of its sessions and email it a reset link
+func ForcePasswordReset(user AssociationUser) error {
+	if err := setAssociationUserPassword(user, generateSessionToken()); err != nil {
+		return err
+	}
+	return SendPasswordReset(user)
+}
+
+// setAssociationUserPassword will hash and store the given password
+// and revoke the sessions opened with the former one
+func setAssociationUserPassword(user AssociationUser, password string) error {
+	hash, algorithm, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := store.UpdateAssociationUserPassword(user.ID, hash, algorithm); err != nil {
+		return err
+	}
+	return RevokeSessionTokensForOwner(user.ID)
+}
+
+func hashPasswordResetToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
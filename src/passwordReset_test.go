@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// newTestPasswordReset adds an AssociationUser with a session and a reset
+// token expiring at the given date, and returns them
+func newTestPasswordReset(t *testing.T, memory *MemoryStore, expireAt time.Time) (AssociationUser, *SessionToken, string) {
+	t.Helper()
+	user := AssociationUser{ID: bson.NewObjectId(), Username: "bde@insa-rennes.fr", Association: bson.NewObjectId()}
+	if err := memory.InsertAssociationUser(user); err != nil {
+		t.Fatal(err)
+	}
+	reset := PasswordReset{ID: bson.NewObjectId(), Token: hashPasswordResetToken("token"), User: user.ID, CreatedAt: time.Now(), ExpireAt: expireAt}
+	if err := memory.InsertPasswordReset(reset); err != nil {
+		t.Fatal(err)
+	}
+	session, err := logAssociation(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, session, "token"
+}
+
+func TestResetPassword(t *testing.T) {
+	memory := newTestStore()
+	user, session, token := newTestPasswordReset(t, memory, time.Now().Add(passwordResetDuration))
+	if err := ResetPassword(token, "short"); err == nil || err == ErrInvalidPasswordReset {
+		t.Fatalf("expected a weak password to be refused, got %v", err)
+	}
+	if err := ResetPassword(token, "correct horse 42"); err != nil {
+		t.Fatal(err)
+	}
+	if user = memory.associationUsers[user.ID]; !CheckPassword(user, "correct horse 42") {
+		t.Fatal("expected the new password to be set")
+	}
+	if _, err := testTokenStore.CheckToken(session.Token); err == nil {
+		t.Fatal("expected the sessions to be revoked")
+	}
+	if err := ResetPassword(token, "another horse 42"); err != ErrInvalidPasswordReset {
+		t.Fatalf("expected the token to be single use, got %v", err)
+	}
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+	memory := newTestStore()
+	_, _, token := newTestPasswordReset(t, memory, time.Now().Add(-time.Minute))
+	if err := ResetPassword(token, "correct horse 42"); err != ErrInvalidPasswordReset {
+		t.Fatalf("expected an expired token to be refused, got %v", err)
+	}
+	if err := ResetPassword("unknown", "correct horse 42"); err != ErrInvalidPasswordReset {
+		t.Fatalf("expected an unknown token to be refused, got %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	memory := newTestStore()
+	user, session, _ := newTestPasswordReset(t, memory, time.Now())
+	if err := setAssociationUserPassword(user, "correct horse 42"); err != nil {
+		t.Fatal(err)
+	}
+	user = memory.associationUsers[user.ID]
+	if err := ChangePassword(user, "wrong horse 42", "another horse 42"); err != ErrWrongPassword {
+		t.Fatalf("expected a wrong current password to be refused, got %v", err)
+	}
+	if err := ChangePassword(user, "correct horse 42", "another horse 42"); err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(memory.associationUsers[user.ID], "another horse 42") {
+		t.Fatal("expected the password to be changed")
+	}
+	if _, err := testTokenStore.CheckToken(session.Token); err == nil {
+		t.Fatal("expected the sessions to be revoked")
+	}
+}
+
+func TestForgotPasswordIsRateLimited(t *testing.T) {
+	newTestStore()
+	byIP, byAddress := forgotPasswordByIP, forgotPasswordByAddress
+	forgotPasswordByIP, forgotPasswordByAddress = newRateLimiter(2, time.Hour), newRateLimiter(1, time.Hour)
+	t.Cleanup(func() { forgotPasswordByIP, forgotPasswordByAddress = byIP, byAddress })
+
+	for i := 0; i < 2; i++ {
+		if w := serveTestRequest("POST", "/login/association/forgot", `{"username": "bde@insa-rennes.fr"}`, ""); w.Code != http.StatusOK {
+			t.Fatalf("expected request %d to be answered ok, got %d", i+1, w.Code)
+		}
+	}
+	if forgotPasswordByAddress.Allow("bde@insa-rennes.fr", time.Now()) {
+		t.Fatal("expected the address to be limited")
+	}
+	w := serveTestRequest("POST", "/login/association/forgot", `{"username": "bda@insa-rennes.fr"}`, "")
+	expectError(t, w, http.StatusTooManyRequests, "rate_limited")
+}
+
+func TestChangePasswordRefusesBoardMember(t *testing.T) {
+	newTestStore()
+	user, _ := newTestUser(t, "alice")
+	association := bson.NewObjectId()
+	session, err := NewSessionToken(Principal{ID: user.ID, Association: association, Roles: []Role{RoleStudent, RoleAssociationAdmin}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := serveTestRequest("PUT", "/association/"+association.Hex()+"/password", `{"oldpassword": "a", "newpassword": "correct horse 42"}`, session.Token)
+	expectError(t, w, http.StatusForbidden, "forbidden")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestValidatePasswordStrength(t *testing.T) {
+	for password, valid := range map[string]bool{
+		"short1":                         false,
+		"onlyletters":                    false,
+		"1234567890":                     false,
+		"alice-secret-1":                 false,
+		"correct horse 42":               true,
+		strings.Repeat("a1", 36):         true,
+		strings.Repeat("a1", 36) + "b":   false,
+		strings.Repeat("é", 35) + "1234": false,
+		"ééééééé12":                      false,
+		"éééééééé12":                     true,
+	} {
+		if err := ValidatePasswordStrength("alice", password); (err == nil) != valid {
+			t.Errorf("password %q: expected valid %v, got %v", password, valid, err)
+		}
+	}
+}
+
+func TestValidatePasswordStrengthMessages(t *testing.T) {
+	if err := ValidatePasswordStrength("", "ééééé1"); err == nil || err.Error() != "Le mot de passe doit contenir au moins 10 caractères" {
+		t.Fatalf("expected the password to be too short, got %v", err)
+	}
+	if err := ValidatePasswordStrength("", strings.Repeat("é", 36)+"1"); err == nil || err.Error() != "Le mot de passe doit faire au plus 72 octets" {
+		t.Fatalf("expected 37 characters of 73 bytes to be too long, got %v", err)
+	}
+}
+
+func TestHashPasswordAcceptsLongestPassword(t *testing.T) {
+	password := strings.Repeat("a1", passwordMaxLength/2)
+	hash, algorithm, err := HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(AssociationUser{Password: hash, Algorithm: algorithm}, password) {
+		t.Fatal("expected the password to match its hash")
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, algorithm, err := HashPassword("correct horse 42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := AssociationUser{Password: hash, Algorithm: algorithm}
+	if !CheckPassword(user, "correct horse 42") || CheckPassword(user, "wrong horse 42") {
+		t.Fatal("expected only the right password to match its bcrypt hash")
+	}
+	legacy := AssociationUser{Password: GetMD5Hash("correct horse 42")}
+	if !CheckPassword(legacy, "correct horse 42") || !NeedsRehash(legacy) || NeedsRehash(user) {
+		t.Fatal("expected a legacy MD5 hash to match and to need a rehash")
+	}
+}
+
+func TestLoginRehashesLegacyPassword(t *testing.T) {
+	memory := newTestStore()
+	user := AssociationUser{ID: bson.NewObjectId(), Username: "bde@insa-rennes.fr", Association: bson.NewObjectId(), Password: GetMD5Hash("correct horse 42")}
+	AddAssociationUser(user)
+	if _, err := checkLoginForAssociation(Login{Username: user.Username, Password: "wrong horse 42"}); err == nil {
+		t.Fatal("expected a wrong password to be refused")
+	}
+	if _, err := checkLoginForAssociation(Login{Username: "bda@insa-rennes.fr", Password: "correct horse 42"}); err != ErrWrongLogin {
+		t.Fatalf("expected an unknown username to be refused as a wrong login, got %v", err)
+	}
+	result, err := checkLoginForAssociation(Login{Username: user.Username, Password: "correct horse 42"})
+	if err != nil || result.Association != user.Association {
+		t.Fatalf("expected the login to succeed, got %+v %v", result, err)
+	}
+	if user = memory.associationUsers[user.ID]; user.Algorithm != passwordAlgorithmBcrypt || !CheckPassword(user, "correct horse 42") {
+		t.Fatalf("expected the password to be rehashed with bcrypt, got %+v", user)
+	}
+}
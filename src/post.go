@@ -1,9 +1,9 @@
 package main
 
 import (
+	"log"
 	"time"
 
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -12,12 +12,16 @@ type Post struct {
 	ID          bson.ObjectId   `bson:"_id,omitempty"`
 	Title       string          `json:"title"`
 	Association bson.ObjectId   `json:"association"`
+	Author      bson.ObjectId   `json:"author,omitempty" bson:"author,omitempty"`
 	Description string          `json:"description"`
 	Date        time.Time       `json:"date"`
 	Likes       []bson.ObjectId `json:"likes"`
 	Comments    Comments        `json:"comments"`
 	Image    		string          `json:"image"`
 	ImageSize		bson.M					`json:"imageSize"`
+	// Broadcast asks to notify every user instead of the followers,
+	// it is only honored for an Official association
+	Broadcast   bool            `json:"broadcast" bson:"broadcast,omitempty"`
 }
 
 // Posts is an array of Post
@@ -25,109 +29,98 @@ type Posts []Post
 
 
 // AddPost will add the given post to the database
-func AddPost(post Post) Post {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	db.Insert(post)
-	var result Post
-	db.Find(bson.M{"title": post.Title, "date": post.Date}).One(&result)
-	AddPostToAssociation(result.Association, result.ID)
-	return result
+func AddPost(post Post) (Post, error) {
+	if len(post.Title) == 0 {
+		return Post{}, ValidationError("Le titre de la news est obligatoire")
+	}
+	if _, err := store.FindAssociation(post.Association); err != nil {
+		return Post{}, err
+	}
+	post.ID = bson.NewObjectId()
+	if err := store.InsertPost(post); err != nil {
+		return Post{}, err
+	}
+	if _, err := AddPostToAssociation(post.Association, post.ID); err != nil {
+		return Post{}, err
+	}
+	return store.FindPost(post.ID)
 }
 
 // UpdatePost will update the post linked to the given ID,
 // with the field of the given post, in the database
-func UpdatePost(id bson.ObjectId, post Post) Post {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	postID := bson.M{"_id": id}
-	change := bson.M{"$set": bson.M{
-		"title"				:	post.Title,
-		"description"	:	post.Description,
-		"image"				:	post.Image,
-		"imageSize"		:	post.ImageSize,
-	}}
-	db.Update(postID, change)
-	var result Post
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
+func UpdatePost(id bson.ObjectId, post Post) (Post, error) {
+	if len(post.Title) == 0 {
+		return Post{}, ValidationError("Le titre de la news est obligatoire")
+	}
+	if err := store.UpdatePost(id, post); err != nil {
+		return Post{}, err
+	}
+	return store.FindPost(id)
 }
 
 // DeletePost will delete the given post from the database
-func DeletePost(post Post) Post {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	db.RemoveId(post.ID)
-	var result Post
-	db.FindId(post.ID).One(result)
+func DeletePost(post Post) error {
+	if err := store.RemovePost(post.ID); err != nil {
+		return err
+	}
 	DeleteNotificationsForPost(post.ID)
-	RemovePostFromAssociation(post.Association, post.ID)
+	if _, err := RemovePostFromAssociation(post.Association, post.ID); err != nil && err != ErrNotFound {
+		return err
+	}
 	for _, userId := range post.Likes{
-		DislikePost(userId, post.ID)
+		if _, err := DislikePost(userId, post.ID); err != nil && err != ErrNotFound {
+			return err
+		}
 	}
-	return result
+	return nil
 }
 
 // GetPost will return an Post object from the given ID
-func GetPost(id bson.ObjectId) Post {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	var result Post
-	db.FindId(id).One(&result)
-	return result
+func GetPost(id bson.ObjectId) (Post, error) {
+	return store.FindPost(id)
 }
 
-// GetLastestPosts will return an array of the last N Posts
-func GetLastestPosts(number int) Posts {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	var result Posts
-	db.Find(bson.M{}).Sort("-date").Limit(number).All(&result)
-	return result
+// GetLastestPosts will return the given page of the Posts, latest first,
+// and the cursor of the next page if there is one
+func GetLastestPosts(page Page) (Posts, *Cursor, error) {
+	posts, err := store.FindLatestPosts(page.peek())
+	if err != nil || !page.more(len(posts)) {
+		return posts, nil, err
+	}
+	posts = posts[:page.Limit]
+	last := posts[len(posts)-1]
+	return posts, &Cursor{Date: last.Date, ID: last.ID}, nil
 }
 
 // LikePostWithUser will add the user to the list of
 // user that liked the post (cf. Likes field)
-func LikePostWithUser(id bson.ObjectId, userID bson.ObjectId) (Post, User) {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	postID := bson.M{"_id": id}
-	change := bson.M{"$addToSet": bson.M{
-		"likes": userID,
-	}}
-	db.Update(postID, change)
-	var post Post
-	db.Find(bson.M{"_id": id}).One(&post)
-	user := LikePost(userID, post.ID)
-	return post, user
+func LikePostWithUser(id bson.ObjectId, userID bson.ObjectId) (Post, User, error) {
+	if err := store.AddPostLike(id, userID); err != nil {
+		return Post{}, User{}, err
+	}
+	post, err := store.FindPost(id)
+	if err != nil {
+		return Post{}, User{}, err
+	}
+	publishPost(post)
+	user, err := LikePost(userID, post.ID)
+	return post, user, err
 }
 
 // DislikePostWithUser will remove the user to the list of
 // users that liked the post (cf. Likes field)
-func DislikePostWithUser(id bson.ObjectId, userID bson.ObjectId) (Post, User) {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("post")
-	postID := bson.M{"_id": id}
-	change := bson.M{"$pull": bson.M{
-		"likes": userID,
-	}}
-	db.Update(postID, change)
-	var post Post
-	db.Find(bson.M{"_id": id}).One(&post)
-	user := DislikePost(userID, post.ID)
-	return post, user
+func DislikePostWithUser(id bson.ObjectId, userID bson.ObjectId) (Post, User, error) {
+	if err := store.RemovePostLike(id, userID); err != nil {
+		return Post{}, User{}, err
+	}
+	post, err := store.FindPost(id)
+	if err != nil {
+		return Post{}, User{}, err
+	}
+	publishPost(post)
+	if err := DeleteNotificationsForLike(post, userID); err != nil {
+		log.Println("[error] Failed to delete the notifications of the like of", userID.Hex(), err)
+	}
+	user, err := DislikePost(userID, post.ID)
+	return post, user, err
 }
@@ -9,7 +9,6 @@ import (
 	"io/ioutil"
 	"gopkg.in/mgo.v2/bson"
 	"github.com/gorilla/mux"
-	"github.com/freehaha/token-auth"
 )
 
 // GetPostController will answer a JSON of the post
@@ -17,14 +16,28 @@ import (
 func GetPostController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	postID := vars["id"]
-	var res = GetPost(bson.ObjectIdHex(postID))
+	res, err := GetPost(bson.ObjectIdHex(postID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(res)
 }
 
-// GetLastestPostsController will answer a JSON of the
-// N lastest post. Here N = 50.
+// GetLastestPostsController will answer a JSON of a page
+// of the lastest posts, 50 by default.
 func GetLastestPostsController(w http.ResponseWriter, r *http.Request) {
-	var res = GetLastestPosts(50)
+	page, err := ParsePage(r, 50)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	res, next, err := GetLastestPosts(page)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	SetNextLink(w, r, page, next)
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -33,20 +46,28 @@ func GetLastestPostsController(w http.ResponseWriter, r *http.Request) {
 func AddPostController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var post Post
-	decoder.Decode(&post)
+	if err := decoder.Decode(&post); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
 	post.Date = time.Now()
 
 	isValid := VerifyAssociationRequest(r, post.Association)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
 		return
 	}
 
-	res := AddPost(post)
-	asso := GetAssociation(post.Association)
+	post.Author = GetPrincipal(r).ID
+	res, err := AddPost(post)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	RecordActivity(res.Association, post.Author, "post:add", res.ID)
+	asso, _ := GetAssociation(post.Association)
 	json.NewEncoder(w).Encode(res)
-	go TriggerNotificationForPost(asso.ID, res.ID, "@" + strings.ToLower(asso.Name) + " a posté une nouvelle news 📰")
+	go TriggerNotificationForPost(asso.ID, res.ID, NewLocalizedText("@{association} a posté une nouvelle news 📰", "association", strings.ToLower(asso.Name)), res.Broadcast && asso.Official)
 }
 
 // UpdatePostController will answer the JSON of the
@@ -54,18 +75,30 @@ func AddPostController(w http.ResponseWriter, r *http.Request) {
 func UpdatePostController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var post Post
-	decoder.Decode(&post)
+	if err := decoder.Decode(&post); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
 	vars := mux.Vars(r)
 	postID := vars["id"]
+	existing, err := GetPost(bson.ObjectIdHex(postID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 
-	isValid := VerifyAssociationRequest(r, post.Association)
+	isValid := VerifyAssociationRequest(r, existing.Association)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
 		return
 	}
 
-	res := UpdatePost(bson.ObjectIdHex(postID), post)
+	res, err := UpdatePost(existing.ID, post)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	RecordActivity(existing.Association, GetPrincipal(r).ID, "post:update", res.ID)
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -73,17 +106,24 @@ func UpdatePostController(w http.ResponseWriter, r *http.Request) {
 // empty post if the deletation has succeed
 func DeletePostController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	post := GetPost(bson.ObjectIdHex(vars["id"]))
+	post, err := GetPost(bson.ObjectIdHex(vars["id"]))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 
 	isValid := VerifyAssociationRequest(r, post.Association)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
 		return
 	}
 
-	res := DeletePost(post)
-	json.NewEncoder(w).Encode(res)
+	if err := DeletePost(post); err != nil {
+		WriteError(w, err)
+		return
+	}
+	RecordActivity(post.Association, GetPrincipal(r).ID, "post:delete", post.ID)
+	json.NewEncoder(w).Encode(Post{})
 }
 
 // LikePostController will answer a JSON of the
@@ -94,12 +134,16 @@ func LikePostController(w http.ResponseWriter, r *http.Request) {
 	userID := vars["userID"]
 	isValid := VerifyUserRequest(r, bson.ObjectIdHex(userID))
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
+		return
+	}
+	post, user, err := LikePostWithUser(bson.ObjectIdHex(postID), bson.ObjectIdHex(userID))
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
-	post, user := LikePostWithUser(bson.ObjectIdHex(postID), bson.ObjectIdHex(userID))
 	json.NewEncoder(w).Encode(bson.M{"post": post, "user": user})
+	go TriggerNotificationForLike(post, user.ID)
 }
 
 // DislikePostController will answer a JSON of the
@@ -110,11 +154,14 @@ func DislikePostController(w http.ResponseWriter, r *http.Request) {
 	userID := vars["userID"]
 	isValid := VerifyUserRequest(r, bson.ObjectIdHex(userID))
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
+		return
+	}
+	post, user, err := DislikePostWithUser(bson.ObjectIdHex(postID), bson.ObjectIdHex(userID))
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
-	post, user := DislikePostWithUser(bson.ObjectIdHex(postID), bson.ObjectIdHex(userID))
 	json.NewEncoder(w).Encode(bson.M{"post": post, "user": user})
 }
 
@@ -126,15 +173,13 @@ func CommentPostController(w http.ResponseWriter, r *http.Request) {
 	}
 	var comment Comment
 	if err := json.Unmarshal([]byte(string(body)), &comment); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(bson.M{"error": "Mauvais Format"})
+		WriteError(w, ErrBadRequest)
 		return
 	}
 
 	isValid := VerifyUserRequest(r, comment.User)
 	if !isValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+		Forbidden(w)
 		return
 	}
 
@@ -143,12 +188,19 @@ func CommentPostController(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 	postID := vars["id"]
-	res := CommentPost(bson.ObjectIdHex(postID), comment)
+	res, err := CommentPost(bson.ObjectIdHex(postID), comment)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(res)
 
+	user, _ := GetUser(comment.User)
 	for _, tag := range(comment.Tags){
-		go TriggerNotificationForUser(comment.User, bson.ObjectIdHex(tag.User), res.ID , "@" + GetUser(comment.User).Username + " t'a taggé sur \"" + res.Title + "\"", comment)
+		receiver := bson.ObjectIdHex(tag.User)
+		go TriggerNotificationForUser(comment.User, receiver, res.ID , NewLocalizedText("@{user} t'a taggé sur \"{post}\"", "user", user.Username, "post", res.Title), comment)
 	}
+	go TriggerNotificationForComment(res, comment)
 }
 
 // UncommentPostController will answer a JSON of the post
@@ -156,21 +208,28 @@ func UncommentPostController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	postID := vars["id"]
 	commentID := vars["commentID"]
-	comment, err := GetComment(bson.ObjectIdHex(postID), bson.ObjectIdHex(commentID))
+	post, err := GetPost(bson.ObjectIdHex(postID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	comment, err := GetComment(post.ID, bson.ObjectIdHex(commentID))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Inexistant"})
+		WriteError(w, err)
 		return
 	}
-	post := GetPost(bson.ObjectIdHex(postID))
 	isUserValid := VerifyUserRequest(r, comment.User)
 	isAssociationValid := VerifyAssociationRequest(r, post.Association)
-	if !isUserValid && !isAssociationValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+	isModeratorValid := GetPrincipal(r).Can(PermissionModerate)
+	if !isUserValid && !isAssociationValid && !isModeratorValid {
+		Forbidden(w)
+		return
+	}
+	res, err := UncommentPost(post.ID, comment.ID)
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
-	res := UncommentPost(bson.ObjectIdHex(postID), bson.ObjectIdHex(commentID))
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -178,9 +237,11 @@ func ReportCommentController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	postID := vars["id"]
 	commentID := vars["commentID"]
-	token := tauth.Get(r)
-	userID := token.Claims("id").(string)
-	ReportComment(bson.ObjectIdHex(postID), bson.ObjectIdHex(commentID), bson.ObjectIdHex(userID))
+	userID := GetPrincipal(r).ID
+	if err := ReportComment(bson.ObjectIdHex(postID), bson.ObjectIdHex(commentID), userID); err != nil {
+		WriteError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(bson.M{})
 }
 
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"time"
+	// the timezones of the QuietHours do not depend on the ones of the host
+	_ "time/tzdata"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// defaultTimezone is the timezone of the QuietHours which do not set one
+const defaultTimezone = "Europe/Paris"
+
+// NotificationPreferences defines which notifications a user receives.
+// Types disables the notifications of a Type, "tag", "post", "event" or
+// any other, when set to false. Associations does the same for the
+// notifications sent by an association, by the hex of its ID. A type
+// missing from the maps is enabled
+type NotificationPreferences struct {
+	User         bson.ObjectId              `json:"user" bson:"_id"`
+	Types        map[string]bool            `json:"types" bson:"types,omitempty"`
+	Associations map[string]map[string]bool `json:"associations" bson:"associations,omitempty"`
+	QuietHours   *QuietHours                `json:"quiethours,omitempty" bson:"quiethours,omitempty"`
+}
+
+// QuietHours is the time of the day, from Start to End formatted as "22:00"
+// in the Timezone of the user, during which the pushes are deferred
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+// GetNotificationPreferences will return the preferences of the given user,
+// every notification being enabled if the user has not set any
+func GetNotificationPreferences(userID bson.ObjectId) (NotificationPreferences, error) {
+	preferences, err := store.FindNotificationPreferences([]bson.ObjectId{userID})
+	if err != nil || len(preferences) == 0 {
+		return NotificationPreferences{User: userID}, err
+	}
+	return preferences[0], nil
+}
+
+// UpdateNotificationPreferences will replace the preferences of the given user
+func UpdateNotificationPreferences(userID bson.ObjectId, preferences NotificationPreferences) (NotificationPreferences, error) {
+	preferences.User = userID
+	if preferences.QuietHours != nil {
+		if preferences.QuietHours.Timezone == "" {
+			preferences.QuietHours.Timezone = defaultTimezone
+		}
+		if err := preferences.QuietHours.validate(); err != nil {
+			return preferences, err
+		}
+	}
+	if err := store.UpsertNotificationPreferences(preferences); err != nil {
+		return preferences, err
+	}
+	return preferences, nil
+}
+
+// DeleteNotificationPreferencesForUser will delete the preferences of the given user
+func DeleteNotificationPreferencesForUser(userID bson.ObjectId) error {
+	return store.RemoveNotificationPreferences(userID)
+}
+
+// getNotificationPreferences returns the preferences of each
+// of the given users who has set some, by their ID
+func getNotificationPreferences(userIDs []bson.ObjectId) (map[bson.ObjectId]NotificationPreferences, error) {
+	preferences, err := store.FindNotificationPreferences(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	result := map[bson.ObjectId]NotificationPreferences{}
+	for _, preference := range preferences {
+		result[preference.User] = preference
+	}
+	return result, nil
+}
+
+// Allows returns whether the user wants to receive the given notification:
+// neither its type nor its sender, if an association, have been disabled
+func (p NotificationPreferences) Allows(notification Notification) bool {
+	if enabled, ok := p.Types[notification.Type]; ok && !enabled {
+		return false
+	}
+	types, ok := p.Associations[notification.Sender.Hex()]
+	if !ok {
+		return true
+	}
+	enabled, ok := types[notification.Type]
+	return !ok || enabled
+}
+
+// DeferUntil returns the end of the quiet hours the given date is in,
+// or the zero time if the pushes can be sent at this date
+func (p NotificationPreferences) DeferUntil(date time.Time) time.Time {
+	if p.QuietHours == nil {
+		return time.Time{}
+	}
+	return p.QuietHours.until(date)
+}
+
+func (q QuietHours) validate() error {
+	if _, err := time.LoadLocation(q.Timezone); err != nil {
+		return ValidationError("Fuseau horaire invalide")
+	}
+	if _, err := time.Parse("15:04", q.Start); err != nil {
+		return ValidationError("Heure de début invalide")
+	}
+	if _, err := time.Parse("15:04", q.End); err != nil {
+		return ValidationError("Heure de fin invalide")
+	}
+	return nil
+}
+
+// until returns the end of the quiet hours the given date is in, or the zero time
+func (q QuietHours) until(date time.Time) time.Time {
+	location, err := time.LoadLocation(q.Timezone)
+	start, startErr := time.Parse("15:04", q.Start)
+	end, endErr := time.Parse("15:04", q.End)
+	if err != nil || startErr != nil || endErr != nil || q.Start == q.End {
+		return time.Time{}
+	}
+	local := date.In(location)
+	at := func(day time.Time, clock time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, location)
+	}
+	startToday, endToday := at(local, start), at(local, end)
+	if startToday.Before(endToday) {
+		// quiet hours within a day, such as 13:00 to 14:00
+		if !local.Before(startToday) && local.Before(endToday) {
+			return endToday
+		}
+		return time.Time{}
+	}
+	// quiet hours overnight, such as 22:00 to 07:00
+	if local.Before(endToday) {
+		return endToday
+	}
+	if !local.Before(startToday) {
+		return at(local.AddDate(0, 0, 1), end)
+	}
+	return time.Time{}
+}
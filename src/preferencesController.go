@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// GetNotificationPreferencesController will answer a JSON of
+// the notification preferences of the user
+func GetNotificationPreferencesController(w http.ResponseWriter, r *http.Request) {
+	userID := bson.ObjectIdHex(mux.Vars(r)["userID"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	preferences, err := GetNotificationPreferences(userID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(preferences)
+}
+
+// UpdateNotificationPreferencesController will replace the notification
+// preferences of the user by the JSON body, and answer them
+func UpdateNotificationPreferencesController(w http.ResponseWriter, r *http.Request) {
+	userID := bson.ObjectIdHex(mux.Vars(r)["userID"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	var preferences NotificationPreferences
+	if err := json.NewDecoder(r.Body).Decode(&preferences); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	preferences, err := UpdateNotificationPreferences(userID, preferences)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(preferences)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestNotificationPreferencesAllows(t *testing.T) {
+	association := bson.NewObjectId()
+	preferences := NotificationPreferences{
+		Types:        map[string]bool{"event": false, "post": true},
+		Associations: map[string]map[string]bool{association.Hex(): {"post": false}},
+	}
+	for _, test := range []struct {
+		notification Notification
+		allowed      bool
+	}{
+		{Notification{Type: "tag"}, true},
+		{Notification{Type: "event"}, false},
+		{Notification{Type: "post", Sender: bson.NewObjectId()}, true},
+		{Notification{Type: "post", Sender: association}, false},
+		{Notification{Type: "tag", Sender: association}, true},
+	} {
+		if preferences.Allows(test.notification) != test.allowed {
+			t.Errorf("notification %s from %s: expected allowed %v", test.notification.Type, test.notification.Sender.Hex(), test.allowed)
+		}
+	}
+	if !(NotificationPreferences{}).Allows(Notification{Type: "event"}) {
+		t.Error("expected every notification to be allowed by default")
+	}
+}
+
+func TestQuietHoursDeferUntil(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2024, time.March, day, hour, minute, 0, 0, paris)
+	}
+	night := NotificationPreferences{QuietHours: &QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/Paris"}}
+	lunch := NotificationPreferences{QuietHours: &QuietHours{Start: "12:00", End: "14:00", Timezone: "Europe/Paris"}}
+	for _, test := range []struct {
+		preferences NotificationPreferences
+		date        time.Time
+		until       time.Time
+	}{
+		{night, at(4, 23, 30), at(5, 7, 0)},
+		{night, at(5, 6, 59), at(5, 7, 0)},
+		{night, at(5, 7, 0), time.Time{}},
+		{night, at(5, 21, 59), time.Time{}},
+		{lunch, at(5, 12, 0), at(5, 14, 0)},
+		{lunch, at(5, 14, 0), time.Time{}},
+		{NotificationPreferences{}, at(5, 3, 0), time.Time{}},
+	} {
+		if until := test.preferences.DeferUntil(test.date.UTC()); !until.Equal(test.until) {
+			t.Errorf("%v: expected to defer until %v, got %v", test.date, test.until, until)
+		}
+	}
+}
+
+func TestUpdateNotificationPreferences(t *testing.T) {
+	newTestStore()
+	userID := bson.NewObjectId()
+	for _, quiet := range []QuietHours{
+		{Start: "22:00", End: "07:00", Timezone: "Mars/Olympus"},
+		{Start: "22h", End: "07:00"},
+		{Start: "22:00", End: "25:00"},
+	} {
+		if _, err := UpdateNotificationPreferences(userID, NotificationPreferences{QuietHours: &quiet}); err == nil {
+			t.Errorf("expected the quiet hours %+v to be refused", quiet)
+		}
+	}
+	_, err := UpdateNotificationPreferences(bson.NewObjectId(), NotificationPreferences{User: userID, Types: map[string]bool{"post": false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preferences, _ := GetNotificationPreferences(userID); len(preferences.Types) != 0 {
+		t.Fatalf("expected the preferences of another user to be set, got %+v", preferences)
+	}
+	preferences, err := UpdateNotificationPreferences(userID, NotificationPreferences{QuietHours: &QuietHours{Start: "22:00", End: "07:00"}})
+	if err != nil || preferences.QuietHours.Timezone != defaultTimezone {
+		t.Fatalf("expected the default timezone, got %+v %v", preferences, err)
+	}
+}
+
+func TestEnqueueNotificationFollowsPreferences(t *testing.T) {
+	memory := newTestStore()
+	alice, _ := newTestUser(t, "alice")
+	bob, _ := newTestUser(t, "bob")
+	carol, _ := newTestUser(t, "carol")
+	if _, err := UpdateNotificationPreferences(alice.ID, NotificationPreferences{Types: map[string]bool{"post": false}}); err != nil {
+		t.Fatal(err)
+	}
+	// quiet hours all day long but from in two hours to in three hours
+	now := time.Now().UTC()
+	quiet := QuietHours{Start: now.Add(3 * time.Hour).Format("15:04"), End: now.Add(2 * time.Hour).Format("15:04"), Timezone: "UTC"}
+	if _, err := UpdateNotificationPreferences(bob.ID, NotificationPreferences{QuietHours: &quiet}); err != nil {
+		t.Fatal(err)
+	}
+	users := []bson.ObjectId{}
+	for _, user := range []User{alice, bob, carol} {
+		if err := CreateOrUpdateNotificationUser(NotificationUser{UserId: user.ID, Token: user.Username, Os: "iOS"}); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user.ID)
+	}
+	if err := EnqueueNotification(Notification{Sender: bson.NewObjectId(), Content: bson.NewObjectId(), Type: "post"}, users); err != nil {
+		t.Fatal(err)
+	}
+	if len(memory.pushJobs) != 2 {
+		t.Fatalf("expected a job for bob and one for carol, got %+v", memory.pushJobs)
+	}
+	for _, job := range memory.pushJobs {
+		deferred := job.NextAttempt.After(now.Add(time.Hour))
+		if len(job.Recipients) != 1 || deferred != (job.Recipients[0].Token == "bob") {
+			t.Fatalf("expected only the push to bob to be deferred, got %+v", job)
+		}
+	}
+}
+
+func TestNotificationPreferencesRoutes(t *testing.T) {
+	newTestStore()
+	alice, token := newTestUser(t, "alice")
+	bob, _ := newTestUser(t, "bob")
+	url := "/notification/" + alice.ID.Hex() + "/preferences"
+	expectError(t, serveTestRequest("PUT", url, `{"quiethours": {"start": "22h", "end": "07:00"}}`, token), http.StatusBadRequest, "validation")
+	if w := serveTestRequest("PUT", url, `{"types": {"event": false}}`, token); w.Code != http.StatusOK {
+		t.Fatalf("expected the preferences to be set, got %d", w.Code)
+	}
+	if preferences, _ := GetNotificationPreferences(alice.ID); preferences.Types["event"] {
+		t.Fatalf("expected the events to be disabled, got %+v", preferences)
+	}
+	expectError(t, serveTestRequest("GET", "/notification/"+bob.ID.Hex()+"/preferences", "", token), http.StatusForbidden, ErrForbidden.Code)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a client makes too many requests of a kind
+var ErrRateLimited = NewAPIError(http.StatusTooManyRequests, "rate_limited",
+	"too many requests, try again later", "Trop de demandes, réessaie plus tard")
+
+// rateLimiterMaxKeys is the number of keys from which a rateLimiter forgets
+// the ones without recent hit, to not grow forever
+const rateLimiterMaxKeys = 10000
+
+// rateLimiter allows a key, such as an address or an IP, at most
+// limit times per window. It is kept in memory, per instance
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mutex sync.Mutex
+	hits  map[string][]time.Time
+}
+
+// newRateLimiter is the constructor of rateLimiter
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, hits: map[string][]time.Time{}}
+}
+
+// Allow records a hit of the given key at the given time, and tells whether
+// the key had been hit less than limit times during the window before it
+func (l *rateLimiter) Allow(key string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.hits) >= rateLimiterMaxKeys {
+		for other := range l.hits {
+			l.recent(other, now)
+		}
+	}
+	recent := l.recent(key, now)
+	if len(recent) >= l.limit {
+		l.hits[key] = recent
+		return false
+	}
+	l.hits[key] = append(recent, now)
+	return true
+}
+
+// recent returns the hits of the given key during the window before now.
+// The keys without recent hit are forgotten
+func (l *rateLimiter) recent(key string, now time.Time) []time.Time {
+	result := []time.Time{}
+	for _, hit := range l.hits[key] {
+		if now.Sub(hit) < l.window {
+			result = append(result, hit)
+		}
+	}
+	if len(result) == 0 {
+		delete(l.hits, key)
+	}
+	return result
+}
+
+// clientIP returns the IP the given request comes from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(2, time.Hour)
+	now := time.Now()
+	if !limiter.Allow("alice", now) || !limiter.Allow("alice", now.Add(time.Minute)) {
+		t.Fatal("expected the first hits to be allowed")
+	}
+	if limiter.Allow("alice", now.Add(2*time.Minute)) {
+		t.Fatal("expected a hit over the limit to be refused")
+	}
+	if !limiter.Allow("bob", now) {
+		t.Fatal("expected the keys to be limited separately")
+	}
+	if !limiter.Allow("alice", now.Add(time.Hour)) || limiter.Allow("alice", now.Add(time.Hour+time.Second)) {
+		t.Fatal("expected a single hit to leave the window after an hour")
+	}
+	if limiter.Allow("bob", now.Add(2*time.Hour)); len(limiter.hits["bob"]) != 1 {
+		t.Fatalf("expected the former hits to be forgotten, got %v", limiter.hits["bob"])
+	}
+}
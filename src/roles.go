@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/freehaha/token-auth"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Role is a set of Permission given to a principal
+type Role string
+
+// Roles of the principals. A student is any user signed in through the CAS,
+// the association roles are given to the association accounts
+const (
+	RoleSuperAdmin        Role = "superadmin"
+	RoleModerator         Role = "moderator"
+	RoleAssociationAdmin  Role = "associationadmin"
+	RoleAssociationEditor Role = "associationeditor"
+	RoleStudent           Role = "student"
+)
+
+// Permission is what a Route requires from the principal calling it
+type Permission string
+
+// Permissions checked on the routes. PermissionNone marks a public route
+const (
+	PermissionNone               Permission = ""
+	PermissionRead               Permission = "read"
+	PermissionInteract           Permission = "interact"
+	PermissionPublish            Permission = "publish"
+	PermissionEditAssociation    Permission = "association:edit"
+	PermissionModerate           Permission = "moderate"
+	PermissionManageAssociations Permission = "association:manage"
+	PermissionManageUsers        Permission = "user:manage"
+	PermissionBroadcast          Permission = "broadcast"
+)
+
+// rolePermissions defines the permissions granted by each Role
+var rolePermissions = map[Role][]Permission{
+	RoleStudent:           {PermissionRead, PermissionInteract},
+	RoleAssociationEditor: {PermissionRead, PermissionInteract, PermissionPublish},
+	RoleAssociationAdmin:  {PermissionRead, PermissionInteract, PermissionPublish, PermissionEditAssociation},
+	RoleModerator:         {PermissionRead, PermissionInteract, PermissionModerate},
+	RoleSuperAdmin: {PermissionRead, PermissionInteract, PermissionPublish, PermissionEditAssociation,
+		PermissionModerate, PermissionManageAssociations, PermissionManageUsers, PermissionBroadcast},
+}
+
+// IsValidRole tells whether the given role exists
+func IsValidRole(role Role) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// GetUserRoles returns the roles of the given user.
+// Every user is a student, some are given more roles by a super admin
+func GetUserRoles(user User) []Role {
+	roles := []Role{RoleStudent}
+	for _, role := range user.Roles {
+		if role != RoleStudent && IsValidRole(role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// GetAssociationUserRoles returns the roles of the given association account.
+// An account without role is the admin of its association, a master one is also super admin
+func GetAssociationUserRoles(user AssociationUser) []Role {
+	role := user.Role
+	if !IsValidRole(role) {
+		role = RoleAssociationAdmin
+	}
+	roles := []Role{role}
+	if user.Master {
+		roles = append(roles, RoleSuperAdmin)
+	}
+	return roles
+}
+
+// SetUserRoles will replace the extra roles of the user linked to the
+// given id, and log it out so that its next session gets the new ones
+func SetUserRoles(id bson.ObjectId, roles []Role) (User, error) {
+	valid := []Role{}
+	for _, role := range roles {
+		if role != RoleStudent && IsValidRole(role) {
+			valid = append(valid, role)
+		}
+	}
+	if err := store.SetUserRoles(id, valid); err != nil {
+		return User{}, err
+	}
+	if err := RevokeSessionTokensForOwner(id); err != nil {
+		return User{}, err
+	}
+	return GetUser(id)
+}
+
+// Principal is who is calling the API, as authenticated by its SessionToken
+type Principal struct {
+	// ID is the user, or the AssociationUser for association accounts
+	ID bson.ObjectId
+	// Association is the association managed by the principal, if any
+	Association bson.ObjectId
+	Roles       []Role
+}
+
+// Can tells whether one of the roles of the principal grants the given permission
+func (p Principal) Can(permission Permission) bool {
+	for _, role := range p.Roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasRole tells whether the principal has the given role
+func (p Principal) HasRole(role Role) bool {
+	for _, granted := range p.Roles {
+		if granted == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CanManageAssociation tells whether the principal is allowed
+// to act on behalf of the association linked to the given id
+func (p Principal) CanManageAssociation(associationID bson.ObjectId) bool {
+	if p.Can(PermissionManageAssociations) {
+		return true
+	}
+	return len(p.Association) > 0 && p.Association == associationID
+}
+
+// GetPrincipal returns the Principal of an authenticated request
+func GetPrincipal(r *http.Request) Principal {
+	token, ok := tauth.Get(r).(*SessionToken)
+	if !ok || token == nil {
+		return Principal{}
+	}
+	return token.Principal()
+}
+
+// Authorize is the middleware rejecting the requests whose
+// principal does not have the given permission
+func Authorize(permission Permission, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !GetPrincipal(r).Can(permission) {
+			Forbidden(w)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Unauthorized answers a 401 to a request without a valid session token
+func Unauthorized(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, ErrUnauthenticated)
+}
+
+// Forbidden answers a 403 to a request whose principal is not allowed
+func Forbidden(w http.ResponseWriter) {
+	WriteError(w, ErrForbidden)
+}
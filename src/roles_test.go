@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestAssociationUserRoles(t *testing.T) {
+	roles := GetAssociationUserRoles(AssociationUser{Association: bson.NewObjectId()})
+	if len(roles) != 1 || roles[0] != RoleAssociationAdmin {
+		t.Fatalf("expected an account without role to be association admin, got %v", roles)
+	}
+	roles = GetAssociationUserRoles(AssociationUser{Role: RoleAssociationEditor, Master: true})
+	if len(roles) != 2 || roles[0] != RoleAssociationEditor || roles[1] != RoleSuperAdmin {
+		t.Fatalf("expected a master editor to be super admin too, got %v", roles)
+	}
+	association := bson.NewObjectId()
+	editor := Principal{Association: association, Roles: []Role{RoleAssociationEditor}}
+	if !editor.Can(PermissionPublish) || editor.Can(PermissionEditAssociation) {
+		t.Fatal("expected an editor to publish but not to edit the association")
+	}
+	if !editor.CanManageAssociation(association) || editor.CanManageAssociation(bson.NewObjectId()) {
+		t.Fatal("expected an editor to only manage its association")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// newTestUser adds a user of the given username and extra roles, and returns
+// it with the token of a session of it
+func newTestUser(t *testing.T, username string, roles ...Role) (User, string) {
+	t.Helper()
+	user, err := AddUser(User{Username: username})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) > 0 {
+		if user, err = SetUserRoles(user.ID, roles); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err := NewSessionToken(Principal{ID: user.ID, Roles: GetUserRoles(user)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token.Token
+}
+
+// serveTestRequest answers the given request through NewRouter, authenticated
+// with the given session token if any
+func serveTestRequest(method string, url string, body string, token string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, url, reader)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	NewRouter().ServeHTTP(w, r)
+	return w
+}
+
+// expectError fails if the response is not the JSON error of the given status and code
+func expectError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	var apiErr APIError
+	if err := json.NewDecoder(w.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("expected a JSON error, got %d: %v", w.Code, err)
+	}
+	if w.Code != status || apiErr.Code != code {
+		t.Fatalf("expected %d %s, got %d %s", status, code, w.Code, apiErr.Code)
+	}
+}
+
+func TestRouterRequiresSession(t *testing.T) {
+	newTestStore()
+	user, _ := newTestUser(t, "alice")
+	expectError(t, serveTestRequest("GET", "/user/"+user.ID.Hex(), "", ""), http.StatusUnauthorized, ErrUnauthenticated.Code)
+	expectError(t, serveTestRequest("GET", "/user/"+user.ID.Hex(), "", "unknown"), http.StatusUnauthorized, ErrUnauthenticated.Code)
+}
+
+func TestRouterAnswersUser(t *testing.T) {
+	newTestStore()
+	user, token := newTestUser(t, "alice")
+	w := serveTestRequest("GET", "/user/"+user.ID.Hex(), "", token)
+	var result User
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil || w.Code != http.StatusOK || result.ID != user.ID {
+		t.Fatalf("expected the user, got %d %+v %v", w.Code, result, err)
+	}
+}
+
+func TestRouterForbidsMissingPermission(t *testing.T) {
+	newTestStore()
+	_, token := newTestUser(t, "alice")
+	expectError(t, serveTestRequest("POST", "/post", `{"title": "Soirée"}`, token), http.StatusForbidden, ErrForbidden.Code)
+	expectError(t, serveTestRequest("GET", "/user", "", token), http.StatusForbidden, ErrForbidden.Code)
+	_, admin := newTestUser(t, "bob", RoleSuperAdmin)
+	if w := serveTestRequest("GET", "/user", "", admin); w.Code != http.StatusOK {
+		t.Fatalf("expected a super admin to list the users, got %d", w.Code)
+	}
+}
+
+func TestRouterForbidsOtherUser(t *testing.T) {
+	newTestStore()
+	alice, _ := newTestUser(t, "alice")
+	_, token := newTestUser(t, "bob")
+	expectError(t, serveTestRequest("PUT", "/user/"+alice.ID.Hex(), `{"name": "Bob"}`, token), http.StatusForbidden, ErrForbidden.Code)
+	if user, _ := GetUser(alice.ID); user.Name != "" {
+		t.Fatalf("expected alice to be unchanged, got %+v", user)
+	}
+}
+
+func TestSetUserRolesLogsOut(t *testing.T) {
+	newTestStore()
+	user, token := newTestUser(t, "alice")
+	_, admin := newTestUser(t, "bob", RoleSuperAdmin)
+	w := serveTestRequest("PUT", "/user/"+user.ID.Hex()+"/roles", `{"roles": ["unknown"]}`, admin)
+	expectError(t, w, http.StatusBadRequest, "validation")
+	w = serveTestRequest("PUT", "/user/"+user.ID.Hex()+"/roles", `{"roles": ["moderator"]}`, admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the roles to be set, got %d", w.Code)
+	}
+	user, _ = GetUser(user.ID)
+	if roles := GetUserRoles(user); len(roles) != 2 || roles[1] != RoleModerator {
+		t.Fatalf("expected alice to be a moderator, got %v", roles)
+	}
+	expectError(t, serveTestRequest("GET", "/user/"+user.ID.Hex(), "", token), http.StatusUnauthorized, ErrUnauthenticated.Code)
+}
+
+func TestRouterAnswersNotFound(t *testing.T) {
+	newTestStore()
+	_, token := newTestUser(t, "alice")
+	w := serveTestRequest("GET", "/user/"+bson.NewObjectId().Hex(), "", token)
+	expectError(t, w, http.StatusNotFound, ErrContentNotFound.Code)
+}
+
+func TestRouterRejectsMalformedParams(t *testing.T) {
+	newTestStore()
+	_, token := newTestUser(t, "alice")
+	expectError(t, serveTestRequest("GET", "/user/notanid", "", token), http.StatusBadRequest, "invalid_param")
+	expectError(t, serveTestRequest("GET", "/post/123", "", token), http.StatusBadRequest, "invalid_param")
+}
+
+func TestRouterRejectsMalformedBody(t *testing.T) {
+	newTestStore()
+	user, token := newTestUser(t, "alice")
+	w := serveTestRequest("PUT", "/user/"+user.ID.Hex(), `{"name": `, token)
+	expectError(t, w, http.StatusBadRequest, ErrBadRequest.Code)
+}
+
+func TestRouterPaginates(t *testing.T) {
+	newTestStore()
+	_, token := newTestUser(t, "admin", RoleSuperAdmin)
+	for _, username := range []string{"bob", "carol", "dave", "erin"} {
+		newTestUser(t, username)
+	}
+	seen := map[bson.ObjectId]bool{}
+	url := "/user?limit=2"
+	for pages := 0; url != ""; pages++ {
+		if pages == 3 {
+			t.Fatal("expected 3 pages at most")
+		}
+		w := serveTestRequest("GET", url, "", token)
+		var users Users
+		if err := json.NewDecoder(w.Body).Decode(&users); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("expected a page of users, got %d: %v", w.Code, err)
+		}
+		for _, user := range users {
+			if seen[user.ID] {
+				t.Fatalf("user %s answered twice", user.Username)
+			}
+			seen[user.ID] = true
+		}
+		url = ""
+		if link := w.Header().Get("Link"); link != "" {
+			url = link[strings.Index(link, "<")+1 : strings.Index(link, ">")]
+		}
+	}
+	if len(seen) != 5 {
+		t.Fatalf("expected 5 users, got %d", len(seen))
+	}
+	expectError(t, serveTestRequest("GET", "/user?limit=-1", "", token), http.StatusBadRequest, "invalid_page")
+}
+
+func TestRouterListsAssociationsWhole(t *testing.T) {
+	newTestStore()
+	_, token := newTestUser(t, "alice")
+	for _, name := range []string{"BDE", "BDA", "BDS"} {
+		newTestAssociation(t, name)
+	}
+	w := serveTestRequest("GET", "/association", "", token)
+	var associations Associations
+	if err := json.NewDecoder(w.Body).Decode(&associations); err != nil || len(associations) != 3 || w.Header().Get("Link") != "" {
+		t.Fatalf("expected the 3 associations without next link, got %d %v", len(associations), err)
+	}
+	w = serveTestRequest("GET", "/association?limit=2", "", token)
+	if err := json.NewDecoder(w.Body).Decode(&associations); err != nil || len(associations) != 2 || w.Header().Get("Link") == "" {
+		t.Fatalf("expected a page of 2 associations with a next link, got %d %v", len(associations), err)
+	}
+}
+
+func TestRouterRejectsMalformedTag(t *testing.T) {
+	memory := newTestStore()
+	user, token := newTestUser(t, "alice")
+	post := Post{ID: bson.NewObjectId(), Title: "News"}
+	if err := memory.InsertPost(post); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"user": "` + user.ID.Hex() + `", "content": "@bob", "tags": [{"user": "bob", "name": "bob"}]}`
+	w := serveTestRequest("POST", "/post/"+post.ID.Hex()+"/comment", body, token)
+	expectError(t, w, http.StatusBadRequest, "validation")
+	if saved, _ := memory.FindPost(post.ID); len(saved.Comments) != 0 {
+		t.Fatalf("expected the comment not to be saved, got %d comments", len(saved.Comments))
+	}
+}
+
+func TestRouterKeepsLocale(t *testing.T) {
+	newTestStore()
+	user, token := newTestUser(t, "alice")
+	url := "/user/" + user.ID.Hex()
+	if w := serveTestRequest("PUT", url, `{"name": "Alice", "locale": "EN"}`, token); w.Code != http.StatusOK {
+		t.Fatalf("expected the locale to be set, got %d", w.Code)
+	}
+	if w := serveTestRequest("PUT", url, `{"name": "Alice Martin"}`, token); w.Code != http.StatusOK {
+		t.Fatalf("expected the user to be updated, got %d", w.Code)
+	}
+	if result, _ := GetUser(user.ID); result.Name != "Alice Martin" || result.Locale != "en" {
+		t.Fatalf("expected the locale to be kept, got %+v", result)
+	}
+	expectError(t, serveTestRequest("PUT", url, `{"locale": "de"}`, token), http.StatusBadRequest, "validation")
+}
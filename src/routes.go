@@ -4,15 +4,19 @@ import (
 	"net/http"
 
 	"github.com/freehaha/token-auth"
-	"github.com/freehaha/token-auth/memory"
 	"github.com/gorilla/mux"
 )
 
-// Route type is used to define a route of the API
+// Route type is used to define a route of the API.
+// Params declares the type of each parameter of the Pattern,
+// Permission is the one the principal calling it must have,
+// PermissionNone for the routes reachable without session token
 type Route struct {
 	Name        string
 	Method      string
 	Pattern     string
+	Params      Params
+	Permission  Permission
 	HandlerFunc http.HandlerFunc
 }
 
@@ -23,115 +27,123 @@ type Routes []Route
 // It will create every routes from the routes variable just above
 func NewRouter() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
-	for _, route := range publicRoutes {
+	for _, route := range routes {
+		checkParams(route)
+		handlerFunc := ValidateParams(route.Params, route.HandlerFunc)
+		handler := http.Handler(handlerFunc)
+		if route.Permission != PermissionNone {
+			handler = tokenAuth.HandleFunc(Authorize(route.Permission, handlerFunc))
+		}
 		router.
 			Methods(route.Method).
 			Path(route.Pattern).
 			Name(route.Name).
-			Handler(route.HandlerFunc)
+			Handler(WithLocale(Recover(handler)))
 	}
-
-	for _, route := range associationRoutes {
-		router.
-			Methods(route.Method).
-			Path(route.Pattern).
-			Name(route.Name).
-			Handler(tokenAuthAssociationUser.HandleFunc(route.HandlerFunc))
-	}
-
-	for _, route := range superRoutes {
-		router.
-			Methods(route.Method).
-			Path(route.Pattern).
-			Name(route.Name).
-			Handler(tokenAuthSuperUser.HandleFunc(route.HandlerFunc))
-	}
-
-	for _, route := range userRoutes {
-		router.
-			Methods(route.Method).
-			Path(route.Pattern).
-			Name(route.Name).
-			Handler(tokenAuthUser.HandleFunc(route.HandlerFunc))
-	}
-
 	return router
 }
 
-var tokenAuthAssociationUser = tauth.NewTokenAuth(nil, nil, memStoreAssociationUser, nil)
-var tokenAuthSuperUser = tauth.NewTokenAuth(nil, nil, memStoreSuperUser, nil)
-var tokenAuthUser = tauth.NewTokenAuth(nil, nil, memStoreUser, nil)
-
-var memStoreAssociationUser = memstore.New("associationUser")
-var memStoreSuperUser = memstore.New("superUser")
-var memStoreUser = memstore.New("user")
-
-var publicRoutes = Routes{
-	Route{"Index", "GET", "/", Index},
-	Route{"Credit", "GET", "/credit", Credit},
-	Route{"Legal", "GET", "/legal", Legal},
-	Route{"LogAssociation", "POST", "/login/association", LogAssociationController},
-	Route{"LogUser", "POST", "/login/user", LogUserController},
-	Route{"SignUser", "POST", "/signin/user/{ticket}", SignInUserController},
-}
-
-var superRoutes = Routes{
-	Route{"GetUsers", "GET", "/user", GetAllUserController},
-	Route{"AddAssociation", "POST", "/association", AddAssociationController},
-	Route{"DeleteAssociation", "DELETE", "/association/{id}", DeleteAssociationController},
-	Route{"CreateUserForAssociation", "POST", "/association/{id}/user", CreateUserForAssociationController},
-	Route{"GetMyAssociations", "GET", "/association/{id}/myassociations", GetMyAssociationController},
-}
+var tokenAuth = tauth.NewTokenAuth(nil, Unauthorized, &PersistentTokenStore{}, nil)
+
+var routes = Routes{
+	Route{"Index", "GET", "/", nil, PermissionNone, Index},
+	Route{"Credit", "GET", "/credit", nil, PermissionNone, Credit},
+	Route{"Legal", "GET", "/legal", nil, PermissionNone, Legal},
+	Route{"LogAssociation", "POST", "/login/association", nil, PermissionNone, LogAssociationController},
+	Route{"ForgotAssociationPassword", "POST", "/login/association/forgot", nil, PermissionNone, ForgotAssociationPasswordController},
+	Route{"ResetAssociationPassword", "POST", "/login/association/reset", nil, PermissionNone, ResetAssociationPasswordController},
+	Route{"AcceptEmailInvitation", "POST", "/login/association/invitation", nil, PermissionNone, AcceptEmailInvitationController},
+	Route{"DeclineEmailInvitation", "POST", "/login/association/invitation/decline", nil, PermissionNone, DeclineEmailInvitationController},
+	Route{"LogUser", "POST", "/login/user", nil, PermissionNone, LogUserController},
+	Route{"SignUser", "POST", "/signin/user/{ticket}", Params{"ticket": ParamTicket}, PermissionNone, SignInUserController},
+	Route{"UnsubscribeFromDigestWithToken", "POST", "/digest/unsubscribe", nil, PermissionNone, UnsubscribeFromDigestWithTokenController},
+	Route{"OneClickUnsubscribeFromDigest", "POST", "/digest/unsubscribe/oneclick", nil, PermissionNone, OneClickUnsubscribeFromDigestController},
 
-var associationRoutes = Routes{
 	//ASSOCIATIONS
-	Route{"UpdateAssociation", "PUT", "/association/{id}", UpdateAssociationController},
+	Route{"GetAssociation", "GET", "/association", nil, PermissionRead, GetAllAssociationsController},
+	Route{"GetAssociation", "GET", "/association/{id}", Params{"id": ParamObjectID}, PermissionRead, GetAssociationController},
+	Route{"AddAssociation", "POST", "/association", nil, PermissionManageAssociations, AddAssociationController},
+	Route{"UpdateAssociation", "PUT", "/association/{id}", Params{"id": ParamObjectID}, PermissionEditAssociation, UpdateAssociationController},
+	Route{"DeleteAssociation", "DELETE", "/association/{id}", Params{"id": ParamObjectID}, PermissionManageAssociations, DeleteAssociationController},
+	Route{"CreateUserForAssociation", "POST", "/association/{id}/user", Params{"id": ParamObjectID}, PermissionManageAssociations, CreateUserForAssociationController},
+	Route{"GetMyAssociations", "GET", "/association/{id}/myassociations", Params{"id": ParamObjectID}, PermissionManageAssociations, GetMyAssociationController},
+	Route{"ChangeAssociationPassword", "PUT", "/association/{id}/password", Params{"id": ParamObjectID}, PermissionPublish, ChangeAssociationPasswordController},
+	Route{"FollowAssociation", "POST", "/association/{id}/follow/{userID}", Params{"id": ParamObjectID, "userID": ParamObjectID}, PermissionInteract, FollowAssociationController},
+	Route{"UnfollowAssociation", "DELETE", "/association/{id}/follow/{userID}", Params{"id": ParamObjectID, "userID": ParamObjectID}, PermissionInteract, UnfollowAssociationController},
+	Route{"ForceResetAssociationPassword", "POST", "/association/{id}/password/reset", Params{"id": ParamObjectID}, PermissionManageAssociations, ForceResetAssociationPasswordController},
+
+	//MEMBERS
+	Route{"GetMembers", "GET", "/association/{id}/member", Params{"id": ParamObjectID}, PermissionPublish, GetMembersController},
+	Route{"InviteMember", "POST", "/association/{id}/member", Params{"id": ParamObjectID}, PermissionEditAssociation, InviteMemberController},
+	Route{"RemoveMember", "DELETE", "/association/{id}/member/{memberID}", Params{"id": ParamObjectID, "memberID": ParamObjectID}, PermissionEditAssociation, RemoveMemberController},
+	Route{"GetActivities", "GET", "/association/{id}/activity", Params{"id": ParamObjectID}, PermissionPublish, GetActivitiesController},
+	Route{"LogMember", "POST", "/association/{id}/login", Params{"id": ParamObjectID}, PermissionRead, LogMemberController},
+	Route{"GetMemberships", "GET", "/user/{id}/association", Params{"id": ParamObjectID}, PermissionRead, GetMembershipsController},
+	Route{"AcceptInvitation", "POST", "/invitation/{id}", Params{"id": ParamObjectID}, PermissionRead, AcceptInvitationController},
+	Route{"DeclineInvitation", "DELETE", "/invitation/{id}", Params{"id": ParamObjectID}, PermissionRead, DeclineInvitationController},
 
 	//EVENTS
-	Route{"AddEvent", "POST", "/event", AddEventController},
-	Route{"UpdateEvent", "PUT", "/event/{id}", UpdateEventController},
-	Route{"DeleteEvent", "DELETE", "/event/{id}", DeleteEventController},
+	Route{"GetFutureEvents", "GET", "/event", nil, PermissionRead, GetFutureEventsController},
+	Route{"GetEvent", "GET", "/event/{id}", Params{"id": ParamObjectID}, PermissionRead, GetEventController},
+	Route{"AddEvent", "POST", "/event", nil, PermissionPublish, AddEventController},
+	Route{"UpdateEvent", "PUT", "/event/{id}", Params{"id": ParamObjectID}, PermissionPublish, UpdateEventController},
+	Route{"DeleteEvent", "DELETE", "/event/{id}", Params{"id": ParamObjectID}, PermissionPublish, DeleteEventController},
+	Route{"AddParticipant", "POST", "/event/{id}/participant/{userID}", Params{"id": ParamObjectID, "userID": ParamObjectID}, PermissionInteract, AddParticipantController},
+	Route{"RemoveParticipant", "DELETE", "/event/{id}/participant/{userID}", Params{"id": ParamObjectID, "userID": ParamObjectID}, PermissionInteract, RemoveParticipantController},
 
 	//POSTS
-	Route{"AddPost", "POST", "/post", AddPostController},
-	Route{"UpdatePost", "PUT", "/post/{id}", UpdatePostController},
-	Route{"DeletePost", "DELETE", "/post/{id}", DeletePostController},
+	Route{"GetPost", "GET", "/post/{id}", Params{"id": ParamObjectID}, PermissionRead, GetPostController},
+	Route{"GetLastestPost", "GET", "/post", nil, PermissionRead, GetLastestPostsController},
+	Route{"AddPost", "POST", "/post", nil, PermissionPublish, AddPostController},
+	Route{"UpdatePost", "PUT", "/post/{id}", Params{"id": ParamObjectID}, PermissionPublish, UpdatePostController},
+	Route{"DeletePost", "DELETE", "/post/{id}", Params{"id": ParamObjectID}, PermissionPublish, DeletePostController},
+	Route{"LikePost", "POST", "/post/{id}/like/{userID}", Params{"id": ParamObjectID, "userID": ParamObjectID}, PermissionInteract, LikePostController},
+	Route{"DislikePost", "DELETE", "/post/{id}/like/{userID}", Params{"id": ParamObjectID, "userID": ParamObjectID}, PermissionInteract, DislikePostController},
+	Route{"CommentPost", "POST", "/post/{id}/comment", Params{"id": ParamObjectID}, PermissionInteract, CommentPostController},
+	Route{"UncommentPost", "DELETE", "/post/{id}/comment/{commentID}", Params{"id": ParamObjectID, "commentID": ParamObjectID}, PermissionInteract, UncommentPostController},
+	Route{"ReportComment", "PUT", "/report/{id}/comment/{commentID}", Params{"id": ParamObjectID, "commentID": ParamObjectID}, PermissionInteract, ReportCommentController},
+
+	//FEED
+	Route{"GetFeed", "GET", "/feed", nil, PermissionRead, GetFeedController},
+
+	//USER
+	Route{"GetUsers", "GET", "/user", nil, PermissionManageUsers, GetAllUserController},
+	Route{"GetUser", "GET", "/user/{id}", Params{"id": ParamObjectID}, PermissionRead, GetUserController},
+	Route{"UpdateUser", "PUT", "/user/{id}", Params{"id": ParamObjectID}, PermissionInteract, UpdateUserController},
+	Route{"DeleteUser", "DELETE", "/user/{id}", Params{"id": ParamObjectID}, PermissionInteract, DeleteUserController},
+	Route{"SetUserRoles", "PUT", "/user/{id}/roles", Params{"id": ParamObjectID}, PermissionManageUsers, SetUserRolesController},
+	Route{"SearchUser", "GET", "/search/users/{username}", Params{"username": ParamUsername}, PermissionRead, SearchUserController},
+	Route{"ReportUser", "PUT", "/report/user/{id}", Params{"id": ParamObjectID}, PermissionInteract, ReportUserController},
+	Route{"GetDevices", "GET", "/user/{id}/device", Params{"id": ParamObjectID}, PermissionInteract, GetDevicesController},
+	Route{"RevokeDevice", "DELETE", "/user/{id}/device/{deviceID}", Params{"id": ParamObjectID, "deviceID": ParamObjectID}, PermissionInteract, RevokeDeviceController},
+	Route{"GetDigest", "GET", "/user/{id}/digest", Params{"id": ParamObjectID}, PermissionInteract, GetDigestController},
+	Route{"SubscribeToDigest", "POST", "/user/{id}/digest", Params{"id": ParamObjectID}, PermissionInteract, SubscribeToDigestController},
+	Route{"UnsubscribeFromDigest", "DELETE", "/user/{id}/digest", Params{"id": ParamObjectID}, PermissionInteract, UnsubscribeFromDigestController},
+	Route{"RevokeAllDevices", "DELETE", "/user/{id}/device", Params{"id": ParamObjectID}, PermissionInteract, RevokeAllDevicesController},
+	Route{"Logout", "POST", "/logout", nil, PermissionRead, LogoutController},
 
 	//Image
 	//DEPENDENCIES : https://github.com/fengsp/color-thief-py
-	Route{"UploadNewImage", "POST", "/image", UploadNewImageController},
-	Route{"UploadImage", "POST", "/image/{name}", UploadImageController},
-}
-
-var userRoutes = Routes{
-	//ASSOCIATIONS
-	Route{"GetAssociation", "GET", "/association", GetAllAssociationsController},
-	Route{"GetAssociation", "GET", "/association/{id}", GetAssociationController},
-
-	//EVENTS
-	Route{"GetFutureEvents", "GET", "/event", GetFutureEventsController},
-	Route{"GetEvent", "GET", "/event/{id}", GetEventController},
-	Route{"AddParticipant", "POST", "/event/{id}/participant/{userID}", AddParticipantController},
-	Route{"RemoveParticipant", "DELETE", "/event/{id}/participant/{userID}", RemoveParticipantController},
-
-	//POSTS
-	Route{"GetPost", "GET", "/post/{id}", GetPostController},
-	Route{"GetLastestPost", "GET", "/post", GetLastestPostsController},
-	Route{"LikePost", "POST", "/post/{id}/like/{userID}", LikePostController},
-	Route{"DislikePost", "DELETE", "/post/{id}/like/{userID}", DislikePostController},
-	Route{"CommentPost", "POST", "/post/{id}/comment", CommentPostController},
-	Route{"UncommentPost", "DELETE", "/post/{id}/comment/{commentID}", UncommentPostController},
-	Route{"ReportComment", "PUT", "/report/{id}/comment/{commentID}", ReportCommentController},
-
-	//USER
-	Route{"GetUser", "GET", "/user/{id}", GetUserController},
-	Route{"UpdateUser", "PUT", "/user/{id}", UpdateUserController},
-	Route{"DeleteUser", "DELETE", "/user/{id}", DeleteUserController},
-	Route{"SearchUser", "GET", "/search/users/{username}", SearchUserController},
-	Route{"ReportUser", "PUT", "/report/user/{id}", ReportUserController},
+	Route{"UploadNewImage", "POST", "/image", nil, PermissionPublish, UploadNewImageController},
+	Route{"UploadImage", "POST", "/image/{name}", Params{"name": ParamImageName}, PermissionPublish, UploadImageController},
 
 	//NOTIFICATION
-	Route{"Notification", "POST", "/notification", UpdateNotificationUserController},
-	Route{"Notification", "GET", "/notification/{userID}", GetNotificationController},
-	Route{"Notification", "DELETE", "/notification/{userID}/{id}", DeleteNotificationController},
+	Route{"Notification", "POST", "/notification", nil, PermissionInteract, UpdateNotificationUserController},
+	Route{"Notification", "GET", "/notification/{userID}", Params{"userID": ParamObjectID}, PermissionInteract, GetNotificationController},
+	Route{"NotificationStream", "GET", "/notification/{userID}/stream", Params{"userID": ParamObjectID}, PermissionInteract, StreamController},
+	Route{"WatchContents", "PUT", "/notification/{userID}/stream/{streamID}", Params{"userID": ParamObjectID, "streamID": ParamObjectID}, PermissionInteract, WatchController},
+	Route{"GetNotificationPreferences", "GET", "/notification/{userID}/preferences", Params{"userID": ParamObjectID}, PermissionInteract, GetNotificationPreferencesController},
+	Route{"UpdateNotificationPreferences", "PUT", "/notification/{userID}/preferences", Params{"userID": ParamObjectID}, PermissionInteract, UpdateNotificationPreferencesController},
+	Route{"Notification", "DELETE", "/notification/{userID}/{id}", Params{"userID": ParamObjectID, "id": ParamObjectID}, PermissionInteract, DeleteNotificationController},
+	Route{"DeleteAllNotifications", "DELETE", "/notification/{userID}", Params{"userID": ParamObjectID}, PermissionInteract, DeleteAllNotificationsController},
+	Route{"UnreadCount", "GET", "/notification/{userID}/unread", Params{"userID": ParamObjectID}, PermissionInteract, GetUnreadCountController},
+	Route{"ReadAllNotifications", "PUT", "/notification/{userID}/read", Params{"userID": ParamObjectID}, PermissionInteract, ReadAllNotificationsController},
+	Route{"ReadNotification", "PUT", "/notification/{userID}/{id}/read", Params{"userID": ParamObjectID, "id": ParamObjectID}, PermissionInteract, ReadNotificationController},
+
+	//BROADCASTS
+	Route{"GetBroadcasts", "GET", "/broadcast", nil, PermissionPublish, GetBroadcastsController},
+	Route{"AddBroadcast", "POST", "/broadcast", nil, PermissionPublish, AddBroadcastController},
+	Route{"PreviewBroadcast", "POST", "/broadcast/preview", nil, PermissionPublish, PreviewBroadcastController},
+	Route{"GetBroadcast", "GET", "/broadcast/{id}", Params{"id": ParamObjectID}, PermissionPublish, GetBroadcastController},
+	Route{"CancelBroadcast", "DELETE", "/broadcast/{id}", Params{"id": ParamObjectID}, PermissionPublish, CancelBroadcastController},
 }
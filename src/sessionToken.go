@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/freehaha/token-auth"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// sessionDuration is how long a SessionToken stays valid.
+// It can be changed with the "sessionduration" key of the config file
+var sessionDuration = 7 * 24 * time.Hour
+
+// SessionToken defines how to model a session token given after a login.
+// The JSON keys are the ones the apps already read from the former memory store
+type SessionToken struct {
+	ID    bson.ObjectId `bson:"_id,omitempty" json:"-"`
+	Token string        `json:"Token"`
+	Owner string        `json:"Id"`
+	// Credentials is the id of the Credentials of the device that logged in, if any
+	Credentials bson.ObjectId `json:"-" bson:"credentials,omitempty"`
+	// Association is the association managed with the token, if any
+	Association bson.ObjectId `json:"association,omitempty" bson:"association,omitempty"`
+	Roles       []Role        `json:"roles"`
+	CreatedAt   time.Time     `json:"createdat"`
+	ExpireAt    time.Time     `json:"ExpireAt"`
+}
+
+// IsExpired tells whether the token can still be used
+func (t *SessionToken) IsExpired() bool {
+	return time.Now().After(t.ExpireAt)
+}
+
+func (t *SessionToken) String() string {
+	return t.Token
+}
+
+// Claims returns the claim for the given key. The only claim
+// is "id", the hex id of the user or association owning the token
+func (t *SessionToken) Claims(key string) interface{} {
+	if key == "id" {
+		return t.Owner
+	}
+	return nil
+}
+
+// Principal returns the Principal authenticated by the token
+func (t *SessionToken) Principal() Principal {
+	return Principal{ID: bson.ObjectIdHex(t.Owner), Association: t.Association, Roles: t.Roles}
+}
+
+// PersistentTokenStore is the tauth.TokenStore checking the tokens against the store
+type PersistentTokenStore struct{}
+
+// CheckToken returns the SessionToken matching the given string
+// if it exists, has not expired and grants at least one role
+func (s *PersistentTokenStore) CheckToken(token string) (tauth.Token, error) {
+	result, err := store.FindSessionToken(token)
+	if err != nil {
+		return nil, errors.New("Invalid token")
+	}
+	if result.IsExpired() {
+		return nil, errors.New("Token expired")
+	}
+	if len(result.Roles) == 0 || !bson.IsObjectIdHex(result.Owner) {
+		return nil, errors.New("Invalid token")
+	}
+	return &result, nil
+}
+
+// NewSessionToken will create and persist a new SessionToken for the given
+// principal. credentials can be empty for associations
+func NewSessionToken(principal Principal, credentials bson.ObjectId) (*SessionToken, error) {
+	now := time.Now()
+	token := SessionToken{
+		ID:          bson.NewObjectId(),
+		Token:       generateSessionToken(),
+		Owner:       principal.ID.Hex(),
+		Credentials: credentials,
+		Association: principal.Association,
+		Roles:       principal.Roles,
+		CreatedAt:   now,
+		ExpireAt:    now.Add(sessionDuration),
+	}
+	if err := store.InsertSessionToken(token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeSessionToken will delete the given token, logging it out
+func RevokeSessionToken(token string) error {
+	return store.RemoveSessionToken(token)
+}
+
+// RevokeSessionTokensForCredentials will delete every token
+// opened with the given Credentials
+func RevokeSessionTokensForCredentials(credentials bson.ObjectId) error {
+	if credentials == "" {
+		return nil
+	}
+	return store.RemoveSessionTokensForCredentials(credentials)
+}
+
+// RevokeSessionTokensForOwner will delete every token of the given owner
+func RevokeSessionTokensForOwner(owner bson.ObjectId) error {
+	return store.RemoveSessionTokensForOwner(owner.Hex())
+}
+
+// RevokeSessionTokensForAssociation will delete every token
+// managing the given association, whoever owns it
+func RevokeSessionTokensForAssociation(association bson.ObjectId) error {
+	return store.RemoveSessionTokensForAssociation(association)
+}
+
+// CleanSessionTokens will delete the expired tokens every interval.
+// It never returns and is meant to run in its own goroutine
+func CleanSessionTokens(interval time.Duration) {
+	for range time.Tick(interval) {
+		removed, err := store.RemoveSessionTokensExpiredBefore(time.Now())
+		if err != nil {
+			log.Println("[error] Failed to clean session tokens:", err)
+		} else if removed > 0 {
+			log.Println("Removed", removed, "expired session tokens")
+		}
+	}
+}
+
+func generateSessionToken() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// testTokenStore is the token store of the router
+var testTokenStore = &PersistentTokenStore{}
+
+// newTestSession returns a new session token of a student of the given id
+func newTestSession(t *testing.T, owner bson.ObjectId) *SessionToken {
+	t.Helper()
+	token, err := NewSessionToken(Principal{ID: owner, Roles: []Role{RoleStudent}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestSessionTokenPrincipal(t *testing.T) {
+	newTestStore()
+	owner := bson.NewObjectId()
+	token := newTestSession(t, owner)
+	result, err := testTokenStore.CheckToken(token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal := result.(*SessionToken).Principal()
+	if principal.ID != owner || !principal.Can(PermissionRead) || principal.Can(PermissionPublish) {
+		t.Fatalf("expected the principal of a student, got %+v", principal)
+	}
+	if _, err := testTokenStore.CheckToken("unknown"); err == nil {
+		t.Fatal("expected an unknown token to be refused")
+	}
+}
+
+func TestSessionTokenExpires(t *testing.T) {
+	memory := newTestStore()
+	expired := SessionToken{Token: "expired", Owner: bson.NewObjectId().Hex(), Roles: []Role{RoleStudent}, ExpireAt: time.Now().Add(-time.Minute)}
+	if err := memory.InsertSessionToken(expired); err != nil {
+		t.Fatal(err)
+	}
+	valid := newTestSession(t, bson.NewObjectId())
+	if _, err := testTokenStore.CheckToken(expired.Token); err == nil {
+		t.Fatal("expected an expired token to be refused")
+	}
+	if removed, err := memory.RemoveSessionTokensExpiredBefore(time.Now()); err != nil || removed != 1 {
+		t.Fatalf("expected the expired token to be cleaned, got %d %v", removed, err)
+	}
+	if _, err := testTokenStore.CheckToken(valid.Token); err != nil {
+		t.Fatalf("expected the valid token to be kept, got %v", err)
+	}
+}
+
+func TestSessionTokenRevocation(t *testing.T) {
+	newTestStore()
+	owner := bson.NewObjectId()
+	first := newTestSession(t, owner)
+	second := newTestSession(t, owner)
+	other := newTestSession(t, bson.NewObjectId())
+
+	if err := RevokeSessionToken(first.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTokenStore.CheckToken(first.Token); err == nil {
+		t.Fatal("expected the revoked token to be refused")
+	}
+	if _, err := testTokenStore.CheckToken(second.Token); err != nil {
+		t.Fatalf("expected the other session to be kept, got %v", err)
+	}
+	if err := RevokeSessionTokensForOwner(owner); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTokenStore.CheckToken(second.Token); err == nil {
+		t.Fatal("expected every token of the owner to be revoked")
+	}
+	if _, err := testTokenStore.CheckToken(other.Token); err != nil {
+		t.Fatalf("expected the token of another owner to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrNotFound is returned by a Store when the requested document does not exist
+var ErrNotFound = errors.New("not found")
+
+// store is the Store used by every model function.
+// It is set in main, and can be swapped for a MemoryStore in tests
+var store Store
+
+// Store defines every persistence operation needed by the models.
+// The lists taking a Page answer the items of this page only,
+// or the whole list for an empty Page
+type Store interface {
+	UserStore
+	AssociationStore
+	EventStore
+	PostStore
+	CredentialsStore
+	NotificationStore
+	SessionTokenStore
+	PushJobStore
+	DigestStore
+	BroadcastStore
+	PasswordResetStore
+	AssociationMemberStore
+}
+
+// UserStore defines the persistence of User
+type UserStore interface {
+	InsertUser(user User) error
+	FindUser(id bson.ObjectId) (User, error)
+	FindUserByUsername(username string) (User, error)
+	FindUsers(page Page) (Users, error)
+	FindUsersByPromotions(promotions []string) ([]bson.ObjectId, error)
+	FindUserIDs() ([]bson.ObjectId, error)
+	SearchUsers(query string, page Page) (Users, error)
+	UpdateUser(id bson.ObjectId, user User) error
+	SetUserRoles(id bson.ObjectId, roles []Role) error
+	RemoveUser(id bson.ObjectId) error
+	AddUserLike(id bson.ObjectId, postID bson.ObjectId) error
+	RemoveUserLike(id bson.ObjectId, postID bson.ObjectId) error
+	AddUserEvent(id bson.ObjectId, eventID bson.ObjectId) error
+	RemoveUserEvent(id bson.ObjectId, eventID bson.ObjectId) error
+	// AddUserFollowing adds the association to the ones followed by the user. It
+	// returns ErrNotFound if the user does not exist or already follows it
+	AddUserFollowing(id bson.ObjectId, associationID bson.ObjectId) error
+	// RemoveUserFollowing removes the association from the ones followed by the
+	// user. It returns ErrNotFound if the user does not exist or does not follow it
+	RemoveUserFollowing(id bson.ObjectId, associationID bson.ObjectId) error
+	FindFollowers(associationID bson.ObjectId) ([]bson.ObjectId, error)
+	RemoveFollowingForAssociation(associationID bson.ObjectId) error
+}
+
+// AssociationStore defines the persistence of Association and AssociationUser
+type AssociationStore interface {
+	InsertAssociation(association Association) error
+	FindAssociation(id bson.ObjectId) (Association, error)
+	FindAssociations(page Page) (Associations, error)
+	UpdateAssociation(id bson.ObjectId, association Association) error
+	RemoveAssociation(id bson.ObjectId) error
+	AddAssociationEvent(id bson.ObjectId, eventID bson.ObjectId) error
+	RemoveAssociationEvent(id bson.ObjectId, eventID bson.ObjectId) error
+	AddAssociationPost(id bson.ObjectId, postID bson.ObjectId) error
+	RemoveAssociationPost(id bson.ObjectId, postID bson.ObjectId) error
+	IncAssociationFollowers(id bson.ObjectId, delta int) error
+
+	InsertAssociationUser(user AssociationUser) error
+	// FindAssociationUser returns the main account of the association,
+	// the accounts created for its members are left out
+	FindAssociationUser(associationID bson.ObjectId) (AssociationUser, error)
+	FindAssociationUsersByUsername(username string) ([]AssociationUser, error)
+	FindAssociationUsersByOwner(owner bson.ObjectId) ([]AssociationUser, error)
+	FindAssociationUserByID(id bson.ObjectId) (AssociationUser, error)
+	UpdateAssociationUserPassword(id bson.ObjectId, password string, algorithm string) error
+	RemoveAssociationUser(id bson.ObjectId) error
+}
+
+// EventStore defines the persistence of Event
+type EventStore interface {
+	InsertEvent(event Event) error
+	FindEvent(id bson.ObjectId) (Event, error)
+	FindEventsEndingAfter(date time.Time, page Page) (Events, error)
+	// FindEventsForAssociations finds the last created events, ending after the given
+	// date, of the given associations or of every association if they are nil
+	FindEventsForAssociations(associations []bson.ObjectId, date time.Time, page Page) (Events, error)
+	UpdateEvent(id bson.ObjectId, event Event) error
+	RemoveEvent(id bson.ObjectId) error
+	AddEventParticipant(id bson.ObjectId, userID bson.ObjectId) error
+	RemoveEventParticipant(id bson.ObjectId, userID bson.ObjectId) error
+}
+
+// PostStore defines the persistence of Post and their Comments
+type PostStore interface {
+	InsertPost(post Post) error
+	FindPost(id bson.ObjectId) (Post, error)
+	FindPosts() (Posts, error)
+	FindLatestPosts(page Page) (Posts, error)
+	// FindPostsForAssociations finds the latest posts of the given
+	// associations, or of every association if they are nil
+	FindPostsForAssociations(associations []bson.ObjectId, page Page) (Posts, error)
+	UpdatePost(id bson.ObjectId, post Post) error
+	RemovePost(id bson.ObjectId) error
+	AddPostLike(id bson.ObjectId, userID bson.ObjectId) error
+	RemovePostLike(id bson.ObjectId, userID bson.ObjectId) error
+	AddPostComment(id bson.ObjectId, comment Comment) error
+	RemovePostComment(id bson.ObjectId, commentID bson.ObjectId) error
+	SetPostComments(id bson.ObjectId, comments Comments) error
+}
+
+// CredentialsStore defines the persistence of the Credentials of the users
+type CredentialsStore interface {
+	InsertCredentials(credentials Credentials) error
+	FindCredentials(username string, authToken string) (Credentials, error)
+	FindCredentialsForDevice(username string, device string) (Credentials, error)
+	FindCredentialsForUser(userID bson.ObjectId) ([]Credentials, error)
+	SetCredentialsLastUsed(id bson.ObjectId, date time.Time) error
+	RemoveCredentials(id bson.ObjectId) error
+	RemoveCredentialsForUser(userID bson.ObjectId) error
+}
+
+// NotificationStore defines the persistence of Notification
+// and of the NotificationUser (the push tokens of the devices).
+// UpsertNotificationUser registers a device by its token, removing the
+// registrations of the token by other users. SetNotificationsDelivery
+// never changes the delivery of a notification already sent
+type NotificationStore interface {
+	UpsertNotificationUser(user NotificationUser) error
+	FindNotificationUsersForUser(userID bson.ObjectId) ([]NotificationUser, error)
+	FindNotificationUsersForUsers(userIDs []bson.ObjectId) ([]NotificationUser, error)
+	RemoveNotificationUsersForUser(userID bson.ObjectId) error
+	RemoveNotificationUsersForTokens(tokens []string) error
+
+	// FindNotificationPreferences finds the preferences of the given
+	// users, leaving out the ones who have not set any
+	FindNotificationPreferences(userIDs []bson.ObjectId) ([]NotificationPreferences, error)
+	UpsertNotificationPreferences(preferences NotificationPreferences) error
+	RemoveNotificationPreferences(userID bson.ObjectId) error
+
+	InsertNotification(notification Notification) error
+	FindNotifications(receiver bson.ObjectId, filter NotificationFilter, page Page) (Notifications, error)
+	CountNotifications(receiver bson.ObjectId, unreadOnly bool) (int, error)
+	// SetNotificationSeen marks the notification as seen. It returns
+	// ErrNotFound if it does not exist or has another receiver
+	SetNotificationSeen(receiver bson.ObjectId, id bson.ObjectId) error
+	SetNotificationsSeen(receiver bson.ObjectId) error
+	// RemoveNotification removes the notification. It returns
+	// ErrNotFound if it does not exist or has another receiver
+	RemoveNotification(receiver bson.ObjectId, id bson.ObjectId) error
+	// UpsertAggregatedNotification adds the sender of the notification to the
+	// Actors of the one of the same AggregationKey, updating its sender,
+	// comment, text, date and marking it unread, or inserts it. It returns
+	// the notification before the update, or ErrNotFound if inserted
+	UpsertAggregatedNotification(notification Notification) (Notification, error)
+	// FindAggregatedNotifications finds the aggregated notifications of the content
+	FindAggregatedNotifications(content bson.ObjectId) (Notifications, error)
+	// UpdateAggregatedNotification replaces the sender, comment, text and
+	// actors of the aggregated notification, leaving it read or unread
+	UpdateAggregatedNotification(notification Notification) error
+	SetNotificationsDelivery(ids []bson.ObjectId, status string) error
+	RemoveNotificationsForReceiver(receiver bson.ObjectId) error
+	RemoveNotificationsForContent(content bson.ObjectId) error
+	// RemoveNotificationsForComment removes the notifications of the
+	// comment, but the aggregated ones which count other comments
+	RemoveNotificationsForComment(commentID bson.ObjectId) error
+}
+
+// PushJobStore defines the persistence of the PushJob, the outbox of the pushes
+type PushJobStore interface {
+	InsertPushJobs(jobs []PushJob) error
+	// ClaimPushJob marks the pending job due the soonest as sending until
+	// now plus the given lease, with a new Lease token, and returns it. A
+	// sending job whose lease is over can be claimed again. It returns
+	// ErrNotFound if no job is due
+	ClaimPushJob(now time.Time, lease time.Duration) (PushJob, error)
+	// UpdatePushJob updates the job if it is still claimed with its Lease
+	// token, and clears the token. It returns ErrNotFound otherwise
+	UpdatePushJob(job PushJob) error
+}
+
+// SessionTokenStore defines the persistence of the SessionToken
+// used to authenticate the requests
+type SessionTokenStore interface {
+	InsertSessionToken(token SessionToken) error
+	FindSessionToken(token string) (SessionToken, error)
+	RemoveSessionToken(token string) error
+	RemoveSessionTokensForOwner(owner string) error
+	RemoveSessionTokensForCredentials(credentialsID bson.ObjectId) error
+	RemoveSessionTokensForAssociation(associationID bson.ObjectId) error
+	RemoveSessionTokensForMember(associationID bson.ObjectId, owner string) error
+	RemoveSessionTokensExpiredBefore(date time.Time) (int, error)
+}
+
+// PasswordResetStore defines the persistence of the PasswordReset
+// tokens sent by email to the association accounts
+type PasswordResetStore interface {
+	InsertPasswordReset(reset PasswordReset) error
+	FindPasswordReset(token string) (PasswordReset, error)
+	// UsePasswordReset marks the reset as used. It returns ErrNotFound
+	// if it does not exist or has already been used
+	UsePasswordReset(id bson.ObjectId) error
+	RemovePasswordResetsForUser(userID bson.ObjectId) error
+}
+
+// AssociationMemberStore defines the persistence of the AssociationMember
+// of the associations and of the AssociationActivity of these members
+type AssociationMemberStore interface {
+	InsertAssociationMember(member AssociationMember) error
+	FindAssociationMember(id bson.ObjectId) (AssociationMember, error)
+	FindAssociationMemberByToken(token string) (AssociationMember, error)
+	FindAssociationMembers(associationID bson.ObjectId) ([]AssociationMember, error)
+	FindAssociationMembersForUser(userID bson.ObjectId) ([]AssociationMember, error)
+	// RespondAssociationMember sets the answer to a pending invitation. It returns
+	// ErrNotFound if it does not exist or has already been answered
+	RespondAssociationMember(id bson.ObjectId, status string, account bson.ObjectId, date time.Time) error
+	RemoveAssociationMember(id bson.ObjectId) error
+
+	InsertAssociationActivity(activity AssociationActivity) error
+	FindAssociationActivities(associationID bson.ObjectId, limit int) ([]AssociationActivity, error)
+}
+
+// DigestStore defines the persistence of DigestSubscription and DigestRecord.
+// InsertDigestRecord returns ErrDuplicate if the user already has a record of the week
+type DigestStore interface {
+	InsertDigestSubscription(subscription DigestSubscription) error
+	FindDigestSubscription(userID bson.ObjectId) (DigestSubscription, error)
+	FindDigestSubscriptionByToken(token string) (DigestSubscription, error)
+	FindDigestSubscriptions() ([]DigestSubscription, error)
+	RemoveDigestSubscription(userID bson.ObjectId) error
+
+	InsertDigestRecord(record DigestRecord) error
+	FindLastDigestRecord(userID bson.ObjectId) (DigestRecord, error)
+	RemoveDigestRecord(id bson.ObjectId) error
+}
+
+// BroadcastStore defines the persistence of Broadcast. FindBroadcasts finds
+// the broadcasts of the given sender, or every one if empty, latest first.
+// ClaimDueBroadcast marks the first scheduled broadcast due at the given date
+// as sending and returns it, or ErrNotFound if none is due. CancelBroadcast
+// returns ErrNotFound if the broadcast is not scheduled anymore.
+// CountBroadcastDeliveries counts the notifications of a broadcast
+// by delivery, and the ones read
+type BroadcastStore interface {
+	InsertBroadcast(broadcast Broadcast) error
+	FindBroadcast(id bson.ObjectId) (Broadcast, error)
+	FindBroadcasts(sender bson.ObjectId, page Page) (Broadcasts, error)
+	ClaimDueBroadcast(now time.Time) (Broadcast, error)
+	UpdateBroadcast(broadcast Broadcast) error
+	CancelBroadcast(id bson.ObjectId) error
+	CountBroadcastDeliveries(id bson.ObjectId) (BroadcastStats, error)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Types of the StreamMessage
+const (
+	StreamReady        = "ready"
+	StreamNotification = "notification"
+	StreamUnread       = "unread"
+	StreamPost         = "post"
+	StreamEvent        = "event"
+)
+
+const (
+	// streamBuffer is how many messages a stream holds for a slow client
+	// before being closed, the client reconnecting to catch up
+	streamBuffer = 32
+	// maxWatched is how many posts and events a stream follows at most
+	maxWatched = 50
+)
+
+// StreamMessage is a message of the real-time stream of a user: a new
+// Notification, the number of unread notifications, or the Post or
+// Event watched by the stream after a like, a comment or a participant
+type StreamMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// Stream is an open connection of a user to the real-time stream.
+// Watched are the posts and events open in the app, of which the
+// changes are streamed. Closed is closed when the client is too slow
+type Stream struct {
+	ID       bson.ObjectId
+	User     bson.ObjectId
+	Messages chan StreamMessage
+	Closed   chan struct{}
+
+	once    sync.Once
+	watched map[bson.ObjectId]bool
+}
+
+// streams are the open Stream of this process, by their ID. The messages
+// are only published to the streams of the process handling the change:
+// the real-time stream supports a single instance of the API. With more
+// instances, a client would miss the changes made through the others
+// until it reloads the inbox or the content it watches
+var streams = struct {
+	sync.RWMutex
+	open map[bson.ObjectId]*Stream
+}{open: map[bson.ObjectId]*Stream{}}
+
+// OpenStream will open a Stream of the given user watching the given contents
+func OpenStream(userID bson.ObjectId, watched []bson.ObjectId) (*Stream, error) {
+	stream := &Stream{
+		ID:       bson.NewObjectId(),
+		User:     userID,
+		Messages: make(chan StreamMessage, streamBuffer),
+		Closed:   make(chan struct{}),
+	}
+	if err := stream.watch(watched); err != nil {
+		return nil, err
+	}
+	streams.Lock()
+	streams.open[stream.ID] = stream
+	streams.Unlock()
+	stream.send(StreamMessage{Type: StreamReady, Data: bson.M{"stream": stream.ID}})
+	if count, err := GetUnreadCount(userID); err == nil {
+		stream.send(StreamMessage{Type: StreamUnread, Data: bson.M{"count": count}})
+	}
+	return stream, nil
+}
+
+// CloseStream will stop publishing to the given Stream
+func CloseStream(stream *Stream) {
+	streams.Lock()
+	delete(streams.open, stream.ID)
+	streams.Unlock()
+}
+
+// WatchContents will replace the posts and events watched by the
+// given Stream of the user. It returns ErrNotFound if it is closed
+func WatchContents(userID bson.ObjectId, streamID bson.ObjectId, watched []bson.ObjectId) error {
+	streams.RLock()
+	stream, ok := streams.open[streamID]
+	streams.RUnlock()
+	if !ok || stream.User != userID {
+		return ErrNotFound
+	}
+	return stream.watch(watched)
+}
+
+func (s *Stream) watch(contents []bson.ObjectId) error {
+	if len(contents) > maxWatched {
+		return ValidationError("Trop de contenus suivis")
+	}
+	watched := map[bson.ObjectId]bool{}
+	for _, id := range contents {
+		watched[id] = true
+	}
+	streams.Lock()
+	s.watched = watched
+	streams.Unlock()
+	return nil
+}
+
+// send queues the given message, closing the stream if its client is too slow
+func (s *Stream) send(message StreamMessage) {
+	select {
+	case s.Messages <- message:
+	default:
+		s.once.Do(func() {
+			log.Println("[error] Closing the slow stream", s.ID.Hex(), "of", s.User.Hex())
+			close(s.Closed)
+		})
+	}
+}
+
+// publish will send the given message to the streams matching accept
+func publish(message StreamMessage, accept func(stream *Stream) bool) {
+	streams.RLock()
+	defer streams.RUnlock()
+	for _, stream := range streams.open {
+		if accept(stream) {
+			stream.send(message)
+		}
+	}
+}
+
+// publishNotification will stream the given new notification and the
+// number of unread notifications to its receiver
+func publishNotification(notification Notification) {
+	if !hasStream(notification.Receiver) {
+		return
+	}
+	notification = notification.In(userLocale(notification.Receiver))
+	publish(StreamMessage{Type: StreamNotification, Data: notification}, func(stream *Stream) bool {
+		return stream.User == notification.Receiver
+	})
+	if count, err := GetUnreadCount(notification.Receiver); err == nil {
+		publishUnread(notification.Receiver, count)
+	}
+}
+
+// hasStream returns whether the given user has an open stream
+func hasStream(userID bson.ObjectId) bool {
+	streams.RLock()
+	defer streams.RUnlock()
+	for _, stream := range streams.open {
+		if stream.User == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// publishUnread will stream the given number of unread notifications to the given user
+func publishUnread(userID bson.ObjectId, count int) {
+	publish(StreamMessage{Type: StreamUnread, Data: bson.M{"count": count}}, func(stream *Stream) bool {
+		return stream.User == userID
+	})
+}
+
+// publishPost will stream the given post to the streams watching it
+func publishPost(post Post) {
+	publish(StreamMessage{Type: StreamPost, Data: post}, func(stream *Stream) bool {
+		return stream.watched[post.ID]
+	})
+}
+
+// publishEvent will stream the given event to the streams watching it
+func publishEvent(event Event) {
+	publish(StreamMessage{Type: StreamEvent, Data: event}, func(stream *Stream) bool {
+		return stream.watched[event.ID]
+	})
+}
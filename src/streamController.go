@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// streamHeartbeat is how often a comment is written on an idle
+// stream, to keep the proxies from closing the connection
+const streamHeartbeat = 25 * time.Second
+
+// StreamController will stream the StreamMessage of the user as
+// Server-Sent Events, until the client disconnects. The "watch" query
+// parameter lists the ids of the posts and events open in the app
+func StreamController(w http.ResponseWriter, r *http.Request) {
+	userID := bson.ObjectIdHex(mux.Vars(r)["userID"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, ErrInternal)
+		return
+	}
+	var watched []bson.ObjectId
+	if value := r.URL.Query().Get("watch"); value != "" {
+		for _, id := range strings.Split(value, ",") {
+			if !bson.IsObjectIdHex(id) {
+				WriteError(w, ErrBadRequest)
+				return
+			}
+			watched = append(watched, bson.ObjectIdHex(id))
+		}
+	}
+	stream, err := OpenStream(userID, watched)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	defer CloseStream(stream)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case message := <-stream.Messages:
+			data, _ := json.Marshal(message.Data)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Type, data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-stream.Closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// WatchController will replace the posts and events watched by
+// the stream of the user by the ones of the JSON body
+func WatchController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := bson.ObjectIdHex(vars["userID"])
+	if !VerifyUserRequest(r, userID) {
+		Forbidden(w)
+		return
+	}
+	var body struct {
+		Watch []bson.ObjectId `json:"watch"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	if err := WatchContents(userID, bson.ObjectIdHex(vars["streamID"]), body.Watch); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(bson.M{"watch": body.Watch})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// expectStreamMessage fails if the next message of the stream is not of the given type
+func expectStreamMessage(t *testing.T, stream *Stream, messageType string) StreamMessage {
+	t.Helper()
+	select {
+	case message := <-stream.Messages:
+		if message.Type != messageType {
+			t.Fatalf("expected a %s message, got %+v", messageType, message)
+		}
+		return message
+	default:
+		t.Fatalf("expected a %s message", messageType)
+	}
+	return StreamMessage{}
+}
+
+func TestStreamPublishesToUserAndWatchers(t *testing.T) {
+	newTestStore()
+	association := newTestAssociation(t, "BDE")
+	post, err := AddPost(Post{Title: "Soirée", Association: association.ID, Date: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _ := newTestUser(t, "alice")
+	bob, _ := newTestUser(t, "bob")
+	stream, err := OpenStream(alice.ID, []bson.ObjectId{post.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseStream(stream)
+	expectStreamMessage(t, stream, StreamReady)
+	expectStreamMessage(t, stream, StreamUnread)
+
+	if _, err := AddNotification(Notification{Receiver: bob.ID, Type: "tag"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.Messages) != 0 {
+		t.Fatal("expected the notifications of bob not to be streamed to alice")
+	}
+	notification, err := AddNotification(Notification{Receiver: alice.ID, Type: "tag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := expectStreamMessage(t, stream, StreamNotification); message.Data.(Notification).ID != notification.ID {
+		t.Fatalf("expected the notification, got %+v", message)
+	}
+	if message := expectStreamMessage(t, stream, StreamUnread); message.Data.(bson.M)["count"] != 1 {
+		t.Fatalf("expected 1 unread notification, got %+v", message)
+	}
+
+	if _, _, err := LikePostWithUser(post.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectStreamMessage(t, stream, StreamPost)
+	if err := WatchContents(bob.ID, stream.ID, nil); err != ErrNotFound {
+		t.Fatalf("expected the stream of another user not to be changed, got %v", err)
+	}
+	if err := WatchContents(alice.ID, stream.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	LikePostWithUser(post.ID, alice.ID)
+	if len(stream.Messages) != 0 {
+		t.Fatal("expected a post no longer watched not to be streamed")
+	}
+	if err := WatchContents(alice.ID, stream.ID, make([]bson.ObjectId, maxWatched+1)); err == nil {
+		t.Fatal("expected too many watched contents to be refused")
+	}
+}
+
+func TestStreamClosesSlowClient(t *testing.T) {
+	newTestStore()
+	alice, _ := newTestUser(t, "alice")
+	stream, err := OpenStream(alice.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseStream(stream)
+	for i := 0; i < streamBuffer; i++ {
+		publishUnread(alice.ID, 0)
+	}
+	select {
+	case <-stream.Closed:
+	default:
+		t.Fatal("expected the stream to be closed once its buffer is full")
+	}
+}
+
+func TestStreamController(t *testing.T) {
+	newTestStore()
+	alice, token := newTestUser(t, "alice")
+	bob, _ := newTestUser(t, "bob")
+	server := httptest.NewServer(NewRouter())
+	defer server.Close()
+
+	expectError(t, serveTestRequest("GET", "/notification/"+bob.ID.Hex()+"/stream", "", token), http.StatusForbidden, ErrForbidden.Code)
+	expectError(t, serveTestRequest("GET", "/notification/"+alice.ID.Hex()+"/stream?watch=nope", "", token), http.StatusBadRequest, ErrBadRequest.Code)
+
+	r, _ := http.NewRequest("GET", server.URL+"/notification/"+alice.ID.Hex()+"/stream", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	resp, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	events := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "event: ") {
+				events <- strings.TrimPrefix(line, "event: ")
+			}
+		}
+		close(events)
+	}()
+	expect := func(event string) {
+		t.Helper()
+		select {
+		case received := <-events:
+			if received != event {
+				t.Fatalf("expected a %s event, got %s", event, received)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a %s event", event)
+		}
+	}
+	expect(StreamReady)
+	expect(StreamUnread)
+	if _, err := AddNotification(Notification{Receiver: alice.ID, Type: "tag"}); err != nil {
+		t.Fatal(err)
+	}
+	expect(StreamNotification)
+	expect(StreamUnread)
+}
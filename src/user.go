@@ -1,7 +1,6 @@
 package main
 
 import (
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"time"
 	"strings"
@@ -24,34 +23,38 @@ type User struct {
 	Email       string          `json:"email"`
 	EmailPublic bool            `json:"emailpublic"`
 	Promotion   string          `json:"promotion"`
+	Department  string          `json:"department"`
 	Gender 			string					`json:"gender"`
 	Events      []bson.ObjectId `json:"events"`
 	PostsLiked  []bson.ObjectId `json:"postsliked"`
+	Roles       []Role          `json:"roles" bson:"roles,omitempty"`
+	Following   []bson.ObjectId `json:"following" bson:"following,omitempty"`
+	// Locale is the language of the notifications and emails of the user
+	Locale      string          `json:"locale" bson:"locale,omitempty"`
 }
 
 // Users is an array of User
 type Users []User
 
 // AddUser will add the given user from JSON body to the database
-func AddUser(user User) User {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
+func AddUser(user User) (User, error) {
+	user.ID = bson.NewObjectId()
 	user.Username = strings.ToLower(user.Username)
-	db.Insert(user)
-	var result User
-	db.Find(bson.M{"username": strings.ToLower(user.Username) }).One(&result)
-	return result
+	if len(user.Username) == 0 {
+		return User{}, ValidationError("Identifiant manquant")
+	}
+	if _, err := store.FindUserByUsername(user.Username); err == nil {
+		return User{}, ConflictError("Cet identifiant est déjà utilisé")
+	}
+	if err := store.InsertUser(user); err != nil {
+		return User{}, err
+	}
+	return store.FindUserByUsername(user.Username)
 }
 
 // UpdateUser will update the user link to the given ID,
 // with the field of the given user, in the database
-func UpdateUser(id bson.ObjectId, user User) User {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
+func UpdateUser(id bson.ObjectId, user User) (User, error) {
 	promotion := ""
 	for _, promo := range promotions {
 		if user.Promotion == promo {
@@ -66,156 +69,133 @@ func UpdateUser(id bson.ObjectId, user User) User {
 			break
 		}
 	}
-	userID := bson.M{"_id": id}
-	change := bson.M{"$set": bson.M{
-		"name":        user.Name,
-		"description": user.Description,
-		"email": 			 user.Email,
-		"emailpublic": user.EmailPublic,
-		"promotion":   promotion,
-		"gender"	:		 gender,
-	}}
-	db.Update(userID, change)
-	var result User
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
+	user.Promotion = promotion
+	user.Gender = gender
+	if user.Locale != "" {
+		if user.Locale = supportedLocale(user.Locale); user.Locale == "" {
+			return User{}, ValidationError("Langue non prise en charge")
+		}
+	}
+	if err := store.UpdateUser(id, user); err != nil {
+		return User{}, err
+	}
+	return store.FindUser(id)
 }
 
 // DeleteUser will delete the given user from the database
-func DeleteUser(user User) User {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
+func DeleteUser(user User) error {
 	DeleteCredentialsForUser(user.ID)
+	RevokeSessionTokensForOwner(user.ID)
+	DeleteMembershipsForUser(user.ID)
 	DeleteNotificationsForUser(user.ID)
 	DeleteNotificationTokenForUser(user.ID)
+	DeleteNotificationPreferencesForUser(user.ID)
+	UnsubscribeFromDigest(user.ID)
 	for _, eventId := range user.Events{
-		RemoveParticipant(eventId, user.ID)
+		if _, _, err := RemoveParticipant(eventId, user.ID); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	for _, associationId := range user.Following {
+		if _, _, err := UnfollowAssociation(associationId, user.ID); err != nil && err != ErrNotFound {
+			return err
+		}
 	}
 	for _, postId := range user.PostsLiked{
-		DislikePostWithUser(postId, user.ID)
-	}
-	DeleteTagsForUser(user.ID)
-	DeleteCommentsForUser(user.ID)
-	db.RemoveId(user.ID)
-	var result User
-	db.FindId(user.ID).One(result)
-	return result
+		if _, _, err := DislikePostWithUser(postId, user.ID); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	if err := DeleteTagsForUser(user.ID); err != nil {
+		return err
+	}
+	if err := DeleteCommentsForUser(user.ID); err != nil {
+		return err
+	}
+	return store.RemoveUser(user.ID)
 }
 
-// GetUser will return an User object from the given ID
-func GetAllUser() Users {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
-	var result Users
-	db.Find(bson.M{}).All(&result)
-	return result
+// GetAllUser will return the given page of the users
+// and the cursor of the next page if there is one
+func GetAllUser(page Page) (Users, *Cursor, error) {
+	users, err := store.FindUsers(page.peek())
+	return usersPage(users, page, err)
 }
 
 // GetUser will return an User object from the given ID
-func GetUser(id bson.ObjectId) User {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
-	var result User
-	db.FindId(id).One(&result)
-	return result
+func GetUser(id bson.ObjectId) (User, error) {
+	return store.FindUser(id)
+}
+
+// GetUserByUsername will return the User object with the given username
+func GetUserByUsername(username string) (User, error) {
+	return store.FindUserByUsername(username)
 }
 
 // LikePost will add the postID to the list of liked post
 // of the user linked to the given id
-func LikePost(id bson.ObjectId, postID bson.ObjectId) User {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
-	userID := bson.M{"_id": id}
-	change := bson.M{"$addToSet": bson.M{
-		"postsliked": postID,
-	}}
-	db.Update(userID, change)
-	var result User
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
+func LikePost(id bson.ObjectId, postID bson.ObjectId) (User, error) {
+	if err := store.AddUserLike(id, postID); err != nil {
+		return User{}, err
+	}
+	return store.FindUser(id)
 }
 
 // DislikePost will remove the postID from the list of liked
 // post of the user linked to the given id
-func DislikePost(id bson.ObjectId, postID bson.ObjectId) User {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
-	userID := bson.M{"_id": id}
-	change := bson.M{"$pull": bson.M{
-		"postsliked": postID,
-	}}
-	db.Update(userID, change)
-	var result User
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
+func DislikePost(id bson.ObjectId, postID bson.ObjectId) (User, error) {
+	if err := store.RemoveUserLike(id, postID); err != nil {
+		return User{}, err
+	}
+	return store.FindUser(id)
 }
 
 // AddEventToUser will add the eventID to the list
 // of the user's event linked to the given id
-func AddEventToUser(id bson.ObjectId, eventID bson.ObjectId) User {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
-	userID := bson.M{"_id": id}
-	change := bson.M{"$addToSet": bson.M{
-		"events": eventID,
-	}}
-	db.Update(userID, change)
-	var result User
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
+func AddEventToUser(id bson.ObjectId, eventID bson.ObjectId) (User, error) {
+	if err := store.AddUserEvent(id, eventID); err != nil {
+		return User{}, err
+	}
+	return store.FindUser(id)
 }
 
 // RemoveEventFromUser will remove the eventID from the list
 // of the user's event linked to the given id
-func RemoveEventFromUser(id bson.ObjectId, eventID bson.ObjectId) User {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
-	userID := bson.M{"_id": id}
-	change := bson.M{"$pull": bson.M{
-		"events": eventID,
-	}}
-	db.Update(userID, change)
-	var result User
-	db.Find(bson.M{"_id": id}).One(&result)
-	return result
+func RemoveEventFromUser(id bson.ObjectId, eventID bson.ObjectId) (User, error) {
+	if err := store.RemoveUserEvent(id, eventID); err != nil {
+		return User{}, err
+	}
+	return store.FindUser(id)
+}
+
+// SearchUser will return the given page of the users whose username
+// or name contains the given string, and the cursor of the next page
+func SearchUser(username string, page Page) (Users, *Cursor, error) {
+	users, err := store.SearchUsers(username, page.peek())
+	return usersPage(users, page, err)
 }
 
-func SearchUser(username string) Users {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
-	var result Users
-	db.Find(bson.M{"$or" : []interface{}{
-		bson.M{"username" : bson.M{ "$regex" : bson.RegEx{`^.*` + username + `.*`, "i"}}}, bson.M{"name" : bson.M{ "$regex" : bson.RegEx{`^.*` + username + `.*`, "i"}}}}}).All(&result)
-	return result
+// usersPage returns the users fetched for the given page and the cursor of the next one
+func usersPage(users Users, page Page, err error) (Users, *Cursor, error) {
+	if err != nil || !page.more(len(users)) {
+		return users, nil, err
+	}
+	users = users[:page.Limit]
+	return users, &Cursor{ID: users[len(users)-1].ID}, nil
 }
 
-func ReportUser(id bson.ObjectId, reporterID bson.ObjectId) {
-	session, _ := mgo.Dial("127.0.0.1")
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	db := session.DB("insapp").C("user")
-	var user User
-	db.Find(bson.M{"_id": id}).One(&user)
-	var reporter User
-	db.Find(bson.M{"_id": reporterID}).One(&reporter)
+func ReportUser(id bson.ObjectId, reporterID bson.ObjectId) error {
+	user, err := store.FindUser(id)
+	if err != nil {
+		return err
+	}
+	reporter, err := store.FindUser(reporterID)
+	if err != nil {
+		return err
+	}
 	SendEmail("aeir@insa-rennes.fr", "Un utilisateur a été reporté sur Insapp",
 		"Cet utilisateur a été reporté le " + time.Now().String() +
 		"\n\nReporteur:\n" + reporter.ID.Hex() + "\n" + reporter.Username + "\n" + reporter.Name +
 		"\n\nSignaler:\n" + user.ID.Hex() + "\n" + user.Username + "\n" + user.Name + "\n" + user.Description)
+	return nil
 }
@@ -5,7 +5,6 @@ import (
 	"net/http"
 
 	"gopkg.in/mgo.v2/bson"
-	"github.com/freehaha/token-auth"
 	"github.com/gorilla/mux"
 )
 
@@ -14,12 +13,27 @@ import (
 func GetUserController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["id"]
-	var res = GetUser(bson.ObjectIdHex(userID))
+	res, err := GetUser(bson.ObjectIdHex(userID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(res)
 }
 
+// GetAllUserController will answer a JSON of a page of the users
 func GetAllUserController(w http.ResponseWriter, r *http.Request) {
-	var res = GetAllUser()
+	page, err := ParsePage(r, 50)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	res, next, err := GetAllUser(page)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	SetNextLink(w, r, page, next)
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -28,8 +42,15 @@ func GetAllUserController(w http.ResponseWriter, r *http.Request) {
 func AddUserController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var user User
-	decoder.Decode(&user)
-	res := AddUser(user)
+	if err := decoder.Decode(&user); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	res, err := AddUser(user)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -38,17 +59,23 @@ func AddUserController(w http.ResponseWriter, r *http.Request) {
 func UpdateUserController(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var user User
-	decoder.Decode(&user)
+	if err := decoder.Decode(&user); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
 	vars := mux.Vars(r)
 	userID := vars["id"]
 	isValidUser := VerifyUserRequest(r, bson.ObjectIdHex(userID))
-	isValidAssociation := VerifyAssociationRequest(r, bson.ObjectIdHex(userID))
-	if !isValidUser && !isValidAssociation {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+	isValidManager := GetPrincipal(r).Can(PermissionManageUsers)
+	if !isValidUser && !isValidManager {
+		Forbidden(w)
+		return
+	}
+	res, err := UpdateUser(bson.ObjectIdHex(userID), user)
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
-	res := UpdateUser(bson.ObjectIdHex(userID), user)
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -58,34 +85,80 @@ func DeleteUserController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["id"]
 	isUserValid := VerifyUserRequest(r, bson.ObjectIdHex(userID))
-	isAssociationValid := VerifyAssociationRequest(r, bson.ObjectIdHex(userID))
-	if !isUserValid && !isAssociationValid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(bson.M{"error": "Contenu Protégé"})
+	isManagerValid := GetPrincipal(r).Can(PermissionManageUsers)
+	if !isUserValid && !isManagerValid {
+		Forbidden(w)
 		return
 	}
-	user := GetUser(bson.ObjectIdHex(userID))
-	res := DeleteUser(user)
-	json.NewEncoder(w).Encode(res)
+	user, err := GetUser(bson.ObjectIdHex(userID))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := DeleteUser(user); err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(User{})
 }
 
+// SearchUserController will answer a JSON of a page of the users
+// whose username or name contains the given one
 func SearchUserController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	users := SearchUser(vars["username"])
+	page, err := ParsePage(r, 50)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	users, next, err := SearchUser(vars["username"], page)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	SetNextLink(w, r, page, next)
 	json.NewEncoder(w).Encode(bson.M{"users": users})
 }
 
 func ReportUserController(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["id"]
-	token := tauth.Get(r)
-	reporterID := token.Claims("id").(string)
-	ReportUser(bson.ObjectIdHex(userID), bson.ObjectIdHex(reporterID))
+	reporterID := GetPrincipal(r).ID
+	if err := ReportUser(bson.ObjectIdHex(userID), reporterID); err != nil {
+		WriteError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(bson.M{})
 }
 
+// SetUserRolesController lets a super admin replace the roles of the user
+// linked to the given id (from the JSON Body), logging it out
+func SetUserRolesController(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["id"]
+	decoder := json.NewDecoder(r.Body)
+	var roles struct {
+		Roles []Role `json:"roles"`
+	}
+	if err := decoder.Decode(&roles); err != nil {
+		WriteError(w, ErrBadRequest)
+		return
+	}
+	for _, role := range roles.Roles {
+		if !IsValidRole(role) {
+			WriteError(w, ValidationError("Rôle inconnu"))
+			return
+		}
+	}
+	res, err := SetUserRoles(bson.ObjectIdHex(userID), roles.Roles)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// VerifyUserRequest tells whether the request has been made by the given user
 func VerifyUserRequest(r *http.Request, userId bson.ObjectId) bool {
-	token := tauth.Get(r)
-	id := token.Claims("id").(string)
-	return bson.ObjectIdHex(id) == userId
+	return GetPrincipal(r).ID == userId
 }